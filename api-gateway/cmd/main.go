@@ -10,12 +10,22 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/0Bleak/api-gateway/internal/apikeys"
+	"github.com/0Bleak/api-gateway/internal/cache"
 	"github.com/0Bleak/api-gateway/internal/config"
 	"github.com/0Bleak/api-gateway/internal/discovery"
 	"github.com/0Bleak/api-gateway/internal/handlers"
+	"github.com/0Bleak/api-gateway/internal/messaging"
+	"github.com/0Bleak/api-gateway/internal/metrics"
 	"github.com/0Bleak/api-gateway/internal/middleware"
+	"github.com/0Bleak/api-gateway/internal/problem"
 	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/ratelimit"
+	"github.com/0Bleak/api-gateway/internal/requestid"
+	"github.com/0Bleak/api-gateway/internal/routes"
+	"github.com/0Bleak/api-gateway/internal/tracing"
 	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/cors"
 )
 
@@ -31,49 +41,253 @@ func run() error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// Set up tracing
+	shutdownTracing, err := tracing.Init(context.Background(), "api-gateway", "", cfg.OTLPEndpoint, cfg.TraceSampleRatio)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
 	consulClient, err := discovery.NewConsulClient(cfg.ConsulAddr)
 	if err != nil {
 		return fmt.Errorf("failed to create consul client: %w", err)
 	}
 
-	loadBalancer := proxy.NewLoadBalancer(consulClient)
-	proxyHandler := handlers.NewProxyHandler(loadBalancer)
+	loadBalancer := proxy.NewLoadBalancer(consulClient, proxy.OutlierSettings{
+		ConsecutiveErrors:  cfg.Outlier.ConsecutiveErrors,
+		ErrorRate:          cfg.Outlier.ErrorRate,
+		MinRequests:        cfg.Outlier.MinRequests,
+		Interval:           cfg.Outlier.Interval,
+		LatencyThreshold:   cfg.Outlier.LatencyThreshold,
+		EjectionTime:       cfg.Outlier.EjectionTime,
+		MaxEjectionPercent: cfg.Outlier.MaxEjectionPercent,
+		SlowStart:          cfg.Outlier.SlowStart,
+	})
+	defer loadBalancer.Close()
+	breakers := proxy.NewBreakerRegistry(proxy.BreakerSettings{
+		FailureThreshold: cfg.BreakerThreshold,
+		OpenTimeout:      cfg.BreakerOpenTimeout,
+		HalfOpenRequests: cfg.BreakerHalfOpenCalls,
+	})
+	retryPolicy := proxy.RetryPolicy{
+		MaxAttempts: cfg.RetryMaxAttempts,
+		Backoff:     cfg.RetryBackoff,
+		MaxBackoff:  time.Second,
+	}
+	proxyHandler := handlers.NewProxyHandler(loadBalancer, breakers, retryPolicy, cfg.UpstreamTimeout, cfg.MirrorMaxInflight)
+	orderDetailsHandler := handlers.NewOrderDetailsHandler(loadBalancer, breakers, retryPolicy, cfg.UpstreamTimeout)
+
+	responseCache := cache.New(int64(cfg.CacheMaxBytes))
+
+	if err := metrics.RegisterGatewayState(loadBalancer, breakers); err != nil {
+		return fmt.Errorf("failed to register metrics: %w", err)
+	}
+	if err := metrics.RegisterCacheStats(responseCache.Stats); err != nil {
+		return fmt.Errorf("failed to register metrics: %w", err)
+	}
+
+	keyVerifier := apikeys.NewVerifier(loadBalancer, cfg.APIKeyService, cfg.InternalToken, cfg.APIKeyCacheTTL)
+	authenticator := middleware.NewAuthenticator(cfg.JWTSecret, keyVerifier)
+
+	clientIP, err := middleware.NewClientIPResolver(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("failed to parse TRUSTED_PROXIES: %w", err)
+	}
+
+	var rateStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RedisAddr != "" {
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+		})
+		defer redisClient.Close()
+
+		pingCtx, pingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := redisClient.Ping(pingCtx).Err(); err != nil {
+			log.Printf("Redis at %s is unreachable, rate limits use local buckets until it is: %v", cfg.RedisAddr, err)
+		} else {
+			log.Printf("Rate limits shared through Redis at %s", cfg.RedisAddr)
+		}
+		pingCancel()
+		rateStore = ratelimit.NewRedisStore(redisClient)
+	}
+	limiter := middleware.NewRateLimiter(rateStore, clientIP)
+	// The global quota is checked before authentication, per client IP. API
+	// keys also get a budget of their own across every route: the key's
+	// quota, or the global one.
+	globalQuota := ratelimit.Quota{RequestsPerSecond: cfg.RateLimitRPS, Burst: cfg.RateLimitBurst}
+	limitAPIKeys := limiter.LimitAPIKeys("api-key", middleware.RatePolicy{Default: globalQuota})
+
+	routeTable := routes.NewRouter(func(route *routes.Route) http.Handler {
+		var handler http.Handler = proxyHandler.ProxyToRoute(route)
+		if route.Cache != nil {
+			handler = responseCache.Handler(route.Name, route.Cache.TTL, metrics.ObserveCache)(handler)
+		}
+		if route.RateLimit != nil {
+			policy := middleware.RatePolicy{
+				Default: ratelimit.Quota(route.RateLimit.Quota),
+				Tiers:   make(map[string]ratelimit.Quota, len(route.RateLimit.Tiers)),
+			}
+			for tier, quota := range route.RateLimit.Tiers {
+				policy.Tiers[tier] = ratelimit.Quota(quota)
+			}
+			handler = limiter.Limit("route:"+route.Name, policy)(handler)
+		}
+		handler = middleware.RequireScope(route.Name)(handler)
+		handler = limitAPIKeys(handler)
+		handler = authenticator.Authenticate(route.Public)(handler)
+		handler = metrics.Instrument(route.Name)(handler)
+		return tracing.Route(route.Name, route.Service)(handler)
+	})
+
+	statusHandler := handlers.NewStatusHandler(consulClient, loadBalancer, breakers, routeTable)
+	openAPIHandler := handlers.NewOpenAPIHandler(loadBalancer, routeTable, time.Minute)
+	adminHandler := handlers.NewAdminHandler(loadBalancer, breakers, routeTable, rateStore, responseCache)
+
+	var routeSource routes.Source
+	if cfg.RoutesConsulKey != "" {
+		routeSource = routes.NewConsulSource(consulClient, cfg.RoutesConsulKey)
+	} else {
+		routeSource = routes.NewFileSource(cfg.RoutesFile)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cacheEvents := messaging.NewTopicWatcher(cfg.KafkaBrokers, cfg.CacheConsumerGroup, func(ctx context.Context, topic, eventType string) {
+		metrics.ObserveInvalidation(topic, eventType)
+		if removed := responseCache.Invalidate(topic); removed > 0 {
+			log.Printf("Purged %d cached responses on %s from %s request_id=%s", removed, eventType, topic, requestid.FromContext(ctx))
+		}
+	})
+	defer cacheEvents.Close()
+
+	routeWatcher := routes.NewWatcher(routeSource, cfg.RoutesReloadInterval, routeTable.Load, func(table *routes.Table) {
+		services := make(map[string]proxy.ServiceConfig, len(table.Services))
+		for name, service := range table.Services {
+			if service == nil {
+				continue
+			}
+			config := proxy.ServiceConfig{
+				Strategy: proxy.StrategyConfig{
+					Name:   service.LoadBalancing.Strategy,
+					HashOn: service.LoadBalancing.HashOn,
+				},
+			}
+			if canary := service.Canary; canary != nil {
+				config.Canary = &proxy.CanaryConfig{
+					Version: canary.Version,
+					Weight:  canary.Weight,
+					Header:  canary.Header,
+					Cookie:  canary.Cookie,
+				}
+			}
+			services[name] = config
+		}
+		loadBalancer.Configure(services)
+	}, func(table *routes.Table) {
+		loadBalancer.WatchOnly(table.ServiceNames())
+	}, func(table *routes.Table) {
+		invalidations := make(map[string][]string)
+		for _, route := range table.Routes {
+			if route.Cache == nil {
+				continue
+			}
+			for _, topic := range route.Cache.InvalidatedBy {
+				invalidations[topic] = append(invalidations[topic], route.Name)
+			}
+		}
+		responseCache.SetInvalidations(invalidations)
+		for topic := range invalidations {
+			cacheEvents.Watch(topic)
+		}
+	})
+	if err := routeWatcher.Load(ctx); err != nil {
+		return fmt.Errorf("failed to load route table: %w", err)
+	}
+	go routeWatcher.Run(ctx)
 
 	router := mux.NewRouter()
+	router.NotFoundHandler = middleware.RequestID(http.HandlerFunc(problem.NotFound))
+	router.MethodNotAllowedHandler = middleware.RequestID(http.HandlerFunc(problem.MethodNotAllowed))
+	router.Use(middleware.RequestID)
 	router.Use(middleware.LoggingMiddleware)
-	router.Use(middleware.RateLimitMiddleware(100))
+	router.Use(limiter.Limit("global", middleware.RatePolicy{Default: globalQuota}))
 
 	// Health check
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"healthy"}`))
 	}).Methods(http.MethodGet)
+	router.HandleFunc("/health/ready", statusHandler.Ready).Methods(http.MethodGet)
 
-	// Jar Service routes
-	router.PathPrefix("/api/jars").Handler(proxyHandler.ProxyToService("jar-service"))
-
-	// User Service routes
-	router.PathPrefix("/api/users").Handler(proxyHandler.ProxyToService("user-service"))
-
-	// Order Service routes
-	router.PathPrefix("/api/orders").Handler(proxyHandler.ProxyToService("order-service"))
+	// Aggregated endpoints served by the gateway itself
+	orderDetails := authenticator.Authenticate(false)(limitAPIKeys(middleware.RequireScope("order-details")(http.HandlerFunc(orderDetailsHandler.GetOrderDetails))))
+	router.Handle("/api/orders/{id}/details", tracing.Route("order-details", "")(metrics.Instrument("order-details")(orderDetails))).Methods(http.MethodGet)
+	router.Handle("/api/openapi.json", tracing.Route("openapi", "")(metrics.Instrument("openapi")(http.HandlerFunc(openAPIHandler.ServeSpec)))).Methods(http.MethodGet)
 
-	// Inventory Service routes
-	router.PathPrefix("/api/inventory").Handler(proxyHandler.ProxyToService("inventory-service"))
-
-	// Payment Service routes
-	router.PathPrefix("/api/payments").Handler(proxyHandler.ProxyToService("payment-service"))
+	// Service routes, driven by the route table
+	router.PathPrefix("/api").Handler(routeTable)
 
+	// Browser scripts can only read exposed headers: ETag is sent back in
+	// If-Match and Link carries pagination cursors.
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{requestid.Header, "ETag", "Link", "X-Total-Count"},
 		AllowCredentials: true,
 	})
 
 	server := &http.Server{
 		Addr:         ":" + cfg.ServerPort,
-		Handler:      corsHandler.Handler(router),
+		Handler:      tracing.Middleware(corsHandler.Handler(router)),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	// Gateway runtime state, on its own listener and for admins only
+	adminRouter := mux.NewRouter()
+	adminRouter.NotFoundHandler = middleware.RequestID(http.HandlerFunc(problem.NotFound))
+	adminRouter.MethodNotAllowedHandler = middleware.RequestID(http.HandlerFunc(problem.MethodNotAllowed))
+	adminRouter.Use(middleware.RequestID)
+	adminRouter.Use(middleware.LoggingMiddleware)
+	adminRouter.Use(authenticator.Authenticate(false))
+	adminRouter.Use(middleware.RequireRole("admin"))
+	adminRouter.HandleFunc("/admin/status", statusHandler.Status).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/admin/routes", adminHandler.Routes).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/admin/services", adminHandler.Services).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/admin/services/{service}", adminHandler.Service).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/admin/services/{service}/refresh", adminHandler.RefreshService).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/admin/services/{service}/instances/{id}/drain", adminHandler.DrainInstance).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/admin/services/{service}/instances/{id}/drain", adminHandler.UndrainInstance).Methods(http.MethodDelete)
+	adminRouter.HandleFunc("/admin/circuit-breakers", adminHandler.CircuitBreakers).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/admin/rate-limits", adminHandler.RateLimitBucket).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/admin/cache", adminHandler.Cache).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/admin/cache/routes/{route}", adminHandler.PurgeCacheRoute).Methods(http.MethodDelete)
+
+	adminServer := &http.Server{
+		Addr:         ":" + cfg.AdminPort,
+		Handler:      adminRouter,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	// Prometheus metrics, on a listener that is only reachable by the scraper
+	metricsRouter := mux.NewRouter()
+	metricsRouter.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+
+	metricsServer := &http.Server{
+		Addr:         ":" + cfg.MetricsPort,
+		Handler:      metricsRouter,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -86,15 +300,35 @@ func run() error {
 		}
 	}()
 
+	go func() {
+		log.Printf("Admin API starting on port %s", cfg.AdminPort)
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Admin server failed: %v", err)
+		}
+	}()
+
+	go func() {
+		log.Printf("Metrics starting on port %s", cfg.MetricsPort)
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Metrics server failed: %v", err)
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Println("Shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer shutdownCancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	if err := adminServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Admin server forced to shutdown: %v", err)
+	}
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Metrics server forced to shutdown: %v", err)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
 		return fmt.Errorf("server forced to shutdown: %w", err)
 	}
 
@@ -0,0 +1,234 @@
+package apikeys
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/requestid"
+	"github.com/0Bleak/api-gateway/internal/tracing"
+)
+
+// ErrInvalidKey is returned for unknown, revoked and expired keys.
+var ErrInvalidKey = errors.New("invalid API key")
+
+const (
+	verifyPath = "/internal/api-keys/verify"
+	// internalTokenHeader carries the token user-service requires on its
+	// internal endpoints.
+	internalTokenHeader = "X-Internal-Token"
+	verifyTimeout       = 2 * time.Second
+	// maxCachedKeys bounds the cache of valid keys.
+	maxCachedKeys = 10000
+	// maxRejectedKeys bounds the separate cache of invalid keys, so that
+	// requests with made-up keys cannot crowd out the valid ones.
+	maxRejectedKeys = 1000
+)
+
+// Key is a verified API key and the user it acts as. Scopes name the routes
+// the key may call, as "route" or "route:METHOD".
+type Key struct {
+	ID                string
+	UserID            string
+	Email             string
+	Role              string
+	Scopes            []string
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// Allows reports whether the key is scoped for the method on the route.
+func (k *Key) Allows(route, method string) bool {
+	for _, scope := range k.Scopes {
+		name, scopeMethod, restricted := strings.Cut(scope, ":")
+		if name == route && (!restricted || scopeMethod == method) {
+			return true
+		}
+	}
+	return false
+}
+
+type cachedKey struct {
+	key       *Key
+	expiresAt time.Time
+}
+
+// Verifier resolves API keys through user-service, which stores them hashed.
+// Results, including rejections, are cached for ttl, so a revoked key stops
+// working within ttl and each key reaches user-service at most once per ttl.
+// Rejections are kept apart and, once their cache is full, replace each other.
+type Verifier struct {
+	service      string
+	token        string
+	loadBalancer *proxy.LoadBalancer
+	client       *http.Client
+	ttl          time.Duration
+
+	mu       sync.Mutex
+	cache    map[[sha256.Size]byte]cachedKey
+	rejected map[[sha256.Size]byte]time.Time
+}
+
+func NewVerifier(lb *proxy.LoadBalancer, service, token string, ttl time.Duration) *Verifier {
+	return &Verifier{
+		service:      service,
+		token:        token,
+		loadBalancer: lb,
+		client:       &http.Client{Timeout: verifyTimeout, Transport: tracing.Transport(http.DefaultTransport)},
+		ttl:          ttl,
+		cache:        make(map[[sha256.Size]byte]cachedKey),
+		rejected:     make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// Verify returns the key's identity, ErrInvalidKey, or an error when
+// user-service could not be asked.
+func (v *Verifier) Verify(ctx context.Context, key string) (*Key, error) {
+	// Only a hash of the key is kept in memory.
+	id := sha256.Sum256([]byte(key))
+	now := time.Now()
+
+	v.mu.Lock()
+	cached, ok := v.cache[id]
+	rejectedUntil, rejected := v.rejected[id]
+	v.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.key, nil
+	}
+	if rejected && now.Before(rejectedUntil) {
+		return nil, ErrInvalidKey
+	}
+
+	verified, err := v.fetch(ctx, key)
+	if err != nil && !errors.Is(err, ErrInvalidKey) {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	if verified != nil {
+		delete(v.rejected, id)
+		v.cacheKey(id, verified, now)
+	} else {
+		delete(v.cache, id)
+		v.rejectKey(id, now)
+	}
+	v.mu.Unlock()
+
+	return verified, err
+}
+
+// cacheKey remembers a valid key, unless the cache is full of unexpired
+// entries. v.mu must be held.
+func (v *Verifier) cacheKey(id [sha256.Size]byte, key *Key, now time.Time) {
+	if len(v.cache) >= maxCachedKeys {
+		for cachedID, cached := range v.cache {
+			if !now.Before(cached.expiresAt) {
+				delete(v.cache, cachedID)
+			}
+		}
+	}
+	if len(v.cache) < maxCachedKeys {
+		v.cache[id] = cachedKey{key: key, expiresAt: now.Add(v.ttl)}
+	}
+}
+
+// rejectKey remembers an invalid key. When the cache is full of unexpired
+// entries an arbitrary one makes room. v.mu must be held.
+func (v *Verifier) rejectKey(id [sha256.Size]byte, now time.Time) {
+	if len(v.rejected) >= maxRejectedKeys {
+		for rejectedID, expiresAt := range v.rejected {
+			if !now.Before(expiresAt) {
+				delete(v.rejected, rejectedID)
+			}
+		}
+	}
+	for rejectedID := range v.rejected {
+		if len(v.rejected) < maxRejectedKeys {
+			break
+		}
+		delete(v.rejected, rejectedID)
+	}
+	v.rejected[id] = now.Add(v.ttl)
+}
+
+func (v *Verifier) fetch(ctx context.Context, key string) (*Key, error) {
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{"key": key})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode verification request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, verifyPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create verification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(internalTokenHeader, v.token)
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+
+	instance, err := v.loadBalancer.NextInstance(v.service, req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s instance: %w", v.service, err)
+	}
+	if req.URL, err = url.Parse(instance.URL() + verifyPath); err != nil {
+		v.loadBalancer.Release(v.service, instance.ID)
+		return nil, fmt.Errorf("failed to create verification request: %w", err)
+	}
+	req.Host = req.URL.Host
+
+	start := time.Now()
+	resp, err := v.client.Do(req)
+	if err != nil {
+		v.loadBalancer.ReportResult(v.service, instance.ID, false, time.Since(start))
+		return nil, fmt.Errorf("failed to verify API key: %w", err)
+	}
+	defer resp.Body.Close()
+	v.loadBalancer.ReportResult(v.service, instance.ID, resp.StatusCode < 500, time.Since(start))
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, ErrInvalidKey
+	default:
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("failed to verify API key: %s returned %d", v.service, resp.StatusCode)
+	}
+
+	var identity struct {
+		KeyID             int64    `json:"key_id"`
+		UserID            int64    `json:"user_id"`
+		Email             string   `json:"email"`
+		Role              string   `json:"role"`
+		Scopes            []string `json:"scopes"`
+		RequestsPerSecond float64  `json:"requests_per_second"`
+		Burst             int      `json:"burst"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&identity); err != nil {
+		return nil, fmt.Errorf("failed to decode API key identity: %w", err)
+	}
+
+	return &Key{
+		ID:                strconv.FormatInt(identity.KeyID, 10),
+		UserID:            strconv.FormatInt(identity.UserID, 10),
+		Email:             identity.Email,
+		Role:              identity.Role,
+		Scopes:            identity.Scopes,
+		RequestsPerSecond: identity.RequestsPerSecond,
+		Burst:             identity.Burst,
+	}, nil
+}
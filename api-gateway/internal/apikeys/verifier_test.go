@@ -0,0 +1,230 @@
+package apikeys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/discovery/consultest"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+)
+
+const internalToken = "internal-token"
+
+// userService answers verification requests like user-service: 200 with the
+// identity of known keys, 401 for unknown and revoked ones.
+type userService struct {
+	mu      sync.Mutex
+	keys    map[string]map[string]any
+	revoked map[string]bool
+	down    bool
+	calls   int
+}
+
+func (s *userService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+
+	if r.URL.Path != verifyPath || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get(internalTokenHeader) != internalToken {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Key string `json:"key"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	identity, ok := s.keys[req.Key]
+	if !ok || s.revoked[req.Key] {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(identity)
+}
+
+func (s *userService) revoke(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[key] = true
+}
+
+func (s *userService) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func newUserService() *userService {
+	return &userService{
+		keys: map[string]map[string]any{
+			"cj_partner": {
+				"key_id":              7,
+				"user_id":             42,
+				"email":               "buyer@example.com",
+				"role":                "admin",
+				"scopes":              []string{"orders:GET", "inventory"},
+				"requests_per_second": 5,
+				"burst":               10,
+			},
+		},
+		revoked: make(map[string]bool),
+	}
+}
+
+// newVerifier returns a verifier asking the user service through a load
+// balancer that finds it in Consul, with the given internal token.
+func newVerifier(t *testing.T, users http.Handler, token string, ttl time.Duration) *Verifier {
+	t.Helper()
+	server := httptest.NewServer(users)
+	t.Cleanup(server.Close)
+
+	consul := consultest.NewServer(t)
+	consul.SetInstances("user-service", consultest.Instance(t, "user-service-1", server.URL, nil))
+	lb := proxy.NewLoadBalancer(consul.Client(t), proxy.OutlierSettings{})
+	t.Cleanup(lb.Close)
+
+	return NewVerifier(lb, "user-service", token, ttl)
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		down    bool
+		token   string
+		want    *Key
+		invalid bool
+		// cached tells whether a second call is answered without asking
+		// user-service again.
+		cached bool
+	}{
+		{
+			name:  "active key",
+			key:   "cj_partner",
+			token: internalToken,
+			want: &Key{
+				ID: "7", UserID: "42", Email: "buyer@example.com", Role: "admin",
+				Scopes: []string{"orders:GET", "inventory"}, RequestsPerSecond: 5, Burst: 10,
+			},
+			cached: true,
+		},
+		{name: "unknown key", key: "cj_unknown", token: internalToken, invalid: true, cached: true},
+		{name: "key store outage", key: "cj_partner", token: internalToken, down: true},
+		{name: "wrong internal token", key: "cj_partner", token: "guess"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newUserService()
+			users.down = tt.down
+			verifier := newVerifier(t, users, tt.token, time.Minute)
+
+			for call := 1; call <= 2; call++ {
+				key, err := verifier.Verify(context.Background(), tt.key)
+				switch {
+				case tt.invalid:
+					if !errors.Is(err, ErrInvalidKey) {
+						t.Fatalf("call %d: error = %v, want ErrInvalidKey", call, err)
+					}
+				case tt.want == nil:
+					if err == nil || errors.Is(err, ErrInvalidKey) {
+						t.Fatalf("call %d: error = %v, want an outage, not ErrInvalidKey", call, err)
+					}
+				default:
+					if err != nil {
+						t.Fatalf("call %d: Verify() error = %v", call, err)
+					}
+					assertKey(t, key, tt.want)
+				}
+			}
+
+			wantCalls := 2
+			if tt.cached {
+				wantCalls = 1
+			}
+			if got := users.callCount(); got != wantCalls {
+				t.Fatalf("user-service called %d times, want %d", got, wantCalls)
+			}
+		})
+	}
+}
+
+func TestVerifyRevokedKeyAfterTTL(t *testing.T) {
+	users := newUserService()
+	verifier := newVerifier(t, users, internalToken, 50*time.Millisecond)
+	ctx := context.Background()
+
+	if _, err := verifier.Verify(ctx, "cj_partner"); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	users.revoke("cj_partner")
+
+	if _, err := verifier.Verify(ctx, "cj_partner"); err != nil {
+		t.Fatalf("within the ttl: Verify() error = %v, want the cached key", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := verifier.Verify(ctx, "cj_partner"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("after the ttl: Verify() error = %v, want ErrInvalidKey", err)
+	}
+	if _, err := verifier.Verify(ctx, "cj_partner"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("cached rejection: Verify() error = %v, want ErrInvalidKey", err)
+	}
+	if got := users.callCount(); got != 2 {
+		t.Fatalf("user-service called %d times, want 2", got)
+	}
+}
+
+func TestKeyAllows(t *testing.T) {
+	key := &Key{Scopes: []string{"orders:GET", "inventory"}}
+
+	tests := []struct {
+		route  string
+		method string
+		want   bool
+	}{
+		{"orders", http.MethodGet, true},
+		{"orders", http.MethodPost, false},
+		{"inventory", http.MethodGet, true},
+		{"inventory", http.MethodDelete, true},
+		{"jars", http.MethodGet, false},
+		// Scopes name whole routes, never prefixes of them.
+		{"order", http.MethodGet, false},
+		{"orders-admin", http.MethodGet, false},
+	}
+
+	for _, tt := range tests {
+		if got := key.Allows(tt.route, tt.method); got != tt.want {
+			t.Errorf("Allows(%q, %s) = %v, want %v", tt.route, tt.method, got, tt.want)
+		}
+	}
+}
+
+func assertKey(t *testing.T, got, want *Key) {
+	t.Helper()
+	if got.ID != want.ID || got.UserID != want.UserID || got.Email != want.Email || got.Role != want.Role ||
+		got.RequestsPerSecond != want.RequestsPerSecond || got.Burst != want.Burst {
+		t.Fatalf("key = %+v, want %+v", got, want)
+	}
+	if len(got.Scopes) != len(want.Scopes) {
+		t.Fatalf("scopes = %v, want %v", got.Scopes, want.Scopes)
+	}
+	for i := range got.Scopes {
+		if got.Scopes[i] != want.Scopes[i] {
+			t.Fatalf("scopes = %v, want %v", got.Scopes, want.Scopes)
+		}
+	}
+}
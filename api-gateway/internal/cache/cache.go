@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// entry is a cached 200 response of a route. vary holds the request header
+// values the upstream said the response depends on.
+type entry struct {
+	key       string
+	route     string
+	header    http.Header
+	body      []byte
+	etag      string
+	vary      map[string]string
+	storedAt  time.Time
+	expiresAt time.Time
+	element   *list.Element
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.body) + len(e.key))
+}
+
+// Cache keeps upstream responses in memory, evicting the least recently used
+// entries once the bodies exceed maxBytes. Entries are grouped by route so a
+// route can be purged at once, e.g. when its service publishes a change.
+type Cache struct {
+	maxBytes int64
+
+	mu          sync.Mutex
+	size        int64
+	entries     map[string]*entry
+	lru         *list.List
+	generations map[string]uint64
+	topics      map[string][]string
+}
+
+func New(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes:    maxBytes,
+		entries:     make(map[string]*entry),
+		lru:         list.New(),
+		generations: make(map[string]uint64),
+		topics:      make(map[string][]string),
+	}
+}
+
+// SetInvalidations replaces the routes purged by Invalidate for each topic.
+func (c *Cache) SetInvalidations(topics map[string][]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.topics = topics
+}
+
+// Invalidate purges every route invalidated by the topic and returns the
+// number of entries removed.
+func (c *Cache) Invalidate(topic string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for _, route := range c.topics[topic] {
+		removed += c.purgeRoute(route)
+	}
+	return removed
+}
+
+// PurgeRoute removes every entry of the route and returns how many there were.
+func (c *Cache) PurgeRoute(route string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.purgeRoute(route)
+}
+
+func (c *Cache) purgeRoute(route string) int {
+	c.generations[route]++
+
+	removed := 0
+	for _, e := range c.entries {
+		if e.route == route {
+			c.remove(e)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Stats returns the number of entries and the bytes they hold.
+func (c *Cache) Stats() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries), c.size
+}
+
+// RouteEntries returns the number of entries held for each route.
+func (c *Cache) RouteEntries() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, e := range c.entries {
+		counts[e.route]++
+	}
+	return counts
+}
+
+// generation changes every time the route is purged. A response fetched
+// before a purge must not be stored after it.
+func (c *Cache) generation(route string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generations[route]
+}
+
+func (c *Cache) get(key string, r *http.Request) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expiresAt) {
+		c.remove(e)
+		return nil
+	}
+	for name, value := range e.vary {
+		if r.Header.Get(name) != value {
+			return nil
+		}
+	}
+
+	c.lru.MoveToFront(e.element)
+	return e
+}
+
+func (c *Cache) set(e *entry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[e.route] != generation || e.size() > c.maxBytes {
+		return
+	}
+	if old, ok := c.entries[e.key]; ok {
+		c.remove(old)
+	}
+
+	e.element = c.lru.PushFront(e)
+	c.entries[e.key] = e
+	c.size += e.size()
+
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back().Value.(*entry))
+	}
+}
+
+func (c *Cache) remove(e *entry) {
+	c.lru.Remove(e.element)
+	delete(c.entries, e.key)
+	c.size -= e.size()
+}
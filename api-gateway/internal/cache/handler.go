@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/requestid"
+)
+
+// maxEntryBody is the largest response body stored; bigger responses are
+// streamed to the client and not cached.
+const maxEntryBody = 1 << 20
+
+// Cache results reported to the observer.
+const (
+	ResultHit    = "hit"
+	ResultMiss   = "miss"
+	ResultBypass = "bypass"
+)
+
+// Handler caches successful GET responses of the route. Freshness comes from
+// the upstream Cache-Control (s-maxage, then max-age), or defaultTTL when the
+// upstream sends none; no-store, no-cache, private, Set-Cookie and Vary: *
+// keep a response out of the cache. Cached responses carry an ETag so clients
+// can revalidate with If-None-Match and receive 304.
+//
+// Enabling the cache on a route declares its responses shareable between
+// callers: the route must not serve user-specific data.
+func (c *Cache) Handler(route string, defaultTTL time.Duration, observe func(route, result string)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || proxy.IsUpgrade(r) || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+				observe(route, ResultBypass)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := route + " " + r.URL.RequestURI()
+			if e := c.get(key, r); e != nil {
+				observe(route, ResultHit)
+				serve(w, r, e, ResultHit)
+				return
+			}
+			observe(route, ResultMiss)
+
+			// Ask for the full response so it can be stored; the client's
+			// conditions are evaluated against it afterwards.
+			generation := c.generation(route)
+			upstream := r.Clone(r.Context())
+			upstream.Header.Del("If-None-Match")
+			upstream.Header.Del("If-Modified-Since")
+
+			rec := &recorder{w: w, header: make(http.Header), limit: maxEntryBody}
+			next.ServeHTTP(rec, upstream)
+			if rec.passthrough {
+				return
+			}
+
+			now := time.Now()
+			e := &entry{
+				key:      key,
+				route:    route,
+				header:   rec.header.Clone(),
+				body:     rec.body.Bytes(),
+				etag:     rec.header.Get("ETag"),
+				storedAt: now,
+			}
+			// Each response carries the ID of the request it answers.
+			e.header.Del(requestid.Header)
+
+			ttl, vary, cacheable := freshness(rec.status, rec.header, r, defaultTTL)
+			if !cacheable {
+				rec.writeThrough()
+				return
+			}
+
+			if e.etag == "" {
+				sum := sha256.Sum256(e.body)
+				e.etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+				e.header.Set("ETag", e.etag)
+			}
+			e.vary = vary
+			e.expiresAt = now.Add(ttl)
+			c.set(e, generation)
+
+			serve(w, r, e, ResultMiss)
+		})
+	}
+}
+
+// serve writes a cached entry, or 304 when the client already holds it.
+func serve(w http.ResponseWriter, r *http.Request, e *entry, result string) {
+	header := w.Header()
+	for key, values := range e.header.Clone() {
+		header[key] = values
+	}
+	header.Set("Age", strconv.Itoa(int(time.Since(e.storedAt).Seconds())))
+	header.Set("X-Cache", strings.ToUpper(result))
+
+	if etagMatches(r.Header.Get("If-None-Match"), e.etag) {
+		header.Del("Content-Length")
+		header.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(e.body)
+}
+
+// freshness decides whether a response may be stored, for how long, and which
+// request header values it varies on.
+func freshness(status int, header http.Header, r *http.Request, defaultTTL time.Duration) (time.Duration, map[string]string, bool) {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" {
+		return 0, nil, false
+	}
+
+	ttl := defaultTTL
+	maxAge, sharedMaxAge := -1, -1
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return 0, nil, false
+		case "max-age":
+			maxAge, _ = strconv.Atoi(strings.Trim(value, `"`))
+		case "s-maxage":
+			sharedMaxAge, _ = strconv.Atoi(strings.Trim(value, `"`))
+		}
+	}
+	switch {
+	case sharedMaxAge >= 0:
+		ttl = time.Duration(sharedMaxAge) * time.Second
+	case maxAge >= 0:
+		ttl = time.Duration(maxAge) * time.Second
+	}
+	if ttl <= 0 {
+		return 0, nil, false
+	}
+
+	var vary map[string]string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return 0, nil, false
+			}
+			if name == "" {
+				continue
+			}
+			if vary == nil {
+				vary = make(map[string]string)
+			}
+			vary[name] = r.Header.Get(name)
+		}
+	}
+
+	return ttl, vary, true
+}
+
+// etagMatches applies the weak comparison of If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder buffers the upstream response so it can be stored. A body larger
+// than limit switches it to writing through to the client.
+type recorder struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	limit       int
+	passthrough bool
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.passthrough {
+		return r.w.Write(b)
+	}
+	if r.body.Len()+len(b) > r.limit {
+		r.writeThrough()
+		return r.w.Write(b)
+	}
+	return r.body.Write(b)
+}
+
+// writeThrough sends what was recorded so far and forwards any further writes.
+func (r *recorder) writeThrough() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	header := r.w.Header()
+	for key, values := range r.header {
+		header[key] = values
+	}
+	r.w.WriteHeader(r.status)
+	r.w.Write(r.body.Bytes())
+	r.body.Reset()
+	r.passthrough = true
+}
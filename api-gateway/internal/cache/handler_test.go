@@ -0,0 +1,279 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		defaultTTL time.Duration
+		ttl        time.Duration
+		vary       map[string]string
+		cacheable  bool
+	}{
+		{name: "route ttl", status: http.StatusOK, header: http.Header{}, defaultTTL: time.Minute, ttl: time.Minute, cacheable: true},
+		{name: "no ttl at all", status: http.StatusOK, header: http.Header{}},
+		{name: "max-age", status: http.StatusOK, header: http.Header{"Cache-Control": {"public, max-age=30"}}, defaultTTL: time.Minute, ttl: 30 * time.Second, cacheable: true},
+		{name: "s-maxage wins over max-age", status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}}, defaultTTL: time.Minute, ttl: 10 * time.Second, cacheable: true},
+		{name: "s-maxage=0 wins over max-age", status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60, s-maxage=0"}}, defaultTTL: time.Minute},
+		{name: "max-age=0", status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=0"}}, defaultTTL: time.Minute},
+		{name: "no-store", status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60, no-store"}}, defaultTTL: time.Minute},
+		{name: "no-cache", status: http.StatusOK, header: http.Header{"Cache-Control": {"no-cache"}}, defaultTTL: time.Minute},
+		{name: "private", status: http.StatusOK, header: http.Header{"Cache-Control": {"Private, max-age=60"}}, defaultTTL: time.Minute},
+		{name: "Set-Cookie", status: http.StatusOK, header: http.Header{"Set-Cookie": {"session=1"}, "Cache-Control": {"max-age=60"}}, defaultTTL: time.Minute},
+		{name: "error status", status: http.StatusNotFound, header: http.Header{"Cache-Control": {"max-age=60"}}, defaultTTL: time.Minute},
+		{name: "Vary: *", status: http.StatusOK, header: http.Header{"Vary": {"Accept-Language, *"}}, defaultTTL: time.Minute},
+		{
+			name:       "Vary on request headers",
+			status:     http.StatusOK,
+			header:     http.Header{"Vary": {"Accept-Language", "Accept-Encoding"}},
+			defaultTTL: time.Minute,
+			ttl:        time.Minute,
+			vary:       map[string]string{"Accept-Language": "fr", "Accept-Encoding": ""},
+			cacheable:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/jars", nil)
+			r.Header.Set("Accept-Language", "fr")
+
+			ttl, vary, cacheable := freshness(tt.status, tt.header, r, tt.defaultTTL)
+			if cacheable != tt.cacheable {
+				t.Fatalf("cacheable = %v, want %v", cacheable, tt.cacheable)
+			}
+			if ttl != tt.ttl {
+				t.Errorf("ttl = %v, want %v", ttl, tt.ttl)
+			}
+			if !reflect.DeepEqual(vary, tt.vary) {
+				t.Errorf("vary = %v, want %v", vary, tt.vary)
+			}
+		})
+	}
+}
+
+// countingUpstream answers every request with the same response and counts
+// the requests it saw.
+type countingUpstream struct {
+	header http.Header
+	body   string
+	calls  int
+	// during runs while the upstream handles a request.
+	during func()
+}
+
+func (u *countingUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.calls++
+	if u.during != nil {
+		u.during()
+	}
+	for key, values := range u.header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Write([]byte(u.body))
+}
+
+func get(handler http.Handler, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/jars?page=1", nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+func noObserve(route, result string) {}
+
+func TestHandlerStoresShareableResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		stored bool
+	}{
+		{"cacheable", http.Header{"Cache-Control": {"max-age=60"}}, true},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, false},
+		{"private", http.Header{"Cache-Control": {"private, max-age=60"}}, false},
+		{"Set-Cookie", http.Header{"Set-Cookie": {"session=secret"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &countingUpstream{header: tt.header, body: `[{"id":"1"}]`}
+			handler := New(1<<20).Handler("jars", time.Minute, noObserve)(upstream)
+
+			first := get(handler, nil)
+			second := get(handler, nil)
+
+			if first.Code != http.StatusOK || first.Body.String() != upstream.body {
+				t.Fatalf("first response = %d %q", first.Code, first.Body)
+			}
+			if second.Code != http.StatusOK || second.Body.String() != upstream.body {
+				t.Fatalf("second response = %d %q", second.Code, second.Body)
+			}
+
+			wantCalls, wantCache := 2, ""
+			if tt.stored {
+				wantCalls, wantCache = 1, "HIT"
+			}
+			if upstream.calls != wantCalls {
+				t.Errorf("upstream called %d times, want %d", upstream.calls, wantCalls)
+			}
+			if got := second.Header().Get("X-Cache"); got != wantCache {
+				t.Errorf("X-Cache = %q, want %q", got, wantCache)
+			}
+			if got := second.Header().Get("Set-Cookie"); got != "" && tt.stored {
+				t.Errorf("cached response carries Set-Cookie %q", got)
+			}
+		})
+	}
+}
+
+func TestHandlerIfNoneMatch(t *testing.T) {
+	upstream := &countingUpstream{header: http.Header{"ETag": {`"v1"`}}, body: `[]`}
+	handler := New(1<<20).Handler("jars", time.Minute, noObserve)(upstream)
+
+	// The first request is sent upstream without the client's condition, and
+	// the condition is checked against the stored response.
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{"miss with the current ETag", `"v1"`, http.StatusNotModified},
+		{"hit with a weak match", `W/"v1"`, http.StatusNotModified},
+		{"hit in a list", `"v0", "v1"`, http.StatusNotModified},
+		{"hit with *", `*`, http.StatusNotModified},
+		{"hit with another ETag", `"v0"`, http.StatusOK},
+		{"hit without a condition", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rec := get(handler, http.Header{"If-None-Match": {tt.ifNoneMatch}})
+		if rec.Code != tt.status {
+			t.Fatalf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if rec.Code == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("%s: 304 with a body", tt.name)
+		}
+		if got := rec.Header().Get("ETag"); got != `"v1"` {
+			t.Errorf("%s: ETag = %q, want the upstream's", tt.name, got)
+		}
+	}
+	if upstream.calls != 1 {
+		t.Fatalf("upstream called %d times, want 1", upstream.calls)
+	}
+}
+
+func TestHandlerGeneratesETag(t *testing.T) {
+	upstream := &countingUpstream{body: `[{"id":"1"}]`}
+	handler := New(1<<20).Handler("jars", time.Minute, noObserve)(upstream)
+
+	etag := get(handler, nil).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag on a cached response without one")
+	}
+	if rec := get(handler, http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want 304", rec.Code)
+	}
+}
+
+func TestHandlerVary(t *testing.T) {
+	upstream := &countingUpstream{header: http.Header{"Vary": {"Accept-Language"}}, body: `[]`}
+	handler := New(1<<20).Handler("jars", time.Minute, noObserve)(upstream)
+
+	get(handler, http.Header{"Accept-Language": {"fr"}})
+	get(handler, http.Header{"Accept-Language": {"en"}})
+	if upstream.calls != 2 {
+		t.Fatalf("upstream called %d times, want 2 for two languages", upstream.calls)
+	}
+	if rec := get(handler, http.Header{"Accept-Language": {"en"}}); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("X-Cache = %q, want HIT for the language last stored", rec.Header().Get("X-Cache"))
+	}
+}
+
+// A response fetched while its route is purged describes the state before the
+// purge, so it must be served but not stored.
+func TestHandlerDropsFillRacingInvalidate(t *testing.T) {
+	c := New(1 << 20)
+	c.SetInvalidations(map[string][]string{"jar-events": {"jars"}})
+
+	upstream := &countingUpstream{body: `[{"id":"1","price":10}]`}
+	upstream.during = func() { c.Invalidate("jar-events") }
+	handler := c.Handler("jars", time.Minute, noObserve)(upstream)
+
+	if rec := get(handler, nil); rec.Code != http.StatusOK || rec.Body.String() != upstream.body {
+		t.Fatalf("racing response = %d %q", rec.Code, rec.Body)
+	}
+	if entries, _ := c.Stats(); entries != 0 {
+		t.Fatalf("cache holds %d entries, want the racing fill dropped", entries)
+	}
+
+	upstream.during = nil
+	get(handler, nil)
+	get(handler, nil)
+	if upstream.calls != 2 {
+		t.Fatalf("upstream called %d times, want 2", upstream.calls)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := New(1 << 20)
+	c.SetInvalidations(map[string][]string{"jar-events": {"jars", "facets"}})
+
+	jars := &countingUpstream{body: `[]`}
+	orders := &countingUpstream{body: `[]`}
+	jarsHandler := c.Handler("jars", time.Minute, noObserve)(jars)
+	ordersHandler := c.Handler("orders", time.Minute, noObserve)(orders)
+	get(jarsHandler, nil)
+	get(ordersHandler, nil)
+
+	if removed := c.Invalidate("jar-events"); removed != 1 {
+		t.Fatalf("Invalidate() removed %d entries, want 1", removed)
+	}
+	if removed := c.Invalidate("order-events"); removed != 0 {
+		t.Fatalf("Invalidate() of an unmapped topic removed %d entries", removed)
+	}
+
+	get(jarsHandler, nil)
+	get(ordersHandler, nil)
+	if jars.calls != 2 || orders.calls != 1 {
+		t.Fatalf("upstream calls: jars %d, orders %d; want 2 and 1", jars.calls, orders.calls)
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	// Each entry holds its 21-byte key and 20-byte body, so two fit.
+	c := New(100)
+	upstream := &countingUpstream{body: string(make([]byte, 20))}
+	handler := c.Handler("jars", time.Minute, noObserve)(upstream)
+
+	request := func(page string) {
+		r := httptest.NewRequest(http.MethodGet, "/api/jars?page="+page, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	request("1")
+	request("2")
+	request("1") // page 1 is now the most recently used
+	request("3") // evicts page 2
+
+	calls := upstream.calls
+	request("1")
+	if upstream.calls != calls {
+		t.Fatal("recently used entry evicted")
+	}
+	request("2")
+	if upstream.calls != calls+1 {
+		t.Fatal("least recently used entry kept")
+	}
+}
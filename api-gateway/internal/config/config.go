@@ -1,26 +1,246 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	ServerPort string
-	ConsulAddr string
+	ServerPort           string
+	AdminPort            string
+	MetricsPort          string
+	ConsulAddr           string
+	JWTSecret            string
+	RoutesFile           string
+	RoutesConsulKey      string
+	RoutesReloadInterval time.Duration
+	UpstreamTimeout      time.Duration
+	RetryMaxAttempts     int
+	RetryBackoff         time.Duration
+	BreakerThreshold     int
+	BreakerOpenTimeout   time.Duration
+	BreakerHalfOpenCalls int
+	Outlier              OutlierConfig
+	RateLimitRPS         float64
+	RateLimitBurst       int
+	TrustedProxies       []string
+	RedisAddr            string
+	RedisPassword        string
+	KafkaBrokers         []string
+	CacheMaxBytes        int
+	CacheConsumerGroup   string
+	MirrorMaxInflight    int
+	APIKeyService        string
+	InternalToken        string
+	APIKeyCacheTTL       time.Duration
+	OTLPEndpoint         string
+	TraceSampleRatio     float64
+}
+
+type OutlierConfig struct {
+	ConsecutiveErrors  int
+	ErrorRate          float64
+	MinRequests        int
+	Interval           time.Duration
+	LatencyThreshold   time.Duration
+	EjectionTime       time.Duration
+	MaxEjectionPercent int
+	SlowStart          time.Duration
 }
 
 func LoadConfig() (*Config, error) {
 	cfg := &Config{
-		ServerPort: getEnv("SERVER_PORT", "8000"),
-		ConsulAddr: getEnv("CONSUL_ADDR", "localhost:8500"),
+		ServerPort:      getEnv("SERVER_PORT", "8000"),
+		AdminPort:       getEnv("ADMIN_PORT", "9000"),
+		MetricsPort:     getEnv("METRICS_PORT", "9102"),
+		ConsulAddr:      getEnv("CONSUL_ADDR", "localhost:8500"),
+		JWTSecret:       getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+		RoutesFile:      getEnv("ROUTES_FILE", "routes.yaml"),
+		RoutesConsulKey: getEnv("ROUTES_CONSUL_KEY", ""),
+		TrustedProxies:  getList("TRUSTED_PROXIES"),
+		RedisAddr:       getEnv("REDIS_ADDR", ""),
+		RedisPassword:   getEnv("REDIS_PASSWORD", ""),
+		OTLPEndpoint:    getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		KafkaBrokers:    getList("KAFKA_BROKERS"),
+		APIKeyService:   getEnv("API_KEY_SERVICE", "user-service"),
+		InternalToken:   getEnv("INTERNAL_TOKEN", "internal-token-change-in-production"),
+	}
+	if len(cfg.KafkaBrokers) == 0 {
+		cfg.KafkaBrokers = []string{"shared-kafka:9092"}
+	}
+
+	// Every replica needs its own group to see every invalidation event.
+	hostname, _ := os.Hostname()
+	cfg.CacheConsumerGroup = getEnv("CACHE_CONSUMER_GROUP", "api-gateway-cache-"+hostname)
+
+	var err error
+	if cfg.RoutesReloadInterval, err = getDuration("ROUTES_RELOAD_INTERVAL", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.UpstreamTimeout, err = getDuration("UPSTREAM_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RetryMaxAttempts, err = getInt("RETRY_MAX_ATTEMPTS", 3); err != nil {
+		return nil, err
+	}
+	if cfg.RetryBackoff, err = getDuration("RETRY_BACKOFF", 50*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if cfg.BreakerThreshold, err = getInt("BREAKER_FAILURE_THRESHOLD", 5); err != nil {
+		return nil, err
+	}
+	if cfg.BreakerOpenTimeout, err = getDuration("BREAKER_OPEN_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.BreakerHalfOpenCalls, err = getInt("BREAKER_HALF_OPEN_REQUESTS", 1); err != nil {
+		return nil, err
+	}
+	if cfg.Outlier.ConsecutiveErrors, err = getInt("OUTLIER_CONSECUTIVE_ERRORS", 5); err != nil {
+		return nil, err
+	}
+	if cfg.Outlier.ErrorRate, err = getFloat("OUTLIER_ERROR_RATE", 0.5); err != nil {
+		return nil, err
+	}
+	if cfg.Outlier.MinRequests, err = getInt("OUTLIER_MIN_REQUESTS", 10); err != nil {
+		return nil, err
+	}
+	if cfg.Outlier.Interval, err = getDuration("OUTLIER_INTERVAL", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Outlier.LatencyThreshold, err = getDuration("OUTLIER_LATENCY_THRESHOLD", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Outlier.EjectionTime, err = getDuration("OUTLIER_EJECTION_TIME", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Outlier.MaxEjectionPercent, err = getInt("OUTLIER_MAX_EJECTION_PERCENT", 50); err != nil {
+		return nil, err
+	}
+	if cfg.Outlier.SlowStart, err = getDuration("OUTLIER_SLOW_START", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimitRPS, err = getFloat("RATE_LIMIT_RPS", 100); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimitBurst, err = getInt("RATE_LIMIT_BURST", 200); err != nil {
+		return nil, err
+	}
+	if cfg.CacheMaxBytes, err = getInt("CACHE_MAX_BYTES", 64<<20); err != nil {
+		return nil, err
+	}
+	if cfg.MirrorMaxInflight, err = getInt("MIRROR_MAX_INFLIGHT", 100); err != nil {
+		return nil, err
+	}
+	if cfg.APIKeyCacheTTL, err = getDuration("API_KEY_CACHE_TTL", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.TraceSampleRatio, err = getFloat("TRACE_SAMPLE_RATIO", 1); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
 	return cfg, nil
 }
 
+func (c *Config) Validate() error {
+	if c.RoutesFile == "" && c.RoutesConsulKey == "" {
+		return fmt.Errorf("ROUTES_FILE or ROUTES_CONSUL_KEY is required")
+	}
+	if c.AdminPort == c.ServerPort {
+		return fmt.Errorf("ADMIN_PORT must differ from SERVER_PORT")
+	}
+	if c.MetricsPort == c.ServerPort || c.MetricsPort == c.AdminPort {
+		return fmt.Errorf("METRICS_PORT must differ from SERVER_PORT and ADMIN_PORT")
+	}
+	if c.RoutesReloadInterval <= 0 {
+		return fmt.Errorf("ROUTES_RELOAD_INTERVAL must be positive")
+	}
+	if c.UpstreamTimeout <= 0 {
+		return fmt.Errorf("UPSTREAM_TIMEOUT must be positive")
+	}
+	if c.RetryMaxAttempts < 1 {
+		return fmt.Errorf("RETRY_MAX_ATTEMPTS must be at least 1")
+	}
+	if c.RateLimitRPS <= 0 {
+		return fmt.Errorf("RATE_LIMIT_RPS must be positive")
+	}
+	if c.CacheMaxBytes < 0 {
+		return fmt.Errorf("CACHE_MAX_BYTES cannot be negative")
+	}
+	if c.MirrorMaxInflight < 1 {
+		return fmt.Errorf("MIRROR_MAX_INFLIGHT must be at least 1")
+	}
+	if c.InternalToken == "" {
+		return fmt.Errorf("INTERNAL_TOKEN is required")
+	}
+	if c.APIKeyCacheTTL <= 0 {
+		return fmt.Errorf("API_KEY_CACHE_TTL must be positive")
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return fmt.Errorf("TRACE_SAMPLE_RATIO must be between 0 and 1")
+	}
+	return nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+// getList splits a comma-separated variable, dropping empty entries.
+func getList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration: %w", key, err)
+	}
+	return d, nil
+}
+
+func getInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", key, err)
+	}
+	return n, nil
+}
+
+func getFloat(key string, defaultValue float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number: %w", key, err)
+	}
+	return f, nil
+}
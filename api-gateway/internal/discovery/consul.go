@@ -1,7 +1,10 @@
 package discovery
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 )
@@ -31,6 +34,48 @@ func (c *ConsulClient) GetServiceInstances(serviceName string) ([]*api.ServiceEn
 	return services, nil
 }
 
+// WatchService follows the healthy instances of a service with blocking
+// queries and calls update every time Consul reports a new index, until the
+// context is cancelled. Failed queries are reported to failed and retried with
+// backoff; update is not called meanwhile, so callers keep their last known
+// instance set.
+func (c *ConsulClient) WatchService(ctx context.Context, serviceName string, update func([]*api.ServiceEntry), failed func(error)) {
+	var index uint64
+	first := true
+	backoff := time.Second
+
+	for ctx.Err() == nil {
+		opts := (&api.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Minute}).WithContext(ctx)
+		services, meta, err := c.client.Health().Service(serviceName, "", true, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to watch service %s, retrying in %v: %v", serviceName, backoff, err)
+			failed(err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		backoff = time.Second
+
+		// A smaller index means the server state was rebuilt; follow it anyway.
+		// Blocking on index 0 would return immediately, so never go below 1.
+		changed := meta.LastIndex != index
+		index = max(meta.LastIndex, 1)
+		if !changed && !first {
+			continue
+		}
+		first = false
+
+		update(services)
+	}
+}
+
 func (c *ConsulClient) RegisterService(service *api.AgentServiceRegistration) error {
 	return c.client.Agent().ServiceRegister(service)
 }
@@ -38,3 +83,29 @@ func (c *ConsulClient) RegisterService(service *api.AgentServiceRegistration) er
 func (c *ConsulClient) DeregisterService(serviceID string) error {
 	return c.client.Agent().ServiceDeregister(serviceID)
 }
+
+func (c *ConsulClient) GetKey(ctx context.Context, key string) ([]byte, error) {
+	pair, _, err := c.client.KV().Get(key, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("key %s not found", key)
+	}
+
+	return pair.Value, nil
+}
+
+// Leader returns the address of the Consul leader, which doubles as a
+// reachability check of the cluster.
+func (c *ConsulClient) Leader(ctx context.Context) (string, error) {
+	leader, err := c.client.Status().LeaderWithQueryOptions((&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to reach consul: %w", err)
+	}
+	if leader == "" {
+		return "", fmt.Errorf("consul has no leader")
+	}
+
+	return leader, nil
+}
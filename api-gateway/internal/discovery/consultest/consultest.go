@@ -0,0 +1,163 @@
+// Package consultest serves the part of the Consul health API the gateway
+// watches, so that tests can run the load balancer against instances of their
+// own.
+package consultest
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/0Bleak/api-gateway/internal/discovery"
+	"github.com/hashicorp/consul/api"
+)
+
+const healthPath = "/v1/health/service/"
+
+// Server answers health queries, blocking queries included, from the
+// instance sets given to SetInstances.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	index    uint64
+	services map[string][]*api.ServiceEntry
+	failing  bool
+	queries  map[string]int
+	changed  chan struct{}
+	done     chan struct{}
+}
+
+// NewServer starts a server that lists no service and stops it at the end of
+// the test.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		index:    1,
+		services: make(map[string][]*api.ServiceEntry),
+		queries:  make(map[string]int),
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHealth))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close releases the blocking queries still waiting and stops the server.
+func (s *Server) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	s.mu.Unlock()
+	s.Server.Close()
+}
+
+// Client returns a Consul client talking to the server.
+func (s *Server) Client(t testing.TB) *discovery.ConsulClient {
+	t.Helper()
+	client, err := discovery.NewConsulClient(strings.TrimPrefix(s.URL, "http://"))
+	if err != nil {
+		t.Fatalf("failed to create consul client: %v", err)
+	}
+	return client
+}
+
+// SetInstances replaces the instances of a service and wakes the blocking
+// queries.
+func (s *Server) SetInstances(service string, entries ...*api.ServiceEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services[service] = entries
+	s.bump()
+}
+
+// SetFailing makes every query answer 500 until it is called with false.
+func (s *Server) SetFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+	s.bump()
+}
+
+// Queries returns how many health queries were made for the service.
+func (s *Server) Queries(service string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[service]
+}
+
+// bump moves to the next index. s.mu must be held.
+func (s *Server) bump() {
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request) {
+	service, ok := strings.CutPrefix(r.URL.Path, healthPath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+
+	s.mu.Lock()
+	s.queries[service]++
+	for !s.failing && waitIndex != 0 && waitIndex >= s.index {
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+		s.mu.Lock()
+	}
+	failing := s.failing
+	index := s.index
+	entries := s.services[service]
+	s.mu.Unlock()
+
+	if failing {
+		http.Error(w, "consul unavailable", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []*api.ServiceEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	json.NewEncoder(w).Encode(entries)
+}
+
+// Instance returns a healthy instance serving at rawURL, such as the URL of an
+// httptest server, with the given service meta.
+func Instance(t testing.TB, id, rawURL string, meta map[string]string) *api.ServiceEntry {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("invalid instance URL %q: %v", rawURL, err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatalf("invalid instance address %q: %v", u.Host, err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatalf("invalid instance port %q: %v", port, err)
+	}
+	return &api.ServiceEntry{
+		Service: &api.AgentService{ID: id, Address: host, Port: portNumber, Meta: meta},
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/cache"
+	"github.com/0Bleak/api-gateway/internal/problem"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/ratelimit"
+	"github.com/0Bleak/api-gateway/internal/routes"
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// AdminHandler exposes the gateway's runtime state to operators. It is served
+// on the admin listener only.
+type AdminHandler struct {
+	loadBalancer *proxy.LoadBalancer
+	breakers     *proxy.BreakerRegistry
+	router       *routes.Router
+	rateStore    ratelimit.Store
+	cache        *cache.Cache
+}
+
+func NewAdminHandler(lb *proxy.LoadBalancer, breakers *proxy.BreakerRegistry, router *routes.Router, rateStore ratelimit.Store, responseCache *cache.Cache) *AdminHandler {
+	return &AdminHandler{
+		loadBalancer: lb,
+		breakers:     breakers,
+		router:       router,
+		rateStore:    rateStore,
+		cache:        responseCache,
+	}
+}
+
+// Routes returns the active route table in the routes.yaml format.
+func (h *AdminHandler) Routes(w http.ResponseWriter, r *http.Request) {
+	table := h.router.Table()
+	if table == nil {
+		problem.Write(w, r, http.StatusServiceUnavailable, "Route table not loaded")
+		return
+	}
+
+	data, err := yaml.Marshal(table)
+	if err != nil {
+		log.Printf("Failed to encode route table: %v", err)
+		problem.Write(w, r, http.StatusInternalServerError, "Failed to encode route table")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// Services returns the instances and strategy state of every watched service.
+func (h *AdminHandler) Services(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, h.loadBalancer.Snapshots())
+}
+
+func (h *AdminHandler) Service(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := h.snapshot(mux.Vars(r)["service"])
+	if !ok {
+		problem.Write(w, r, http.StatusNotFound, "Service not watched")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, snapshot)
+}
+
+// RefreshService queries Consul for the service's instances right away.
+func (h *AdminHandler) RefreshService(w http.ResponseWriter, r *http.Request) {
+	service := mux.Vars(r)["service"]
+	if err := h.loadBalancer.Refresh(service); err != nil {
+		log.Printf("Failed to refresh %s: %v", service, err)
+		problem.Write(w, r, http.StatusBadGateway, "Failed to query Consul")
+		return
+	}
+
+	snapshot, _ := h.snapshot(service)
+	respondWithJSON(w, http.StatusOK, snapshot)
+}
+
+func (h *AdminHandler) DrainInstance(w http.ResponseWriter, r *http.Request) {
+	h.setDraining(w, r, true)
+}
+
+func (h *AdminHandler) UndrainInstance(w http.ResponseWriter, r *http.Request) {
+	h.setDraining(w, r, false)
+}
+
+func (h *AdminHandler) setDraining(w http.ResponseWriter, r *http.Request, draining bool) {
+	vars := mux.Vars(r)
+	service, instanceID := vars["service"], vars["id"]
+
+	// Instances that left Consul can still be undrained.
+	snapshot, ok := h.snapshot(service)
+	if draining && (!ok || !hasInstance(snapshot, instanceID)) {
+		problem.Write(w, r, http.StatusNotFound, "Instance not found")
+		return
+	}
+
+	if draining {
+		h.loadBalancer.Drain(service, instanceID)
+		log.Printf("Draining %s instance %s", service, instanceID)
+	} else {
+		h.loadBalancer.Undrain(service, instanceID)
+		log.Printf("Returned %s instance %s to rotation", service, instanceID)
+	}
+
+	snapshot, _ = h.snapshot(service)
+	respondWithJSON(w, http.StatusOK, snapshot)
+}
+
+func (h *AdminHandler) CircuitBreakers(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, h.breakers.Snapshots())
+}
+
+// RateLimitBucket reports how long until the bucket named by ?key= is full
+// again. Keys are "<scope>:<client>", e.g. "route:orders:user:42",
+// "api-key:key:7" or "global:ip:10.0.0.1".
+func (h *AdminHandler) RateLimitBucket(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		problem.Write(w, r, http.StatusBadRequest, "key is required")
+		return
+	}
+
+	backlog, err := h.rateStore.Backlog(r.Context(), key)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadGateway, "Failed to read bucket")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]any{
+		"key":             key,
+		"full":            backlog == 0,
+		"full_in_seconds": backlog.Seconds(),
+		"checked_at":      time.Now(),
+	})
+}
+
+func (h *AdminHandler) Cache(w http.ResponseWriter, r *http.Request) {
+	entries, size := h.cache.Stats()
+	respondWithJSON(w, http.StatusOK, map[string]any{
+		"entries": entries,
+		"bytes":   size,
+		"routes":  h.cache.RouteEntries(),
+	})
+}
+
+func (h *AdminHandler) PurgeCacheRoute(w http.ResponseWriter, r *http.Request) {
+	removed := h.cache.PurgeRoute(mux.Vars(r)["route"])
+	respondWithJSON(w, http.StatusOK, map[string]int{"removed": removed})
+}
+
+func (h *AdminHandler) snapshot(service string) (proxy.ServiceSnapshot, bool) {
+	for _, snapshot := range h.loadBalancer.Snapshots() {
+		if snapshot.Service == service {
+			return snapshot, true
+		}
+	}
+	return proxy.ServiceSnapshot{}, false
+}
+
+func hasInstance(snapshot proxy.ServiceSnapshot, instanceID string) bool {
+	for _, instance := range snapshot.Instances {
+		if instance.ID == instanceID {
+			return true
+		}
+	}
+	return false
+}
+
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	response, _ := json.Marshal(payload)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/metrics"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/routes"
+)
+
+// Outcomes of a shadow request, compared with the primary response.
+const (
+	mirrorMatch          = "match"
+	mirrorStatusMismatch = "status_mismatch"
+	mirrorBodyMismatch   = "body_mismatch"
+	mirrorError          = "error"
+	mirrorSkipped        = "skipped"
+	mirrorDropped        = "dropped"
+)
+
+// shadowHeader marks mirrored requests. Services reject unsafe methods carrying
+// it unless they run apart from the primary's database and topics.
+const shadowHeader = "X-Shadow-Request"
+
+// mirror sends copies of proxied requests to shadow services. Shadow responses
+// never reach the client; only their status and body hash are kept.
+type mirror struct {
+	loadBalancer   *proxy.LoadBalancer
+	client         *http.Client
+	defaultTimeout time.Duration
+	slots          chan struct{}
+}
+
+func newMirror(lb *proxy.LoadBalancer, client *http.Client, defaultTimeout time.Duration, maxInflight int) *mirror {
+	return &mirror{
+		loadBalancer:   lb,
+		client:         client,
+		defaultTimeout: defaultTimeout,
+		slots:          make(chan struct{}, maxInflight),
+	}
+}
+
+// primaryResult is what the client received from the primary service.
+// complete is false when the body was not relayed in full.
+type primaryResult struct {
+	status   int
+	hash     string
+	complete bool
+}
+
+// shadow is a mirrored request waiting for the result of its primary. A nil
+// shadow ignores every call, so callers need not check whether the request
+// was mirrored.
+type shadow struct {
+	status  int
+	body    *hashingBody
+	primary chan primaryResult
+}
+
+// start sends a copy of the request to the route's mirror service, unless the
+// method is not mirrored, the request falls outside the mirrored percentage or
+// too many shadow requests are outstanding. body is the buffered request body.
+func (m *mirror) start(route *routes.Route, r *http.Request, body []byte) *shadow {
+	config := route.Mirror
+	if !config.Mirrors(r.Method) {
+		return nil
+	}
+	percent := 100.0
+	if config.Percent != nil {
+		percent = *config.Percent
+	}
+	if rand.Float64()*100 >= percent {
+		return nil
+	}
+
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		metrics.ObserveMirror(route.Name, config.Service, mirrorDropped, 0)
+		return nil
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = route.Timeout
+	}
+	if timeout <= 0 {
+		timeout = m.defaultTimeout
+	}
+
+	// The shadow outlives the client request, so it keeps its values but not
+	// its cancellation.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), timeout)
+
+	target := route.RewritePath(r.URL.Path)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	shadowReq, err := http.NewRequestWithContext(ctx, r.Method, target, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		<-m.slots
+		metrics.ObserveMirror(route.Name, config.Service, mirrorError, 0)
+		return nil
+	}
+	shadowReq.ContentLength = int64(len(body))
+	shadowReq.Header = r.Header.Clone()
+	proxy.RemoveHopHeaders(shadowReq.Header)
+	proxy.SetForwardedHeaders(shadowReq.Header, r)
+	shadowReq.Header.Set(shadowHeader, "true")
+
+	s := &shadow{primary: make(chan primaryResult, 1)}
+	go func() {
+		defer func() { <-m.slots }()
+		defer cancel()
+		m.run(route, shadowReq, s)
+	}()
+	return s
+}
+
+func (m *mirror) run(route *routes.Route, req *http.Request, s *shadow) {
+	service := route.Mirror.Service
+
+	start := time.Now()
+	status, bodyHash, err := m.send(service, req)
+	elapsed := time.Since(start)
+
+	primary := <-s.primary
+
+	result := mirrorMatch
+	switch {
+	case err != nil:
+		result = mirrorError
+	case !primary.complete:
+		result = mirrorSkipped
+	case status != primary.status:
+		result = mirrorStatusMismatch
+	case bodyHash != primary.hash:
+		result = mirrorBodyMismatch
+	}
+	metrics.ObserveMirror(route.Name, service, result, elapsed)
+
+	if result == mirrorMatch || result == mirrorSkipped {
+		return
+	}
+	logPercent := route.Mirror.LogPercent
+	if logPercent == 0 {
+		logPercent = 1
+	}
+	if rand.Float64()*100 >= logPercent {
+		return
+	}
+
+	if err != nil {
+		log.Printf("Shadow request %s %s to %s failed: %v", req.Method, req.URL.Path, service, err)
+		return
+	}
+	log.Printf("Shadow %s differs from %s on %s %s: status %d vs %d, body %s vs %s",
+		service, route.Service, req.Method, req.URL.Path, status, primary.status, shortHash(bodyHash), shortHash(primary.hash))
+}
+
+// send forwards the shadow request to an instance of the service and returns
+// the status and hash of the response.
+func (m *mirror) send(service string, req *http.Request) (int, string, error) {
+	instance, err := m.loadBalancer.NextInstance(service, req, nil)
+	if err != nil {
+		return 0, "", err
+	}
+
+	target, err := url.Parse(instance.URL() + req.URL.RequestURI())
+	if err != nil {
+		m.loadBalancer.Release(service, instance.ID)
+		return 0, "", err
+	}
+	req.URL = target
+	req.Host = target.Host
+
+	start := time.Now()
+	resp, err := m.client.Do(req)
+	if err != nil {
+		m.loadBalancer.ReportResult(service, instance.ID, false, time.Since(start))
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, resp.Body)
+	m.loadBalancer.ReportResult(service, instance.ID, err == nil && resp.StatusCode < 500, time.Since(start))
+	if err != nil {
+		return 0, "", err
+	}
+	return resp.StatusCode, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// observe hashes the primary response body as it is relayed to the client.
+func (s *shadow) observe(resp *http.Response) {
+	if s == nil {
+		return
+	}
+	s.status = resp.StatusCode
+	s.body = &hashingBody{ReadCloser: resp.Body, hash: sha256.New()}
+	resp.Body = s.body
+}
+
+// finish hands the primary result to the shadow. It must be called exactly
+// once, after the primary response was written or the request abandoned.
+func (s *shadow) finish() {
+	if s == nil {
+		return
+	}
+	result := primaryResult{status: s.status}
+	if s.body != nil && s.body.eof {
+		result.hash = hex.EncodeToString(s.body.hash.Sum(nil))
+		result.complete = true
+	}
+	s.primary <- result
+}
+
+// hashingBody hashes a response body while it is read and records whether it
+// was read to the end.
+type hashingBody struct {
+	io.ReadCloser
+	hash hash.Hash
+	eof  bool
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
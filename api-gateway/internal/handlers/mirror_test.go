@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/routes"
+)
+
+// mirroredRequest is what a shadow service received.
+type mirroredRequest struct {
+	method string
+	shadow string
+	body   string
+}
+
+func recordShadow(received chan<- mirroredRequest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- mirroredRequest{method: r.Method, shadow: r.Header.Get(shadowHeader), body: string(body)}
+		w.Write([]byte(`{}`))
+	}
+}
+
+func nextShadow(t *testing.T, received <-chan mirroredRequest) mirroredRequest {
+	t.Helper()
+	select {
+	case req := <-received:
+		return req
+	case <-time.After(3 * time.Second):
+		t.Fatal("shadow service received nothing")
+		return mirroredRequest{}
+	}
+}
+
+func TestMirrorSendsOnlySafeMethodsByDefault(t *testing.T) {
+	received := make(chan mirroredRequest, 10)
+	route := &routes.Route{Name: "jars", PathPrefix: "/api/jars", Service: "jar-service", Mirror: &routes.Mirror{Service: "jar-service-v2"}}
+	gateway := newGateway(t, route, map[string]http.Handler{
+		"jar-service":    document(http.StatusOK, `{}`),
+		"jar-service-v2": recordShadow(received),
+	})
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodGet} {
+		req, _ := http.NewRequest(method, gateway.URL+"/api/jars/1", strings.NewReader(`{"price":10}`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// Shadows are sent in request order, so the GET being the first to arrive
+	// means no write was mirrored.
+	if req := nextShadow(t, received); req.method != http.MethodGet || req.shadow != "true" {
+		t.Fatalf("shadow received %s with %s %q, want a marked GET", req.method, shadowHeader, req.shadow)
+	}
+	select {
+	case req := <-received:
+		t.Fatalf("shadow received a second request: %s", req.method)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Writes are mirrored only when the route lists them, and then still carry the
+// header services use to refuse them.
+func TestMirrorMarksListedWrites(t *testing.T) {
+	received := make(chan mirroredRequest, 1)
+	route := &routes.Route{Name: "orders", PathPrefix: "/api/orders", Service: "order-service", Mirror: &routes.Mirror{Service: "order-service-v2", Methods: []string{http.MethodPost}}}
+	gateway := newGateway(t, route, map[string]http.Handler{
+		"order-service":    document(http.StatusCreated, `{}`),
+		"order-service-v2": recordShadow(received),
+	})
+
+	resp, err := http.Post(gateway.URL+"/api/orders", "application/json", strings.NewReader(`{"jar_id":"j1"}`))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d, want the primary's 201", resp.StatusCode)
+	}
+
+	req := nextShadow(t, received)
+	if req.method != http.MethodPost || req.shadow != "true" || req.body != `{"jar_id":"j1"}` {
+		t.Fatalf("shadow received %+v, want the marked POST and its body", req)
+	}
+}
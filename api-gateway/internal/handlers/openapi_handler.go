@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/middleware"
+	"github.com/0Bleak/api-gateway/internal/problem"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/requestid"
+	"github.com/0Bleak/api-gateway/internal/routes"
+)
+
+const (
+	specPath    = "/openapi.json"
+	specTimeout = 5 * time.Second
+	// maxSpecBody is the largest service document merged.
+	maxSpecBody = 4 << 20
+)
+
+// gatewaySpec documents the endpoints the gateway serves itself, under their
+// gateway paths.
+//
+//go:embed openapi_gateway.json
+var gatewaySpec []byte
+
+var operationMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// OpenAPIHandler serves one OpenAPI document for the whole gateway, merged
+// from the documents the services publish at /openapi.json. Only operations
+// a route sends to the service are kept, under their gateway paths.
+type OpenAPIHandler struct {
+	loadBalancer *proxy.LoadBalancer
+	router       *routes.Router
+	client       *http.Client
+	ttl          time.Duration
+
+	mu        sync.Mutex
+	spec      []byte
+	expiresAt time.Time
+}
+
+func NewOpenAPIHandler(lb *proxy.LoadBalancer, router *routes.Router, ttl time.Duration) *OpenAPIHandler {
+	client := newUpstreamClient()
+	client.Timeout = specTimeout
+	return &OpenAPIHandler{
+		loadBalancer: lb,
+		router:       router,
+		client:       client,
+		ttl:          ttl,
+	}
+}
+
+// ServeSpec returns the merged document. Complete documents are cached for
+// ttl; when a service cannot be reached its operations are left out, it is
+// listed under info.x-missing-services and the next request tries again.
+func (h *OpenAPIHandler) ServeSpec(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	spec, fresh := h.spec, time.Now().Before(h.expiresAt)
+	h.mu.Unlock()
+
+	if !fresh {
+		table := h.router.Table()
+		if table == nil {
+			problem.Write(w, r, http.StatusServiceUnavailable, "Route table not loaded")
+			return
+		}
+
+		doc, missing := h.merge(r.Context(), table)
+		data, err := json.Marshal(doc)
+		if err != nil {
+			log.Printf("Failed to encode openapi document: %v", err)
+			problem.Write(w, r, http.StatusInternalServerError, "Failed to encode openapi document")
+			return
+		}
+		spec = data
+
+		if len(missing) == 0 {
+			h.mu.Lock()
+			h.spec, h.expiresAt = spec, time.Now().Add(h.ttl)
+			h.mu.Unlock()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(spec)
+}
+
+// merge fetches every routed service's document and combines them. It
+// returns the services whose documents could not be fetched.
+func (h *OpenAPIHandler) merge(ctx context.Context, table *routes.Table) (map[string]any, []string) {
+	var services []string
+	seen := make(map[string]bool)
+	for _, route := range table.Routes {
+		if !seen[route.Service] {
+			seen[route.Service] = true
+			services = append(services, route.Service)
+		}
+	}
+	sort.Strings(services)
+
+	docs := make([]map[string]any, len(services))
+	errs := make([]error, len(services))
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func(i int, service string) {
+			defer wg.Done()
+			docs[i], errs[i] = h.fetch(ctx, service)
+		}(i, service)
+	}
+	wg.Wait()
+
+	merged := newMergedSpec()
+	var missing []string
+	for i, service := range services {
+		if errs[i] != nil {
+			log.Printf("Leaving %s out of the openapi document: %v", service, errs[i])
+			missing = append(missing, service)
+			continue
+		}
+		merged.add(service, docs[i], table)
+	}
+
+	var gateway map[string]any
+	if err := json.Unmarshal(gatewaySpec, &gateway); err != nil {
+		log.Printf("Leaving the gateway's endpoints out of the openapi document: %v", err)
+	} else {
+		merged.addGateway(gateway)
+	}
+
+	return merged.document(missing), missing
+}
+
+func (h *OpenAPIHandler) fetch(ctx context.Context, service string) (map[string]any, error) {
+	ctx, cancel := context.WithTimeout(ctx, specTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, specPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+
+	instance, err := h.loadBalancer.NextInstance(service, req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance: %w", err)
+	}
+	if req.URL, err = url.Parse(instance.URL() + specPath); err != nil {
+		h.loadBalancer.Release(service, instance.ID)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Host = req.URL.Host
+
+	start := time.Now()
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.loadBalancer.ReportResult(service, instance.ID, false, time.Since(start))
+		return nil, fmt.Errorf("failed to fetch document: %w", err)
+	}
+	defer resp.Body.Close()
+	h.loadBalancer.ReportResult(service, instance.ID, resp.StatusCode < 500, time.Since(start))
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("document request returned %d", resp.StatusCode)
+	}
+
+	var doc map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSpecBody)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	return doc, nil
+}
+
+// mergedSpec accumulates service documents into a single one.
+type mergedSpec struct {
+	paths      map[string]any
+	components map[string]map[string]any
+	tags       []any
+	tagNames   map[string]bool
+}
+
+func newMergedSpec() *mergedSpec {
+	return &mergedSpec{
+		paths: make(map[string]any),
+		components: map[string]map[string]any{
+			"securitySchemes": {
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey":     map[string]any{"type": "apiKey", "in": "header", "name": middleware.APIKeyHeader},
+			},
+		},
+		tagNames: make(map[string]bool),
+	}
+}
+
+// add merges the routed operations of a service document. Components that
+// clash with a different component of the same name are renamed after the
+// service and the references to them rewritten.
+func (m *mergedSpec) add(service string, doc map[string]any, table *routes.Table) {
+	doc = m.addComponents(service, doc)
+
+	used := false
+	paths, _ := doc["paths"].(map[string]any)
+	for path, item := range paths {
+		item, _ := item.(map[string]any)
+		for _, method := range operationMethods {
+			operation, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			route, gatewayPath := routeFor(table, service, strings.ToUpper(method), path)
+			if route == nil {
+				continue
+			}
+
+			if route.Public {
+				// The service may still require credentials itself.
+				if _, ok := operation["security"]; !ok {
+					operation["security"] = []any{}
+				}
+			} else {
+				operation["security"] = []any{
+					map[string]any{"bearerAuth": []any{}},
+					map[string]any{"apiKey": []any{}},
+				}
+			}
+			operation["x-gateway-route"] = route.Name
+
+			merged, _ := m.paths[gatewayPath].(map[string]any)
+			if merged == nil {
+				merged = make(map[string]any)
+				for key, value := range item {
+					if !isOperation(key) {
+						merged[key] = value
+					}
+				}
+				m.paths[gatewayPath] = merged
+			}
+			merged[method] = operation
+			used = true
+		}
+	}
+
+	if used {
+		m.addTags(doc)
+	}
+}
+
+// addGateway merges the document of the gateway's own endpoints, whose paths
+// and security are already those of the gateway.
+func (m *mergedSpec) addGateway(doc map[string]any) {
+	doc = m.addComponents("api-gateway", doc)
+
+	paths, _ := doc["paths"].(map[string]any)
+	for path, item := range paths {
+		m.paths[path] = item
+	}
+	m.addTags(doc)
+}
+
+// addComponents merges the components of a document and returns the document
+// with its references to renamed components rewritten.
+func (m *mergedSpec) addComponents(service string, doc map[string]any) map[string]any {
+	components, _ := doc["components"].(map[string]any)
+	renames := m.renames(service, components)
+	if len(renames) > 0 {
+		doc = rewriteRefs(doc, renames).(map[string]any)
+		components, _ = doc["components"].(map[string]any)
+	}
+
+	for kind, entries := range components {
+		if kind == "securitySchemes" {
+			continue
+		}
+		entries, _ := entries.(map[string]any)
+		if m.components[kind] == nil {
+			m.components[kind] = make(map[string]any)
+		}
+		for name, component := range entries {
+			if renamed, ok := renames[componentRef(kind, name)]; ok {
+				name = strings.TrimPrefix(renamed, "#/components/"+kind+"/")
+			}
+			m.components[kind][name] = component
+		}
+	}
+	return doc
+}
+
+func (m *mergedSpec) addTags(doc map[string]any) {
+	tags, _ := doc["tags"].([]any)
+	for _, tag := range tags {
+		name, _ := tag.(map[string]any)["name"].(string)
+		if !m.tagNames[name] {
+			m.tagNames[name] = true
+			m.tags = append(m.tags, tag)
+		}
+	}
+}
+
+// renames decides which components of a service document must be renamed.
+// A component is kept under its name when it is equal to the one already
+// merged, once the references it holds are rewritten, so renaming one
+// component can force renaming the components that refer to it.
+func (m *mergedSpec) renames(service string, components map[string]any) map[string]string {
+	renames := make(map[string]string)
+	for changed := true; changed; {
+		changed = false
+		rewritten, _ := rewriteRefs(components, renames).(map[string]any)
+		for kind, entries := range rewritten {
+			if kind == "securitySchemes" {
+				continue
+			}
+			entries, _ := entries.(map[string]any)
+			for name, component := range entries {
+				ref := componentRef(kind, name)
+				if _, ok := renames[ref]; ok {
+					continue
+				}
+				existing, ok := m.components[kind][name]
+				if ok && !reflect.DeepEqual(existing, component) {
+					renames[ref] = componentRef(kind, service+"."+name)
+					changed = true
+				}
+			}
+		}
+	}
+	return renames
+}
+
+func (m *mergedSpec) document(missing []string) map[string]any {
+	info := map[string]any{
+		"title":       "ClayJar API",
+		"version":     "1.0.0",
+		"description": "All routes served by the API gateway, merged from the documents of the services behind it.",
+	}
+	if len(missing) > 0 {
+		info["x-missing-services"] = missing
+	}
+
+	components := make(map[string]any, len(m.components))
+	for kind, entries := range m.components {
+		components[kind] = entries
+	}
+
+	doc := map[string]any{
+		"openapi":    "3.0.3",
+		"info":       info,
+		"paths":      m.paths,
+		"components": components,
+	}
+	if len(m.tags) > 0 {
+		doc["tags"] = m.tags
+	}
+	return doc
+}
+
+// routeFor maps an operation of the service to its gateway path and returns
+// the route that serves it there, or nil when no route does.
+func routeFor(table *routes.Table, service, method, path string) (*routes.Route, string) {
+	for _, route := range table.Routes {
+		if route.Service != service || !strings.HasPrefix(path, route.Rewrite.AddPrefix) {
+			continue
+		}
+		gatewayPath := route.Rewrite.StripPrefix + strings.TrimPrefix(path, route.Rewrite.AddPrefix)
+		if route.RewritePath(gatewayPath) != path {
+			continue
+		}
+
+		// The route must also be the one the gateway picks for the request.
+		matched, _ := table.Match(&http.Request{Method: method, URL: &url.URL{Path: gatewayPath}})
+		if matched == route {
+			return route, gatewayPath
+		}
+	}
+	return nil, ""
+}
+
+func rewriteRefs(value any, renames map[string]string) any {
+	if len(renames) == 0 {
+		return value
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		rewritten := make(map[string]any, len(value))
+		for key, item := range value {
+			if ref, ok := item.(string); ok && key == "$ref" {
+				if renamed, ok := renames[ref]; ok {
+					item = renamed
+				}
+			}
+			rewritten[key] = rewriteRefs(item, renames)
+		}
+		return rewritten
+	case []any:
+		rewritten := make([]any, len(value))
+		for i, item := range value {
+			rewritten[i] = rewriteRefs(item, renames)
+		}
+		return rewritten
+	default:
+		return value
+	}
+}
+
+func componentRef(kind, name string) string {
+	return "#/components/" + kind + "/" + name
+}
+
+func isOperation(key string) bool {
+	for _, method := range operationMethods {
+		if key == method {
+			return true
+		}
+	}
+	return false
+}
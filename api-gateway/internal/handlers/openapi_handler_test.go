@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/discovery/consultest"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/routes"
+)
+
+const specTable = `
+routes:
+  - name: jars
+    path_prefix: /api/jars
+    service: jar-service
+    rewrite: {strip_prefix: /api}
+  - name: jar-facets
+    path: /api/jars/facets
+    service: search-service
+    rewrite: {strip_prefix: /api/jars}
+  - name: orders
+    path_prefix: /api/orders
+    service: order-service
+    methods: [GET]
+    rewrite: {strip_prefix: /api/orders, add_prefix: /v1/orders}
+  - name: users-login
+    path: /api/users/login
+    service: user-service
+    methods: [POST]
+    public: true
+    rewrite: {strip_prefix: /api}
+`
+
+func parseSpecTable(t *testing.T) *routes.Table {
+	t.Helper()
+	table, err := routes.Parse([]byte(specTable))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return table
+}
+
+func TestRouteFor(t *testing.T) {
+	table := parseSpecTable(t)
+
+	tests := []struct {
+		name    string
+		service string
+		method  string
+		path    string
+		route   string
+		gateway string
+	}{
+		{"stripped prefix", "jar-service", "GET", "/jars/{id}", "jars", "/api/jars/{id}"},
+		{"prefix itself", "jar-service", "POST", "/jars", "jars", "/api/jars"},
+		{"added prefix", "order-service", "GET", "/v1/orders/{id}", "orders", "/api/orders/{id}"},
+		{"path outside the added prefix", "order-service", "GET", "/orders/{id}", "", ""},
+		{"method the route refuses", "order-service", "DELETE", "/v1/orders/{id}", "", ""},
+		{"shadowed by another service's route", "jar-service", "GET", "/jars/facets", "", ""},
+		{"exact path", "search-service", "GET", "/facets", "jar-facets", "/api/jars/facets"},
+		{"path of another service", "search-service", "GET", "/jars/{id}", "", ""},
+		{"unrouted path", "user-service", "GET", "/internal/api-keys/verify", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, gatewayPath := routeFor(table, tt.service, tt.method, tt.path)
+			var name string
+			if route != nil {
+				name = route.Name
+			}
+			if name != tt.route || gatewayPath != tt.gateway {
+				t.Fatalf("routeFor() = %q at %q, want %q at %q", name, gatewayPath, tt.route, tt.gateway)
+			}
+		})
+	}
+}
+
+// decode turns a JSON literal into the generic form documents are merged in.
+func decode(t *testing.T, doc string) map[string]any {
+	t.Helper()
+	var value map[string]any
+	if err := json.Unmarshal([]byte(doc), &value); err != nil {
+		t.Fatalf("invalid document %s: %v", doc, err)
+	}
+	return value
+}
+
+func TestMergeRoutedOperations(t *testing.T) {
+	m := newMergedSpec()
+	m.add("jar-service", decode(t, `{
+		"tags": [{"name": "jars"}],
+		"paths": {
+			"/jars/{id}": {"parameters": [{"name": "id", "in": "path"}], "get": {"operationId": "getJar"}},
+			"/jars/facets": {"get": {"operationId": "shadowedFacets"}},
+			"/internal/purge": {"post": {"operationId": "purge"}}
+		}
+	}`), parseSpecTable(t))
+	m.add("user-service", decode(t, `{
+		"tags": [{"name": "users"}, {"name": "internal"}],
+		"paths": {"/users/login": {"post": {"operationId": "login"}, "get": {"operationId": "loginPage"}}}
+	}`), parseSpecTable(t))
+	m.add("order-service", decode(t, `{
+		"tags": [{"name": "orders"}],
+		"paths": {"/internal/orders": {"get": {"operationId": "internalOrders"}}}
+	}`), parseSpecTable(t))
+
+	doc := m.document(nil)
+	want := decode(t, `{
+		"/api/jars/{id}": {
+			"parameters": [{"name": "id", "in": "path"}],
+			"get": {
+				"operationId": "getJar",
+				"x-gateway-route": "jars",
+				"security": [{"bearerAuth": []}, {"apiKey": []}]
+			}
+		},
+		"/api/users/login": {
+			"post": {"operationId": "login", "x-gateway-route": "users-login", "security": []}
+		}
+	}`)
+	if got := roundTrip(t, doc["paths"]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("paths = %v, want %v", got, want)
+	}
+
+	// Tags of services with no routed operation are left out.
+	if got := roundTrip(t, doc["tags"]); !reflect.DeepEqual(got, []any{map[string]any{"name": "jars"}, map[string]any{"name": "users"}, map[string]any{"name": "internal"}}) {
+		t.Fatalf("tags = %v", got)
+	}
+	if _, ok := doc["info"].(map[string]any)["x-missing-services"]; ok {
+		t.Fatal("complete document lists missing services")
+	}
+}
+
+func TestMergeRenamesClashingComponents(t *testing.T) {
+	table := parseSpecTable(t)
+	m := newMergedSpec()
+	m.add("jar-service", decode(t, `{
+		"paths": {"/jars/{id}": {"get": {"responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Jar"}}}}}}}},
+		"components": {"schemas": {
+			"Error": {"type": "object", "properties": {"title": {"type": "string"}}},
+			"Money": {"type": "number"},
+			"Jar": {"type": "object", "properties": {"price": {"$ref": "#/components/schemas/Money"}}}
+		}}
+	}`), table)
+	m.add("order-service", decode(t, `{
+		"paths": {"/v1/orders/{id}": {"get": {"responses": {
+			"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Jar"}}}},
+			"404": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+		}}}},
+		"components": {"schemas": {
+			"Error": {"type": "object", "properties": {"title": {"type": "string"}}},
+			"Money": {"type": "string"},
+			"Jar": {"type": "object", "properties": {"price": {"$ref": "#/components/schemas/Money"}}}
+		}}
+	}`), table)
+
+	doc := roundTrip(t, m.document(nil)).(map[string]any)
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+
+	// Error is identical and shared. Money differs, and Jar refers to it, so
+	// both are renamed although Jar reads the same.
+	var names []string
+	for name := range schemas {
+		names = append(names, name)
+	}
+	want := []string{"Error", "Jar", "Money", "order-service.Jar", "order-service.Money"}
+	if !sameStrings(names, want) {
+		t.Fatalf("schemas = %v, want %v", names, want)
+	}
+	if ref := schemas["order-service.Jar"].(map[string]any)["properties"].(map[string]any)["price"].(map[string]any)["$ref"]; ref != "#/components/schemas/order-service.Money" {
+		t.Fatalf("renamed Jar refers to %v", ref)
+	}
+	if ref := schemas["Jar"].(map[string]any)["properties"].(map[string]any)["price"].(map[string]any)["$ref"]; ref != "#/components/schemas/Money" {
+		t.Fatalf("first Jar refers to %v", ref)
+	}
+
+	responses := doc["paths"].(map[string]any)["/api/orders/{id}"].(map[string]any)["get"].(map[string]any)["responses"].(map[string]any)
+	ref := func(status string) any {
+		return responses[status].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)["$ref"]
+	}
+	if ref("200") != "#/components/schemas/order-service.Jar" || ref("404") != "#/components/schemas/Error" {
+		t.Fatalf("order operation refers to %v and %v", ref("200"), ref("404"))
+	}
+
+	jarRef := doc["paths"].(map[string]any)["/api/jars/{id}"].(map[string]any)["get"].(map[string]any)["responses"].(map[string]any)["200"].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)["$ref"]
+	if jarRef != "#/components/schemas/Jar" {
+		t.Fatalf("jar operation refers to %v", jarRef)
+	}
+}
+
+func TestServeSpec(t *testing.T) {
+	consul := consultest.NewServer(t)
+	fetches := 0
+	jars := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"paths": {"/jars": {"get": {"operationId": "listJars"}}}}`))
+	}))
+	t.Cleanup(jars.Close)
+	consul.SetInstances("jar-service", consultest.Instance(t, "jar-service-1", jars.URL, nil))
+
+	lb := proxy.NewLoadBalancer(consul.Client(t), proxy.OutlierSettings{})
+	t.Cleanup(lb.Close)
+	router := routes.NewRouter(func(route *routes.Route) http.Handler { return http.NotFoundHandler() })
+	router.Load(parseSpecTable(t))
+	h := NewOpenAPIHandler(lb, router, time.Minute)
+
+	serve := func() map[string]any {
+		rec := httptest.NewRecorder()
+		h.ServeSpec(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		return decode(t, rec.Body.String())
+	}
+
+	// The other routed services have no instances, so they are listed as
+	// missing and the document is not cached.
+	doc := serve()
+	missing := doc["info"].(map[string]any)["x-missing-services"]
+	if !reflect.DeepEqual(missing, []any{"order-service", "search-service", "user-service"}) {
+		t.Fatalf("x-missing-services = %v", missing)
+	}
+	if _, ok := doc["paths"].(map[string]any)["/api/jars"]; !ok {
+		t.Fatalf("paths = %v, want /api/jars", doc["paths"])
+	}
+	serve()
+	if fetches != 2 {
+		t.Fatalf("jar-service document fetched %d times, want 2 while incomplete", fetches)
+	}
+
+	// Once every service answers, the document is cached.
+	only, err := routes.Parse([]byte("routes:\n  - {name: jars, path_prefix: /api/jars, service: jar-service, rewrite: {strip_prefix: /api}}\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	router.Load(only)
+	doc = serve()
+	serve()
+	if _, ok := doc["info"].(map[string]any)["x-missing-services"]; ok {
+		t.Fatalf("complete document lists missing services: %v", doc["info"])
+	}
+	if fetches != 3 {
+		t.Fatalf("jar-service document fetched %d times, want 3 once complete", fetches)
+	}
+}
+
+func roundTrip(t *testing.T, value any) any {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("failed to encode %v: %v", value, err)
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to decode %s: %v", data, err)
+	}
+	return decoded
+}
+
+func sameStrings(got, want []string) bool {
+	counts := make(map[string]int)
+	for _, s := range got {
+		counts[s]++
+	}
+	for _, s := range want {
+		counts[s]--
+	}
+	for _, n := range counts {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/metrics"
+	"github.com/0Bleak/api-gateway/internal/problem"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/gorilla/mux"
+)
+
+const (
+	orderService     = "order-service"
+	paymentService   = "payment-service"
+	jarService       = "jar-service"
+	inventoryService = "inventory-service"
+)
+
+// orderDetailsRoute labels the upstream calls of the endpoint in metrics.
+const orderDetailsRoute = "order-details"
+
+// maxSectionBody is the largest upstream document merged into the response.
+const maxSectionBody = 1 << 20
+
+// Reasons a section is missing from the order details.
+const (
+	ReasonNotFound    = "not_found"
+	ReasonUnavailable = "unavailable"
+)
+
+// OrderDetails merges an order with its payment, jar and stock level. Sections
+// that could not be fetched are null and listed in Missing.
+type OrderDetails struct {
+	Order     json.RawMessage  `json:"order"`
+	Payment   json.RawMessage  `json:"payment"`
+	Jar       json.RawMessage  `json:"jar"`
+	Inventory json.RawMessage  `json:"inventory"`
+	Partial   bool             `json:"partial"`
+	Missing   []MissingSection `json:"missing,omitempty"`
+}
+
+type MissingSection struct {
+	Section string `json:"section"`
+	Reason  string `json:"reason"`
+}
+
+// OrderDetailsHandler serves a storefront page's worth of data in one call,
+// fanning out to the order, payment, jar and inventory services.
+type OrderDetailsHandler struct {
+	loadBalancer *proxy.LoadBalancer
+	breakers     *proxy.BreakerRegistry
+	retryPolicy  proxy.RetryPolicy
+	timeout      time.Duration
+	client       *http.Client
+}
+
+func NewOrderDetailsHandler(lb *proxy.LoadBalancer, breakers *proxy.BreakerRegistry, retryPolicy proxy.RetryPolicy, timeout time.Duration) *OrderDetailsHandler {
+	return &OrderDetailsHandler{
+		loadBalancer: lb,
+		breakers:     breakers,
+		retryPolicy:  retryPolicy,
+		timeout:      timeout,
+		client:       newUpstreamClient(),
+	}
+}
+
+// section is the outcome of fetching one part of the order details.
+type section struct {
+	body        json.RawMessage
+	contentType string
+	status      int
+	err         error
+}
+
+func (s section) missing(name string) *MissingSection {
+	switch {
+	case s.err == nil && s.status == http.StatusOK:
+		return nil
+	case s.err == nil && s.status == http.StatusNotFound:
+		return &MissingSection{Section: name, Reason: ReasonNotFound}
+	default:
+		return &MissingSection{Section: name, Reason: ReasonUnavailable}
+	}
+}
+
+// GetOrderDetails fetches the order and its payment in parallel, then the jar
+// and its inventory. Only the order is required; any other section that fails
+// is reported as missing and the response is marked partial.
+func (h *OrderDetailsHandler) GetOrderDetails(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var order, payment, jar, inventory section
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		order = h.get(ctx, r, orderService, "/orders/"+url.PathEscape(orderID))
+	}()
+	go func() {
+		defer wg.Done()
+		payment = h.get(ctx, r, paymentService, "/payments/order/"+url.PathEscape(orderID))
+	}()
+
+	wg.Wait()
+
+	if r.Context().Err() != nil {
+		return
+	}
+	switch {
+	case order.err != nil:
+		log.Printf("Failed to fetch order %s: %v", orderID, order.err)
+		problem.Write(w, r, http.StatusBadGateway, "Order service unavailable")
+		return
+	case order.status != http.StatusOK:
+		// Pass the order service's verdict on (not found, invalid id, ...).
+		w.Header().Set("Content-Type", order.contentType)
+		w.WriteHeader(order.status)
+		w.Write(order.body)
+		return
+	}
+
+	var header struct {
+		JarID string `json:"jar_id"`
+	}
+	if err := json.Unmarshal(order.body, &header); err != nil || header.JarID == "" {
+		log.Printf("Order %s has no readable jar_id: %v", orderID, err)
+		jar = section{err: errors.New("order has no jar_id")}
+		inventory = jar
+	} else {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			jar = h.get(ctx, r, jarService, "/jars/"+url.PathEscape(header.JarID))
+		}()
+		go func() {
+			defer wg.Done()
+			inventory = h.get(ctx, r, inventoryService, "/inventory/"+url.PathEscape(header.JarID))
+		}()
+		wg.Wait()
+	}
+
+	details := OrderDetails{Order: order.body}
+	for _, part := range []struct {
+		name   string
+		result section
+		target *json.RawMessage
+	}{
+		{"payment", payment, &details.Payment},
+		{"jar", jar, &details.Jar},
+		{"inventory", inventory, &details.Inventory},
+	} {
+		if missing := part.result.missing(part.name); missing != nil {
+			if part.result.err != nil {
+				log.Printf("Order details %s: %s unavailable: %v", orderID, part.name, part.result.err)
+			}
+			details.Missing = append(details.Missing, *missing)
+			continue
+		}
+		*part.target = part.result.body
+	}
+	details.Partial = len(details.Missing) > 0
+
+	respondWithJSON(w, http.StatusOK, details)
+}
+
+// get fetches a JSON document from the service through the load balancer and
+// circuit breaker, retrying on other instances like the proxy does.
+func (h *OrderDetailsHandler) get(ctx context.Context, r *http.Request, service, path string) section {
+	breaker := h.breakers.Get(service)
+	tried := make(map[string]bool)
+
+	for attempt := 1; ; attempt++ {
+		if err := breaker.Allow(); err != nil {
+			return section{err: err}
+		}
+
+		instance, err := h.loadBalancer.NextInstance(service, r, tried)
+		if err != nil {
+			breaker.Release()
+			return section{err: err}
+		}
+		tried[instance.ID] = true
+
+		start := time.Now()
+		result := h.fetch(ctx, r, instance, path)
+		if r.Context().Err() != nil {
+			// The client went away; the upstream is not to blame.
+			breaker.Release()
+			h.loadBalancer.Release(service, instance.ID)
+			return section{err: r.Context().Err()}
+		}
+		elapsed := time.Since(start)
+		metrics.ObserveUpstream(orderDetailsRoute, service, instance.ID, result.status, result.err, elapsed)
+
+		failed := result.err != nil || isRetryableStatus(result.status)
+		breaker.Record(!failed)
+		h.loadBalancer.ReportResult(service, instance.ID, result.err == nil && result.status < 500, elapsed)
+
+		if !failed || attempt >= h.retryPolicy.MaxAttempts {
+			return result
+		}
+
+		select {
+		case <-time.After(h.retryPolicy.BackoffFor(attempt)):
+		case <-ctx.Done():
+			return section{err: ctx.Err()}
+		}
+	}
+}
+
+func (h *OrderDetailsHandler) fetch(ctx context.Context, r *http.Request, instance *proxy.Instance, path string) section {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, instance.URL()+path, nil)
+	if err != nil {
+		return section{err: err}
+	}
+
+	// Forward the caller's identity and credentials, but ask for a plain body
+	// that can be merged.
+	req.Header = r.Header.Clone()
+	proxy.RemoveHopHeaders(req.Header)
+	req.Header.Del("Accept-Encoding")
+	req.Header.Set("Accept", "application/json")
+	proxy.SetForwardedHeaders(req.Header, r)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return section{err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSectionBody+1))
+	if err != nil {
+		return section{err: fmt.Errorf("failed to read response: %w", err)}
+	}
+	if len(body) > maxSectionBody {
+		return section{err: fmt.Errorf("response exceeds %d bytes", maxSectionBody)}
+	}
+	if resp.StatusCode == http.StatusOK && !json.Valid(body) {
+		return section{err: errors.New("response is not valid JSON")}
+	}
+
+	return section{body: body, contentType: resp.Header.Get("Content-Type"), status: resp.StatusCode}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/discovery/consultest"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/gorilla/mux"
+)
+
+// document answers every request with status and body as JSON.
+func document(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestGetOrderDetails(t *testing.T) {
+	order := document(http.StatusOK, `{"id":7,"jar_id":"j1","quantity":2}`)
+	payment := document(http.StatusOK, `{"order_id":7,"status":"paid"}`)
+	jar := document(http.StatusOK, `{"id":"j1","name":"Blue vase"}`)
+	inventory := document(http.StatusOK, `{"jar_id":"j1","available":3}`)
+
+	tests := []struct {
+		name     string
+		services map[string]http.Handler // services left out have no instances
+		status   int
+		missing  []MissingSection
+		present  []string
+	}{
+		{
+			name:     "every section",
+			services: map[string]http.Handler{orderService: order, paymentService: payment, jarService: jar, inventoryService: inventory},
+			status:   http.StatusOK,
+			present:  []string{"order", "payment", "jar", "inventory"},
+		},
+		{
+			name:     "no payment yet",
+			services: map[string]http.Handler{orderService: order, paymentService: document(http.StatusNotFound, `{"title":"Not Found"}`), jarService: jar, inventoryService: inventory},
+			status:   http.StatusOK,
+			missing:  []MissingSection{{Section: "payment", Reason: ReasonNotFound}},
+			present:  []string{"order", "jar", "inventory"},
+		},
+		{
+			name:     "jar service failing",
+			services: map[string]http.Handler{orderService: order, paymentService: payment, jarService: document(http.StatusInternalServerError, `{}`), inventoryService: inventory},
+			status:   http.StatusOK,
+			missing:  []MissingSection{{Section: "jar", Reason: ReasonUnavailable}},
+			present:  []string{"order", "payment", "inventory"},
+		},
+		{
+			name:     "inventory service without instances",
+			services: map[string]http.Handler{orderService: order, paymentService: payment, jarService: jar},
+			status:   http.StatusOK,
+			missing:  []MissingSection{{Section: "inventory", Reason: ReasonUnavailable}},
+			present:  []string{"order", "payment", "jar"},
+		},
+		{
+			name:     "jar that is not JSON",
+			services: map[string]http.Handler{orderService: order, paymentService: payment, jarService: document(http.StatusOK, `<html>`), inventoryService: inventory},
+			status:   http.StatusOK,
+			missing:  []MissingSection{{Section: "jar", Reason: ReasonUnavailable}},
+			present:  []string{"order", "payment", "inventory"},
+		},
+		{
+			name:     "order without a jar",
+			services: map[string]http.Handler{orderService: document(http.StatusOK, `{"id":7}`), paymentService: payment, jarService: jar, inventoryService: inventory},
+			status:   http.StatusOK,
+			missing:  []MissingSection{{Section: "jar", Reason: ReasonUnavailable}, {Section: "inventory", Reason: ReasonUnavailable}},
+			present:  []string{"order", "payment"},
+		},
+		{
+			name:     "everything but the order down",
+			services: map[string]http.Handler{orderService: order},
+			status:   http.StatusOK,
+			missing: []MissingSection{
+				{Section: "payment", Reason: ReasonUnavailable},
+				{Section: "jar", Reason: ReasonUnavailable},
+				{Section: "inventory", Reason: ReasonUnavailable},
+			},
+			present: []string{"order"},
+		},
+		{
+			name:     "order not found",
+			services: map[string]http.Handler{orderService: document(http.StatusNotFound, `{"title":"Order not found"}`), paymentService: payment, jarService: jar, inventoryService: inventory},
+			status:   http.StatusNotFound,
+		},
+		{
+			name:     "order service without instances",
+			services: map[string]http.Handler{paymentService: payment, jarService: jar, inventoryService: inventory},
+			status:   http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consul := consultest.NewServer(t)
+			for name, handler := range tt.services {
+				server := httptest.NewServer(handler)
+				t.Cleanup(server.Close)
+				consul.SetInstances(name, consultest.Instance(t, name+"-1", server.URL, nil))
+			}
+			lb := proxy.NewLoadBalancer(consul.Client(t), proxy.OutlierSettings{})
+			t.Cleanup(lb.Close)
+			breakers := proxy.NewBreakerRegistry(proxy.BreakerSettings{FailureThreshold: 5, OpenTimeout: time.Second, HalfOpenRequests: 1})
+			h := NewOrderDetailsHandler(lb, breakers, proxy.RetryPolicy{MaxAttempts: 1}, 5*time.Second)
+
+			router := mux.NewRouter()
+			router.HandleFunc("/api/orders/{id}/details", h.GetOrderDetails)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/orders/7/details", nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code == http.StatusNotFound && rec.Body.String() != `{"title":"Order not found"}` {
+				t.Fatalf("body = %s, want the order service's", rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var details OrderDetails
+			if err := json.Unmarshal(rec.Body.Bytes(), &details); err != nil {
+				t.Fatalf("invalid response %s: %v", rec.Body, err)
+			}
+			if !reflect.DeepEqual(details.Missing, tt.missing) {
+				t.Errorf("missing = %+v, want %+v", details.Missing, tt.missing)
+			}
+			if details.Partial != (len(tt.missing) > 0) {
+				t.Errorf("partial = %v with %d missing sections", details.Partial, len(tt.missing))
+			}
+
+			sections := map[string]json.RawMessage{"order": details.Order, "payment": details.Payment, "jar": details.Jar, "inventory": details.Inventory}
+			var present []string
+			for _, name := range []string{"order", "payment", "jar", "inventory"} {
+				if body := sections[name]; len(body) > 0 && string(body) != "null" {
+					present = append(present, name)
+				}
+			}
+			if !reflect.DeepEqual(present, tt.present) {
+				t.Errorf("sections present = %v, want %v", present, tt.present)
+			}
+		})
+	}
+}
@@ -1,68 +1,398 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/0Bleak/api-gateway/internal/metrics"
+	"github.com/0Bleak/api-gateway/internal/problem"
 	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/requestid"
+	"github.com/0Bleak/api-gateway/internal/routes"
+	"github.com/0Bleak/api-gateway/internal/tracing"
 )
 
+// maxReplayBody is the largest request body buffered so it can be replayed on retry.
+const maxReplayBody = 1 << 20
+
 type ProxyHandler struct {
-	loadBalancer *proxy.LoadBalancer
+	loadBalancer   *proxy.LoadBalancer
+	breakers       *proxy.BreakerRegistry
+	retryPolicy    proxy.RetryPolicy
+	defaultTimeout time.Duration
+	client         *http.Client
+	mirror         *mirror
 }
 
-func NewProxyHandler(lb *proxy.LoadBalancer) *ProxyHandler {
+// NewProxyHandler creates the handler proxying route traffic. At most
+// mirrorInflight shadow requests are outstanding; further ones are dropped.
+func NewProxyHandler(lb *proxy.LoadBalancer, breakers *proxy.BreakerRegistry, retryPolicy proxy.RetryPolicy, defaultTimeout time.Duration, mirrorInflight int) *ProxyHandler {
+	client := newUpstreamClient()
+
 	return &ProxyHandler{
-		loadBalancer: lb,
+		loadBalancer:   lb,
+		breakers:       breakers,
+		retryPolicy:    retryPolicy,
+		defaultTimeout: defaultTimeout,
+		client:         client,
+		mirror:         newMirror(lb, client, defaultTimeout, mirrorInflight),
 	}
 }
 
-func (h *ProxyHandler) ProxyToService(serviceName string) http.HandlerFunc {
+// newUpstreamClient returns the client used for every upstream call. It does
+// not follow redirects, which are passed on to the caller.
+func newUpstreamClient() *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   20,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		// Pass Accept-Encoding through untouched instead of decompressing for the client.
+		DisableCompression: true,
+	}
+
+	return &http.Client{
+		Transport: tracing.Transport(transport),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (h *ProxyHandler) ProxyToRoute(route *routes.Route) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		serviceURL, err := h.loadBalancer.GetServiceURL(serviceName)
+		breaker := h.breakers.Get(route.Service)
+		policy := h.policyFor(route, r.Method)
+
+		body, replayable, err := bufferBody(r)
 		if err != nil {
-			log.Printf("Failed to get service URL: %v", err)
-			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			log.Printf("Failed to read request body: %v", err)
+			problem.Write(w, r, http.StatusBadRequest, "Invalid request body")
 			return
 		}
-
-		path := strings.TrimPrefix(r.URL.Path, "/api")
-		targetURL := serviceURL + path
-		if r.URL.RawQuery != "" {
-			targetURL += "?" + r.URL.RawQuery
+		if !replayable {
+			policy.MaxAttempts = 1
 		}
 
-		proxyReq, err := http.NewRequest(r.Method, targetURL, r.Body)
-		if err != nil {
-			log.Printf("Failed to create proxy request: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+		// Only requests whose body could be buffered are mirrored; streams are not.
+		var shadow *shadow
+		if route.Mirror != nil && replayable && !proxy.IsUpgrade(r) && !acceptsEventStream(r) {
+			shadow = h.mirror.start(route, r, body)
 		}
+		defer shadow.finish()
+
+		tried := make(map[string]bool)
+		for attempt := 1; ; attempt++ {
+			if err := breaker.Allow(); err != nil {
+				retryAfter := int(math.Ceil(breaker.RetryAfter().Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+				problem.Write(w, r, http.StatusServiceUnavailable, "Service temporarily unavailable")
+				return
+			}
+
+			instance, err := h.loadBalancer.NextInstance(route.Service, r, tried)
+			if err != nil {
+				breaker.Release()
+				log.Printf("Failed to get service instance: %v", err)
+				problem.Write(w, r, http.StatusServiceUnavailable, "Service unavailable")
+				return
+			}
+			tried[instance.ID] = true
+
+			start := time.Now()
+			resp, cancel, err := h.forward(r, route, instance, body)
+			if r.Context().Err() != nil {
+				// The client went away; the upstream is not to blame.
+				breaker.Release()
+				h.loadBalancer.Release(route.Service, instance.ID)
+				if cancel != nil {
+					cancel()
+				}
+				return
+			}
+
+			elapsed := time.Since(start)
+			status := 0
+			if err == nil {
+				status = resp.StatusCode
+			}
+			metrics.ObserveUpstream(route.Name, route.Service, instance.ID, status, err, elapsed)
+
+			failed := err != nil || isRetryableStatus(status)
+			breaker.Record(!failed)
+			h.loadBalancer.ReportResult(route.Service, instance.ID, err == nil && status < 500, elapsed)
+
+			if !failed || attempt >= policy.MaxAttempts {
+				if err != nil {
+					cancel()
+					log.Printf("Failed to proxy request to %s (%s): %v", route.Service, instance.ID, err)
+					if errors.Is(err, context.DeadlineExceeded) {
+						problem.Write(w, r, http.StatusGatewayTimeout, "Upstream timeout")
+						return
+					}
+					problem.Write(w, r, http.StatusBadGateway, "Service unavailable")
+					return
+				}
+				if resp.StatusCode == http.StatusSwitchingProtocols {
+					h.tunnel(w, r, resp)
+				} else {
+					shadow.observe(resp)
+					h.writeResponse(w, resp)
+				}
+				cancel()
+				return
+			}
 
-		for key, values := range r.Header {
-			for _, value := range values {
-				proxyReq.Header.Add(key, value)
+			if err != nil {
+				log.Printf("Attempt %d to %s (%s) failed: %v", attempt, route.Service, instance.ID, err)
+			} else {
+				log.Printf("Attempt %d to %s (%s) returned %d", attempt, route.Service, instance.ID, resp.StatusCode)
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			cancel()
+
+			select {
+			case <-time.After(policy.BackoffFor(attempt)):
+			case <-r.Context().Done():
+				return
 			}
 		}
+	}
+}
 
-		client := &http.Client{}
-		resp, err := client.Do(proxyReq)
-		if err != nil {
-			log.Printf("Failed to proxy request: %v", err)
-			http.Error(w, "Service unavailable", http.StatusBadGateway)
-			return
+// forward sends one attempt to the instance. The returned cancel func releases
+// the attempt's timeout and must be called once the response has been consumed.
+// Streams (upgrades and server-sent events) live as long as the client stays, so
+// for them the timeout only bounds the wait for the response headers.
+func (h *ProxyHandler) forward(r *http.Request, route *routes.Route, instance *proxy.Instance, body []byte) (*http.Response, context.CancelFunc, error) {
+	targetURL := instance.URL() + route.RewritePath(r.URL.Path)
+	if r.URL.RawQuery != "" {
+		targetURL += "?" + r.URL.RawQuery
+	}
+
+	timeout := route.Timeout
+	if timeout <= 0 {
+		timeout = h.defaultTimeout
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var headerTimer *time.Timer
+	upgrade := proxy.IsUpgrade(r)
+	if upgrade || acceptsEventStream(r) {
+		ctx, cancel = context.WithCancel(r.Context())
+		headerTimer = time.AfterFunc(timeout, cancel)
+	} else {
+		ctx, cancel = context.WithTimeout(r.Context(), timeout)
+	}
+
+	var reqBody io.Reader = r.Body
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL, reqBody)
+	if err != nil {
+		return nil, cancel, err
+	}
+	if body != nil {
+		proxyReq.ContentLength = int64(len(body))
+	}
+
+	proxyReq.Header = r.Header.Clone()
+	proxy.RemoveHopHeaders(proxyReq.Header)
+	if upgrade {
+		proxyReq.Header.Set("Connection", "Upgrade")
+		proxyReq.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+	}
+	proxy.SetForwardedHeaders(proxyReq.Header, r)
+
+	resp, err := h.client.Do(proxyReq)
+	if headerTimer != nil && !headerTimer.Stop() {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, cancel, fmt.Errorf("no response headers within %v: %w", timeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		return nil, cancel, err
+	}
+	return resp, cancel, nil
+}
+
+// writeResponse relays the upstream response. Bodies of unknown length and
+// server-sent events are flushed as they arrive and are not cut off by the
+// server write timeout.
+func (h *ProxyHandler) writeResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+
+	proxy.RemoveHopHeaders(resp.Header)
+	// The gateway has already set its own request ID.
+	resp.Header.Del(requestid.Header)
+	header := w.Header()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			header.Add(key, value)
 		}
-		defer resp.Body.Close()
+	}
+	for key := range resp.Trailer {
+		header.Add("Trailer", key)
+	}
 
-		for key, values := range resp.Header {
-			for _, value := range values {
-				w.Header().Add(key, value)
+	controller := http.NewResponseController(w)
+	streaming := resp.ContentLength == -1 || isEventStream(resp.Header.Get("Content-Type"))
+	if streaming {
+		controller.SetWriteDeadline(time.Time{})
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	if streaming {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					return
+				}
+				controller.Flush()
 			}
+			if err != nil {
+				break
+			}
+		}
+	} else if _, err := io.Copy(w, resp.Body); err != nil {
+		return
+	}
+
+	for key, values := range resp.Trailer {
+		header[key] = values
+	}
+}
+
+// tunnel completes a protocol switch such as a WebSocket upgrade: it sends the
+// upstream's 101 response to the client and copies bytes both ways until
+// either side closes the connection.
+func (h *ProxyHandler) tunnel(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		log.Printf("Upstream switched protocols without a writable connection")
+		problem.Write(w, r, http.StatusBadGateway, "Service unavailable")
+		return
+	}
+	defer upstream.Close()
+
+	upgrade := resp.Header.Get("Upgrade")
+	proxy.RemoveHopHeaders(resp.Header)
+	// The gateway has already set its own request ID.
+	resp.Header.Del(requestid.Header)
+	header := w.Header()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			header.Add(key, value)
 		}
+	}
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", upgrade)
 
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Printf("Failed to take over client connection for upgrade: %v", err)
+		problem.Write(w, r, http.StatusInternalServerError, "Upgrade not supported")
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Time{})
+
+	if _, err := fmt.Fprintf(buf, "HTTP/1.1 %s\r\n", resp.Status); err != nil {
+		return
+	}
+	if err := header.Write(buf); err != nil {
+		return
 	}
+	if _, err := buf.WriteString("\r\n"); err != nil {
+		return
+	}
+	if err := buf.Flush(); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, buf.Reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (h *ProxyHandler) policyFor(route *routes.Route, method string) proxy.RetryPolicy {
+	policy := h.retryPolicy
+	if route.Retry != nil {
+		policy.MaxAttempts = route.Retry.Attempts
+		if route.Retry.Backoff > 0 {
+			policy.Backoff = route.Retry.Backoff
+		}
+	}
+	if policy.MaxAttempts < 1 || !proxy.IsIdempotent(method) {
+		policy.MaxAttempts = 1
+	}
+	return policy
+}
+
+// bufferBody reads small request bodies into memory so they can be replayed.
+// Larger bodies are streamed once and the request is not retried.
+func bufferBody(r *http.Request) ([]byte, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		r.Body = http.NoBody
+		return nil, true, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r.Body, maxReplayBody+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(buf) > maxReplayBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return nil, false, nil
+	}
+
+	return buf, true, nil
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func isEventStream(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/event-stream"
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
 }
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/discovery/consultest"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/routes"
+)
+
+// newGateway serves the route through a proxy handler that finds the upstream
+// services in Consul, each registered with one instance.
+func newGateway(t *testing.T, route *routes.Route, services map[string]http.Handler) *httptest.Server {
+	t.Helper()
+	consul := consultest.NewServer(t)
+	for name, handler := range services {
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		consul.SetInstances(name, consultest.Instance(t, name+"-1", server.URL, nil))
+	}
+
+	lb := proxy.NewLoadBalancer(consul.Client(t), proxy.OutlierSettings{})
+	t.Cleanup(lb.Close)
+	breakers := proxy.NewBreakerRegistry(proxy.BreakerSettings{FailureThreshold: 5, OpenTimeout: time.Second, HalfOpenRequests: 1})
+	h := NewProxyHandler(lb, breakers, proxy.RetryPolicy{MaxAttempts: 1}, 5*time.Second, 10)
+
+	gateway := httptest.NewServer(h.ProxyToRoute(route))
+	t.Cleanup(gateway.Close)
+	return gateway
+}
+
+func TestPolicyForRetryBudget(t *testing.T) {
+	h := &ProxyHandler{retryPolicy: proxy.RetryPolicy{MaxAttempts: 3, Backoff: 50 * time.Millisecond}}
+
+	tests := []struct {
+		name     string
+		retry    *routes.Retry
+		method   string
+		attempts int
+		backoff  time.Duration
+	}{
+		{"gateway default", nil, http.MethodGet, 3, 50 * time.Millisecond},
+		{"idempotent write", nil, http.MethodPut, 3, 50 * time.Millisecond},
+		{"never retries POST", nil, http.MethodPost, 1, 50 * time.Millisecond},
+		{"never retries PATCH", &routes.Retry{Attempts: 5}, http.MethodPatch, 1, 50 * time.Millisecond},
+		{"route override", &routes.Retry{Attempts: 5, Backoff: time.Second}, http.MethodGet, 5, time.Second},
+		{"route override keeps backoff", &routes.Retry{Attempts: 2}, http.MethodDelete, 2, 50 * time.Millisecond},
+		{"route without retries", &routes.Retry{Attempts: 1}, http.MethodGet, 1, 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := h.policyFor(&routes.Route{Name: "test", Retry: tt.retry}, tt.method)
+			if policy.MaxAttempts != tt.attempts {
+				t.Errorf("MaxAttempts = %d, want %d", policy.MaxAttempts, tt.attempts)
+			}
+			if policy.Backoff != tt.backoff {
+				t.Errorf("Backoff = %v, want %v", policy.Backoff, tt.backoff)
+			}
+		})
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusNotFound:            false,
+		http.StatusInternalServerError: false,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusGatewayTimeout:      true,
+	} {
+		if got := isRetryableStatus(status); got != want {
+			t.Errorf("isRetryableStatus(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestProxyStripsHopHeaders(t *testing.T) {
+	var received http.Header
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Proxy-Authenticate", "Basic")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	})
+	gateway := newGateway(t, &routes.Route{Name: "jars", PathPrefix: "/api/jars", Service: "jar-service"}, map[string]http.Handler{"jar-service": upstream})
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/api/jars", nil)
+	req.Header.Set("Connection", "X-Client-Hop")
+	req.Header.Set("X-Client-Hop", "1")
+	req.Header.Set("Proxy-Authorization", "Basic YWRtaW46YWRtaW4=")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	for _, name := range []string{"X-Client-Hop", "Proxy-Authorization", "Te"} {
+		if value := received.Get(name); value != "" {
+			t.Errorf("upstream received %s: %s", name, value)
+		}
+	}
+	if received.Get("Authorization") != "Bearer token" {
+		t.Errorf("upstream did not receive Authorization")
+	}
+	if received.Get("X-Forwarded-For") == "" || received.Get("Forwarded") == "" {
+		t.Errorf("upstream received no forwarding headers: %v", received)
+	}
+
+	for _, name := range []string{"X-Upstream-Hop", "Keep-Alive", "Proxy-Authenticate"} {
+		if value := resp.Header.Get(name); value != "" {
+			t.Errorf("client received %s: %s", name, value)
+		}
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("client did not receive Content-Type")
+	}
+}
+
+// echoUpgrade switches to a line echo protocol and echoes until the client
+// closes the connection.
+func echoUpgrade(t *testing.T, received chan<- http.Header) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		if !proxy.IsUpgrade(r) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("upstream failed to hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade, X-Upstream-Hop\r\nUpgrade: echo\r\nX-Upstream-Hop: 1\r\nX-Session: 7\r\n\r\n")
+		buf.Flush()
+
+		for {
+			line, err := buf.ReadString('\n')
+			if err != nil {
+				return
+			}
+			buf.WriteString("echo " + line)
+			buf.Flush()
+		}
+	}
+}
+
+func TestProxyTunnelsUpgrades(t *testing.T) {
+	received := make(chan http.Header, 1)
+	gateway := newGateway(t, &routes.Route{Name: "live", PathPrefix: "/live", Service: "live-service"}, map[string]http.Handler{"live-service": echoUpgrade(t, received)})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gateway.URL, "http://"))
+	if err != nil {
+		t.Fatalf("failed to dial gateway: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET /live HTTP/1.1\r\nHost: gateway\r\nConnection: keep-alive, Upgrade, X-Client-Hop\r\nUpgrade: echo\r\nX-Client-Hop: 1\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read upgrade response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	if resp.Header.Get("Upgrade") != "echo" || !strings.EqualFold(resp.Header.Get("Connection"), "Upgrade") {
+		t.Fatalf("upgrade headers = Connection %q, Upgrade %q", resp.Header.Get("Connection"), resp.Header.Get("Upgrade"))
+	}
+	if resp.Header.Get("X-Upstream-Hop") != "" || resp.Header.Get("X-Session") != "7" {
+		t.Fatalf("response headers = %v, want X-Session and no hop header", resp.Header)
+	}
+
+	upstream := <-received
+	if upstream.Get("Upgrade") != "echo" || !strings.EqualFold(upstream.Get("Connection"), "Upgrade") {
+		t.Fatalf("upstream upgrade headers = Connection %q, Upgrade %q", upstream.Get("Connection"), upstream.Get("Upgrade"))
+	}
+	if upstream.Get("X-Client-Hop") != "" {
+		t.Fatal("upstream received the client's hop header")
+	}
+
+	// Bytes flow both ways until the client hangs up.
+	for _, message := range []string{"ping", "pong"} {
+		fmt.Fprintf(conn, "%s\n", message)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read through the tunnel: %v", err)
+		}
+		if line != "echo "+message+"\n" {
+			t.Fatalf("tunnel returned %q, want %q", line, "echo "+message+"\n")
+		}
+	}
+}
+
+func TestProxyWithoutUpgradeAnswersPlainly(t *testing.T) {
+	received := make(chan http.Header, 1)
+	gateway := newGateway(t, &routes.Route{Name: "live", PathPrefix: "/live", Service: "live-service"}, map[string]http.Handler{"live-service": echoUpgrade(t, received)})
+
+	resp, err := http.Get(gateway.URL + "/live")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("status = %d, want 426", resp.StatusCode)
+	}
+}
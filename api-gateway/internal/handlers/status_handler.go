@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/discovery"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/0Bleak/api-gateway/internal/routes"
+)
+
+const (
+	StatusHealthy   = "healthy"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+
+	ServiceUp       = "up"
+	ServiceDegraded = "degraded"
+	ServiceDown     = "down"
+)
+
+// consulCheckTimeout bounds the Consul reachability check of /status.
+const consulCheckTimeout = 2 * time.Second
+
+type StatusReport struct {
+	Status    string          `json:"status"`
+	Consul    *ConsulStatus   `json:"consul,omitempty"`
+	Services  []ServiceStatus `json:"services"`
+	CheckedAt time.Time       `json:"checked_at"`
+}
+
+type ConsulStatus struct {
+	Reachable bool   `json:"reachable"`
+	Leader    string `json:"leader,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ServiceStatus is the gateway's view of one upstream: the healthy instances
+// Consul reports, how many of them outlier detection keeps in rotation, and
+// the circuit breaker state.
+type ServiceStatus struct {
+	Name           string `json:"name"`
+	Critical       bool   `json:"critical"`
+	Status         string `json:"status"`
+	Instances      int    `json:"instances"`
+	Available      int    `json:"available"`
+	CircuitBreaker string `json:"circuit_breaker"`
+}
+
+type StatusHandler struct {
+	consul       *discovery.ConsulClient
+	loadBalancer *proxy.LoadBalancer
+	breakers     *proxy.BreakerRegistry
+	router       *routes.Router
+}
+
+func NewStatusHandler(consul *discovery.ConsulClient, lb *proxy.LoadBalancer, breakers *proxy.BreakerRegistry, router *routes.Router) *StatusHandler {
+	return &StatusHandler{
+		consul:       consul,
+		loadBalancer: lb,
+		breakers:     breakers,
+		router:       router,
+	}
+}
+
+// Ready reports whether the gateway can serve traffic: a route table is loaded
+// and every critical service has at least one healthy instance. Consul itself
+// is not required, as the gateway keeps routing to the last known instances.
+// It is public, so it answers with a status code only.
+func (h *StatusHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	report := h.report(r.Context(), false)
+
+	code := http.StatusOK
+	if report.Status == StatusUnhealthy {
+		code = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(code)
+}
+
+// Status reports every upstream service and Consul reachability, on the admin
+// listener. The gateway is unhealthy (503) when a critical service has no
+// healthy instances, and degraded when any service is impaired or Consul is
+// unreachable.
+func (h *StatusHandler) Status(w http.ResponseWriter, r *http.Request) {
+	report := h.report(r.Context(), true)
+
+	code := http.StatusOK
+	if report.Status == StatusUnhealthy {
+		code = http.StatusServiceUnavailable
+	}
+	respondWithJSON(w, code, report)
+}
+
+func (h *StatusHandler) report(ctx context.Context, checkConsul bool) StatusReport {
+	report := StatusReport{
+		Status:    StatusHealthy,
+		Services:  []ServiceStatus{},
+		CheckedAt: time.Now().UTC(),
+	}
+
+	if checkConsul {
+		report.Consul = h.checkConsul(ctx)
+		if !report.Consul.Reachable {
+			report.Status = StatusDegraded
+		}
+	}
+
+	table := h.router.Table()
+	if table == nil {
+		report.Status = StatusUnhealthy
+		return report
+	}
+
+	instances := make(map[string]proxy.ServiceSnapshot)
+	for _, snapshot := range h.loadBalancer.Snapshots() {
+		instances[snapshot.Service] = snapshot
+	}
+	breakers := make(map[string]string)
+	for _, snapshot := range h.breakers.Snapshots() {
+		breakers[snapshot.Service] = snapshot.State
+	}
+
+	for _, name := range table.ServiceNames() {
+		service := ServiceStatus{
+			Name:           name,
+			CircuitBreaker: proxy.StateClosed.String(),
+		}
+		if config := table.Services[name]; config != nil {
+			service.Critical = config.Critical
+		}
+		if state, ok := breakers[name]; ok {
+			service.CircuitBreaker = state
+		}
+		for _, instance := range instances[name].Instances {
+			service.Instances++
+			if !instance.Ejected {
+				service.Available++
+			}
+		}
+
+		switch {
+		case service.Instances == 0:
+			service.Status = ServiceDown
+		case service.Available < service.Instances || service.CircuitBreaker != proxy.StateClosed.String():
+			service.Status = ServiceDegraded
+		default:
+			service.Status = ServiceUp
+		}
+
+		switch {
+		case service.Status == ServiceDown && service.Critical:
+			report.Status = StatusUnhealthy
+		case service.Status != ServiceUp && report.Status == StatusHealthy:
+			report.Status = StatusDegraded
+		}
+
+		report.Services = append(report.Services, service)
+	}
+
+	return report
+}
+
+func (h *StatusHandler) checkConsul(ctx context.Context) *ConsulStatus {
+	ctx, cancel := context.WithTimeout(ctx, consulCheckTimeout)
+	defer cancel()
+
+	leader, err := h.consul.Leader(ctx)
+	if err != nil {
+		return &ConsulStatus{Reachable: false, Error: err.Error()}
+	}
+	return &ConsulStatus{Reachable: true, Leader: leader}
+}
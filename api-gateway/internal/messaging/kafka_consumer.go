@@ -0,0 +1,117 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/requestid"
+	"github.com/segmentio/kafka-go"
+)
+
+// EventHandler is called for every message read from a watched topic with
+// the value of its "event-type" header. The context carries the message's
+// request ID.
+type EventHandler func(ctx context.Context, topic, eventType string)
+
+// TopicWatcher follows Kafka topics and reports every new message. Each
+// gateway replica uses its own consumer group so all of them see every event.
+type TopicWatcher struct {
+	brokers []string
+	groupID string
+	handle  EventHandler
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	readers map[string]*kafka.Reader
+}
+
+func NewTopicWatcher(brokers []string, groupID string, handle EventHandler) *TopicWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &TopicWatcher{
+		brokers: brokers,
+		groupID: groupID,
+		handle:  handle,
+		ctx:     ctx,
+		cancel:  cancel,
+		readers: make(map[string]*kafka.Reader),
+	}
+}
+
+// Watch starts consuming the topic from its latest offset. Watching a topic
+// twice does nothing.
+func (w *TopicWatcher) Watch(topic string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.readers[topic]; ok || w.ctx.Err() != nil {
+		return
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     w.brokers,
+		Topic:       topic,
+		GroupID:     w.groupID,
+		StartOffset: kafka.LastOffset,
+		MinBytes:    1,
+		MaxBytes:    10e6,
+		MaxWait:     500 * time.Millisecond,
+	})
+	w.readers[topic] = reader
+
+	go w.consume(topic, reader)
+	log.Printf("Watching topic %s", topic)
+}
+
+func (w *TopicWatcher) consume(topic string, reader *kafka.Reader) {
+	backoff := time.Second
+	for {
+		msg, err := reader.ReadMessage(w.ctx)
+		if err != nil {
+			if w.ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
+			log.Printf("Failed to read from topic %s, retrying in %v: %v", topic, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-w.ctx.Done():
+				return
+			}
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		backoff = time.Second
+
+		ctx, span := startProcessSpan(w.ctx, msg)
+		eventType := ""
+		for _, header := range msg.Headers {
+			switch header.Key {
+			case "event-type":
+				eventType = string(header.Value)
+			case requestid.MessageHeader:
+				ctx = requestid.NewContext(ctx, string(header.Value))
+			}
+		}
+		w.handle(ctx, topic, eventType)
+		span.End()
+	}
+}
+
+// Close stops every consumer.
+func (w *TopicWatcher) Close() error {
+	w.cancel()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var errs []error
+	for _, reader := range w.readers {
+		errs = append(errs, reader.Close())
+	}
+	return errors.Join(errs...)
+}
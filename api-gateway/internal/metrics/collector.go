@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var breakerStates = []string{
+	proxy.StateClosed.String(),
+	proxy.StateOpen.String(),
+	proxy.StateHalfOpen.String(),
+}
+
+// stateCollector reports load-balancer and circuit-breaker state at scrape time.
+type stateCollector struct {
+	loadBalancer *proxy.LoadBalancer
+	breakers     *proxy.BreakerRegistry
+
+	instances       *prometheus.Desc
+	ejected         *prometheus.Desc
+	inflight        *prometheus.Desc
+	lastUpdate      *prometheus.Desc
+	breakerState    *prometheus.Desc
+	breakerFailures *prometheus.Desc
+}
+
+// RegisterGatewayState exports the instance cache of the load balancer and
+// the state of every circuit breaker.
+func RegisterGatewayState(lb *proxy.LoadBalancer, breakers *proxy.BreakerRegistry) error {
+	return prometheus.Register(&stateCollector{
+		loadBalancer: lb,
+		breakers:     breakers,
+		instances: prometheus.NewDesc(namespace+"_upstream_instances",
+			"Healthy instances cached for the service.", []string{"service"}, nil),
+		ejected: prometheus.NewDesc(namespace+"_upstream_instances_ejected",
+			"Instances currently ejected by outlier detection.", []string{"service"}, nil),
+		inflight: prometheus.NewDesc(namespace+"_upstream_inflight_requests",
+			"Requests outstanding against an instance.", []string{"service", "upstream_instance"}, nil),
+		lastUpdate: prometheus.NewDesc(namespace+"_upstream_instances_updated_timestamp_seconds",
+			"When Consul last changed the instance set of the service.", []string{"service"}, nil),
+		breakerState: prometheus.NewDesc(namespace+"_circuit_breaker_state",
+			"Circuit breaker state of the service; 1 for the current state.", []string{"service", "state"}, nil),
+		breakerFailures: prometheus.NewDesc(namespace+"_circuit_breaker_consecutive_failures",
+			"Consecutive failures counted by the circuit breaker.", []string{"service"}, nil),
+	})
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.instances
+	ch <- c.ejected
+	ch <- c.inflight
+	ch <- c.lastUpdate
+	ch <- c.breakerState
+	ch <- c.breakerFailures
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, service := range c.loadBalancer.Snapshots() {
+		ejected := 0
+		for _, instance := range service.Instances {
+			if instance.Ejected {
+				ejected++
+			}
+			ch <- prometheus.MustNewConstMetric(c.inflight, prometheus.GaugeValue, float64(instance.Inflight), service.Service, instance.ID)
+		}
+		ch <- prometheus.MustNewConstMetric(c.instances, prometheus.GaugeValue, float64(len(service.Instances)), service.Service)
+		ch <- prometheus.MustNewConstMetric(c.ejected, prometheus.GaugeValue, float64(ejected), service.Service)
+		ch <- prometheus.MustNewConstMetric(c.lastUpdate, prometheus.GaugeValue, float64(service.UpdatedAt.Unix()), service.Service)
+	}
+
+	for _, breaker := range c.breakers.Snapshots() {
+		for _, state := range breakerStates {
+			value := 0.0
+			if breaker.State == state {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.breakerState, prometheus.GaugeValue, value, breaker.Service, state)
+		}
+		ch <- prometheus.MustNewConstMetric(c.breakerFailures, prometheus.GaugeValue, float64(breaker.ConsecutiveFailures), breaker.Service)
+	}
+}
@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gateway"
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Requests served on gateway routes.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time to serve requests on gateway routes, including retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	upstreamRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_requests_total",
+		Help:      "Attempts sent to upstream instances. Status is \"error\" or \"timeout\" when no response arrived.",
+	}, []string{"route", "service", "upstream_instance", "status"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time until an upstream instance returned response headers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "service", "upstream_instance"})
+
+	mirrorRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mirror_requests_total",
+		Help:      "Shadow requests by comparison with the primary: match, status_mismatch, body_mismatch, error, skipped or dropped.",
+	}, []string{"route", "service", "result"})
+
+	mirrorDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mirror_request_duration_seconds",
+		Help:      "Time until a shadow service returned its full response.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "service"})
+
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Requests on cached routes by result: hit, miss or bypass.",
+	}, []string{"route", "result"})
+
+	cacheInvalidationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_invalidations_total",
+		Help:      "Events that purged cached routes.",
+	}, []string{"topic", "event_type"})
+)
+
+// Handler serves the metrics of the default registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Instrument records the rate, status and latency of every request served by the route.
+func Instrument(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(recorder, r)
+
+			status := strconv.Itoa(recorder.statusCode())
+			method := methodLabel(r.Method)
+			requestsTotal.WithLabelValues(route, method, status).Inc()
+			requestDuration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// ObserveUpstream records one attempt against an upstream instance. status is
+// ignored when err is set.
+func ObserveUpstream(route, service, instance string, status int, err error, duration time.Duration) {
+	label := strconv.Itoa(status)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		label = "timeout"
+	case err != nil:
+		label = "error"
+	}
+
+	upstreamRequestsTotal.WithLabelValues(route, service, instance, label).Inc()
+	upstreamDuration.WithLabelValues(route, service, instance).Observe(duration.Seconds())
+}
+
+// ObserveMirror records the outcome of a shadow request. A zero duration means
+// the request was never sent.
+func ObserveMirror(route, service, result string, duration time.Duration) {
+	mirrorRequestsTotal.WithLabelValues(route, service, result).Inc()
+	if duration > 0 {
+		mirrorDuration.WithLabelValues(route, service).Observe(duration.Seconds())
+	}
+}
+
+// ObserveCache records the cache result of a request on a cached route.
+func ObserveCache(route, result string) {
+	cacheRequestsTotal.WithLabelValues(route, result).Inc()
+}
+
+// ObserveInvalidation records an event that purged the cache.
+func ObserveInvalidation(topic, eventType string) {
+	cacheInvalidationsTotal.WithLabelValues(topic, eventType).Inc()
+}
+
+// RegisterCacheStats exports the size of the response cache.
+func RegisterCacheStats(stats func() (int, int64)) error {
+	entries := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_entries",
+		Help:      "Responses held in the gateway cache.",
+	}, func() float64 {
+		n, _ := stats()
+		return float64(n)
+	})
+	size := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_bytes",
+		Help:      "Bytes held in the gateway cache.",
+	}, func() float64 {
+		_, b := stats()
+		return float64(b)
+	})
+
+	if err := prometheus.Register(entries); err != nil {
+		return err
+	}
+	return prometheus.Register(size)
+}
+
+// methodLabel folds non-standard methods into one label value to bound cardinality.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// statusRecorder captures the status code written by the wrapped handler.
+// Unwrap keeps http.ResponseController features such as flushing available.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack is used for protocol upgrades, which the handler answers with 101
+// directly on the connection.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+func (r *statusRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/0Bleak/api-gateway/internal/apikeys"
+	"github.com/0Bleak/api-gateway/internal/problem"
+	"github.com/0Bleak/api-gateway/internal/ratelimit"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Identity headers are set by the gateway after the token has been verified.
+// Any client supplied value is stripped so downstream services can trust them.
+const (
+	UserIDHeader    = "X-User-ID"
+	UserRoleHeader  = "X-User-Role"
+	UserEmailHeader = "X-User-Email"
+	APIKeyIDHeader  = "X-API-Key-ID"
+)
+
+// APIKeyHeader carries the API key of machine clients. It is never forwarded.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyRole is the role of every API key, whatever the role of its user, so
+// that services trusting X-User-Role never grant a key admin rights.
+const APIKeyRole = "partner"
+
+type identityKey struct{}
+
+// Identity holds the verified claims of the caller. Callers using an API key
+// act as the key's user with the APIKeyRole, limited to the key's scopes and
+// quota.
+type Identity struct {
+	UserID string
+	Role   string
+	Email  string
+	KeyID  string
+	Key    *apikeys.Key
+}
+
+// Allows reports whether the caller may use the method on the route. Only API
+// keys are restricted.
+func (i *Identity) Allows(route, method string) bool {
+	return i.Key == nil || i.Key.Allows(route, method)
+}
+
+// quota returns the API key's own quota, if it has one.
+func (i *Identity) quota() (ratelimit.Quota, bool) {
+	if i.Key == nil || i.Key.RequestsPerSecond <= 0 {
+		return ratelimit.Quota{}, false
+	}
+	return ratelimit.Quota{RequestsPerSecond: i.Key.RequestsPerSecond, Burst: i.Key.Burst}, true
+}
+
+// IdentityFromContext returns the identity attached by the auth middleware, if any.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+type Authenticator struct {
+	secret []byte
+	keys   *apikeys.Verifier
+}
+
+func NewAuthenticator(jwtSecret string, keys *apikeys.Verifier) *Authenticator {
+	return &Authenticator{
+		secret: []byte(jwtSecret),
+		keys:   keys,
+	}
+}
+
+// Authenticate verifies the Bearer token issued by user-service, or the API
+// key, and forwards the verified claims as identity headers. Public routes are
+// let through without credentials, but still get identity headers when valid
+// ones are supplied.
+func (a *Authenticator) Authenticate(public bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del(UserIDHeader)
+			r.Header.Del(UserRoleHeader)
+			r.Header.Del(UserEmailHeader)
+			r.Header.Del(APIKeyIDHeader)
+
+			var identity *Identity
+			var err error
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				r.Header.Del(APIKeyHeader)
+				identity, err = a.authenticateKey(r.Context(), key)
+			} else {
+				identity, err = a.authenticate(r)
+			}
+			if err != nil {
+				if public {
+					next.ServeHTTP(w, r)
+					return
+				}
+				log.Printf("Rejected %s %s: %v", r.Method, r.URL.Path, err)
+				if errors.Is(err, errKeyStoreUnavailable) {
+					problem.Write(w, r, http.StatusServiceUnavailable, "Service unavailable")
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="clayjar"`)
+				problem.Write(w, r, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+			r.Header.Set(UserIDHeader, identity.UserID)
+			r.Header.Set(UserRoleHeader, identity.Role)
+			if identity.Email != "" {
+				r.Header.Set(UserEmailHeader, identity.Email)
+			}
+			if identity.KeyID != "" {
+				r.Header.Set(APIKeyIDHeader, identity.KeyID)
+			}
+
+			ctx := context.WithValue(r.Context(), identityKey{}, identity)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireScope rejects API keys that are not scoped for the route. Requests
+// authenticated with a user token are not restricted.
+func RequireScope(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if identity, ok := IdentityFromContext(r.Context()); ok && !identity.Allows(route, r.Method) {
+				problem.Write(w, r, http.StatusForbidden, "API key not allowed on this route")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole only lets through users signed in with a token carrying the
+// role; API keys are refused. It must run after Authenticate.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := IdentityFromContext(r.Context())
+			if !ok || identity.Key != nil || identity.Role != role {
+				problem.Write(w, r, http.StatusForbidden, "Forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// errKeyStoreUnavailable means API keys could not be checked, which is not the
+// caller's fault.
+var errKeyStoreUnavailable = errors.New("API key verification unavailable")
+
+func (a *Authenticator) authenticateKey(ctx context.Context, key string) (*Identity, error) {
+	if a.keys == nil {
+		return nil, errors.New("API keys are not accepted")
+	}
+
+	verified, err := a.keys.Verify(ctx, key)
+	if errors.Is(err, apikeys.ErrInvalidKey) {
+		return nil, errors.New("Invalid API key")
+	}
+	if err != nil {
+		log.Printf("Failed to verify API key: %v", err)
+		return nil, errKeyStoreUnavailable
+	}
+
+	return &Identity{
+		UserID: verified.UserID,
+		Role:   APIKeyRole,
+		Email:  verified.Email,
+		KeyID:  verified.ID,
+		Key:    verified,
+	}, nil
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (*Identity, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, errors.New("Missing authorization header")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, errors.New("Invalid authorization header")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(parts[1], claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return a.secret, nil
+	}, jwt.WithExpirationRequired())
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, errors.New("Token expired")
+	}
+	if err != nil {
+		return nil, errors.New("Invalid token")
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, errors.New("Invalid user ID in token")
+	}
+
+	role, _ := claims["role"].(string)
+	email, _ := claims["email"].(string)
+
+	return &Identity{
+		UserID: strconv.FormatInt(int64(userID), 10),
+		Role:   role,
+		Email:  email,
+	}, nil
+}
@@ -0,0 +1,348 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/apikeys"
+	"github.com/0Bleak/api-gateway/internal/discovery/consultest"
+	"github.com/0Bleak/api-gateway/internal/proxy"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	jwtSecret     = "jwt-secret"
+	internalToken = "internal-token"
+)
+
+// Keys known to the user-service stub. The admin key belongs to an admin.
+const (
+	adminKey   = "cj_admin"
+	revokedKey = "cj_revoked"
+	outageKey  = "cj_outage"
+)
+
+// newUserService stands in for user-service's key verification endpoint.
+func newUserService(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Internal-Token") != internalToken {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Key string `json:"key"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		switch req.Key {
+		case adminKey:
+			json.NewEncoder(w).Encode(map[string]any{
+				"key_id":  7,
+				"user_id": 42,
+				"email":   "admin@example.com",
+				"role":    "admin",
+				"scopes":  []string{"orders:GET", "inventory"},
+			})
+		case outageKey:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			// Unknown and revoked keys alike.
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	users := newUserService(t)
+	consul := consultest.NewServer(t)
+	consul.SetInstances("user-service", consultest.Instance(t, "user-service-1", users.URL, nil))
+	lb := proxy.NewLoadBalancer(consul.Client(t), proxy.OutlierSettings{})
+	t.Cleanup(lb.Close)
+
+	return NewAuthenticator(jwtSecret, apikeys.NewVerifier(lb, "user-service", internalToken, time.Minute))
+}
+
+// upstream records the request the middleware let through.
+type upstream struct {
+	called   bool
+	header   http.Header
+	identity *Identity
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.called = true
+	u.header = r.Header.Clone()
+	u.identity, _ = IdentityFromContext(r.Context())
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	auth := newAuthenticator(t)
+
+	tests := []struct {
+		name    string
+		key     string
+		public  bool
+		status  int
+		headers map[string]string
+	}{
+		{
+			name:   "active key acts as its user with the partner role",
+			key:    adminKey,
+			status: http.StatusOK,
+			headers: map[string]string{
+				UserIDHeader:    "42",
+				UserRoleHeader:  APIKeyRole,
+				UserEmailHeader: "admin@example.com",
+				APIKeyIDHeader:  "7",
+				APIKeyHeader:    "",
+			},
+		},
+		{name: "unknown key", key: "cj_unknown", status: http.StatusUnauthorized},
+		{name: "revoked key", key: revokedKey, status: http.StatusUnauthorized},
+		{name: "key store outage", key: outageKey, status: http.StatusServiceUnavailable},
+		{
+			name:    "unknown key on a public route",
+			key:     "cj_unknown",
+			public:  true,
+			status:  http.StatusOK,
+			headers: map[string]string{UserIDHeader: "", UserRoleHeader: "", APIKeyIDHeader: "", APIKeyHeader: ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &upstream{}
+			r := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+			r.Header.Set(APIKeyHeader, tt.key)
+			rec := httptest.NewRecorder()
+			auth.Authenticate(tt.public)(next).ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if next.called != (tt.status == http.StatusOK) {
+				t.Fatalf("upstream called = %v", next.called)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			for header, want := range tt.headers {
+				if got := next.header.Get(header); got != want {
+					t.Errorf("forwarded %s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	scoped := &Identity{UserID: "42", Role: APIKeyRole, Key: &apikeys.Key{Scopes: []string{"orders:GET", "inventory"}}}
+	user := &Identity{UserID: "42", Role: "customer"}
+
+	tests := []struct {
+		name     string
+		identity *Identity
+		route    string
+		method   string
+		status   int
+	}{
+		{"scope limited to the method", scoped, "orders", http.MethodGet, http.StatusOK},
+		{"other method of a limited scope", scoped, "orders", http.MethodPost, http.StatusForbidden},
+		{"scope for every method", scoped, "inventory", http.MethodDelete, http.StatusOK},
+		{"route outside the scopes", scoped, "jars", http.MethodGet, http.StatusForbidden},
+		{"user tokens are not scoped", user, "jars", http.MethodPost, http.StatusOK},
+		{"anonymous callers are not scoped", nil, "jars", http.MethodGet, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &upstream{}
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.identity != nil {
+				r = r.WithContext(context.WithValue(r.Context(), identityKey{}, tt.identity))
+			}
+			rec := httptest.NewRecorder()
+			RequireScope(tt.route)(next).ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if next.called != (tt.status == http.StatusOK) {
+				t.Fatalf("upstream called = %v", next.called)
+			}
+		})
+	}
+}
+
+// An admin's key must not pass for the admin.
+func TestRequireRoleRefusesAPIKeys(t *testing.T) {
+	auth := newAuthenticator(t)
+	next := &upstream{}
+	handler := auth.Authenticate(false)(RequireRole("admin")(next))
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/routes", nil)
+	r.Header.Set(APIKeyHeader, adminKey)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusForbidden || next.called {
+		t.Fatalf("status = %d, upstream called = %v; want 403", rec.Code, next.called)
+	}
+}
+
+// token signs claims with the method and key given; user-service signs
+// HS256 tokens with the shared secret.
+func token(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func userClaims(role string, expiresIn time.Duration) jwt.MapClaims {
+	return jwt.MapClaims{
+		"user_id": 42,
+		"email":   "buyer@example.com",
+		"role":    role,
+		"exp":     time.Now().Add(expiresIn).Unix(),
+	}
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	secret := []byte(jwtSecret)
+	noExpiry := userClaims("customer", time.Hour)
+	delete(noExpiry, "exp")
+	noUserID := userClaims("customer", time.Hour)
+	delete(noUserID, "user_id")
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"HS256 token", "Bearer " + token(t, jwt.SigningMethodHS256, secret, userClaims("customer", time.Hour)), http.StatusOK},
+		{"HS512 token", "Bearer " + token(t, jwt.SigningMethodHS512, secret, userClaims("customer", time.Hour)), http.StatusOK},
+		{"expired token", "Bearer " + token(t, jwt.SigningMethodHS256, secret, userClaims("customer", -time.Minute)), http.StatusUnauthorized},
+		{"token without exp", "Bearer " + token(t, jwt.SigningMethodHS256, secret, noExpiry), http.StatusUnauthorized},
+		{"token without user_id", "Bearer " + token(t, jwt.SigningMethodHS256, secret, noUserID), http.StatusUnauthorized},
+		{"token signed with another secret", "Bearer " + token(t, jwt.SigningMethodHS256, []byte("other"), userClaims("customer", time.Hour)), http.StatusUnauthorized},
+		{"alg none", "Bearer " + token(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, userClaims("admin", time.Hour)), http.StatusUnauthorized},
+		{"alg RS256", "Bearer " + token(t, jwt.SigningMethodRS256, rsaKey, userClaims("admin", time.Hour)), http.StatusUnauthorized},
+		{"not a bearer token", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"no credentials", "", http.StatusUnauthorized},
+	}
+
+	auth := NewAuthenticator(jwtSecret, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, public := range []bool{false, true} {
+				next := &upstream{}
+				r := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+				if tt.authorization != "" {
+					r.Header.Set("Authorization", tt.authorization)
+				}
+				// Identity headers sent by the client are never trusted.
+				r.Header.Set(UserIDHeader, "1")
+				r.Header.Set(UserRoleHeader, "admin")
+				r.Header.Set(UserEmailHeader, "admin@example.com")
+				r.Header.Set(APIKeyIDHeader, "1")
+				rec := httptest.NewRecorder()
+				auth.Authenticate(public)(next).ServeHTTP(rec, r)
+
+				valid := tt.status == http.StatusOK
+				switch {
+				case valid:
+					if rec.Code != http.StatusOK || next.identity == nil {
+						t.Fatalf("public=%v: status = %d, identity = %v; want the token's identity", public, rec.Code, next.identity)
+					}
+					assertForwarded(t, next.header, "42", "customer", "buyer@example.com")
+				case public:
+					// Bad credentials on a public route fall through as anonymous.
+					if rec.Code != http.StatusOK || next.identity != nil {
+						t.Fatalf("public: status = %d, identity = %v; want anonymous", rec.Code, next.identity)
+					}
+					assertForwarded(t, next.header, "", "", "")
+				default:
+					if rec.Code != http.StatusUnauthorized || next.called {
+						t.Fatalf("status = %d, upstream called = %v; want 401", rec.Code, next.called)
+					}
+					if rec.Header().Get("WWW-Authenticate") == "" {
+						t.Error("401 without WWW-Authenticate")
+					}
+				}
+				if next.called && next.header.Get(APIKeyIDHeader) != "" {
+					t.Errorf("public=%v: client %s forwarded", public, APIKeyIDHeader)
+				}
+			}
+		})
+	}
+}
+
+// TestAdminRouter checks the chain the admin listener runs.
+func TestAdminRouter(t *testing.T) {
+	secret := []byte(jwtSecret)
+	handler := func(next http.Handler) http.Handler {
+		return NewAuthenticator(jwtSecret, nil).Authenticate(false)(RequireRole("admin")(next))
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"admin token", http.Header{"Authorization": {"Bearer " + token(t, jwt.SigningMethodHS256, secret, userClaims("admin", time.Hour))}}, http.StatusOK},
+		{"customer token", http.Header{"Authorization": {"Bearer " + token(t, jwt.SigningMethodHS256, secret, userClaims("customer", time.Hour))}}, http.StatusForbidden},
+		{
+			"customer token with a spoofed role header",
+			http.Header{
+				"Authorization": {"Bearer " + token(t, jwt.SigningMethodHS256, secret, userClaims("customer", time.Hour))},
+				UserRoleHeader:  {"admin"},
+			},
+			http.StatusForbidden,
+		},
+		{"spoofed role header alone", http.Header{UserRoleHeader: {"admin"}}, http.StatusUnauthorized},
+		{"expired admin token", http.Header{"Authorization": {"Bearer " + token(t, jwt.SigningMethodHS256, secret, userClaims("admin", -time.Minute))}}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &upstream{}
+			r := httptest.NewRequest(http.MethodGet, "/admin/routes", nil)
+			r.Header = tt.header.Clone()
+			rec := httptest.NewRecorder()
+			handler(next).ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if next.called != (tt.status == http.StatusOK) {
+				t.Fatalf("upstream called = %v", next.called)
+			}
+		})
+	}
+}
+
+func assertForwarded(t *testing.T, header http.Header, userID, role, email string) {
+	t.Helper()
+	for name, want := range map[string]string{UserIDHeader: userID, UserRoleHeader: role, UserEmailHeader: email} {
+		if got := header.Get(name); got != want {
+			t.Errorf("forwarded %s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -4,16 +4,19 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/0Bleak/api-gateway/internal/requestid"
 )
 
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		id := requestid.FromContext(r.Context())
 
-		log.Printf("[%s] %s %s", r.Method, r.RequestURI, r.RemoteAddr)
+		log.Printf("[%s] %s %s request_id=%s", r.Method, r.RequestURI, r.RemoteAddr, id)
 
 		next.ServeHTTP(w, r)
 
-		log.Printf("[%s] %s completed in %v", r.Method, r.RequestURI, time.Since(start))
+		log.Printf("[%s] %s completed in %v request_id=%s", r.Method, r.RequestURI, time.Since(start), id)
 	})
 }
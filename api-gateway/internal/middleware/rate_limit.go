@@ -1,38 +1,87 @@
 package middleware
 
 import (
+	"fmt"
+	"log"
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
 
-	"golang.org/x/time/rate"
+	"github.com/0Bleak/api-gateway/internal/problem"
+	"github.com/0Bleak/api-gateway/internal/ratelimit"
 )
 
-type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
+// AnonymousTier is the quota tier of requests without a verified identity.
+const AnonymousTier = "anonymous"
+
+// RatePolicy selects the quota of a request by the caller's role, or the
+// anonymous tier, falling back to Default. API keys with a quota of their own
+// use it instead.
+type RatePolicy struct {
+	Default ratelimit.Quota
+	Tiers   map[string]ratelimit.Quota
+}
+
+func (p RatePolicy) quotaFor(identity *Identity) ratelimit.Quota {
+	tier := AnonymousTier
+	if identity != nil {
+		tier = identity.Role
+	}
+
+	quota, ok := p.Tiers[tier]
+	if !ok {
+		quota = p.Default
+	}
+	if identity != nil {
+		if own, ok := identity.quota(); ok {
+			quota = own
+		}
+	}
+	if quota.Burst <= 0 {
+		quota.Burst = int(math.Ceil(quota.RequestsPerSecond * 2))
+	}
+	if quota.Burst < 1 {
+		quota.Burst = 1
+	}
+	return quota
+}
+
+// RateLimiter enforces quotas per client: the API key, the authenticated user,
+// or the client IP for anonymous requests. Buckets live in the store, so a shared
+// store gives every gateway replica the same budget.
+type RateLimiter struct {
+	store    ratelimit.Store
+	clientIP *ClientIPResolver
 }
 
-var visitors = make(map[string]*visitor)
-var mu sync.Mutex
+func NewRateLimiter(store ratelimit.Store, clientIP *ClientIPResolver) *RateLimiter {
+	return &RateLimiter{
+		store:    store,
+		clientIP: clientIP,
+	}
+}
 
-func RateLimitMiddleware(requestsPerSecond int) func(http.Handler) http.Handler {
+// Limit returns a middleware enforcing the policy for each client within
+// scope. Requests over quota are answered with 429 and Retry-After; every
+// response carries the RateLimit-* headers of its bucket.
+func (l *RateLimiter) Limit(scope string, policy RatePolicy) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := r.RemoteAddr
-
-			mu.Lock()
-			v, exists := visitors[ip]
-			if !exists {
-				limiter := rate.NewLimiter(rate.Limit(requestsPerSecond), requestsPerSecond*2)
-				visitors[ip] = &visitor{limiter, time.Now()}
-				v = visitors[ip]
+			identity, _ := IdentityFromContext(r.Context())
+			quota := policy.quotaFor(identity)
+
+			result, err := l.store.Allow(r.Context(), scope+":"+l.clientKey(r, identity), quota)
+			if err != nil {
+				log.Printf("Rate limit check failed, allowing request: %v", err)
+				next.ServeHTTP(w, r)
+				return
 			}
-			v.lastSeen = time.Now()
-			mu.Unlock()
 
-			if !v.limiter.Allow() {
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			setRateLimitHeaders(w.Header(), quota, result)
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				problem.Write(w, r, http.StatusTooManyRequests, "Rate limit exceeded")
 				return
 			}
 
@@ -41,20 +90,42 @@ func RateLimitMiddleware(requestsPerSecond int) func(http.Handler) http.Handler
 	}
 }
 
-func init() {
-	go cleanupVisitors()
+// LimitAPIKeys is Limit for requests made with an API key; other requests
+// pass through. It must run after Authenticate, which resolves the key.
+func (l *RateLimiter) LimitAPIKeys(scope string, policy RatePolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limited := l.Limit(scope, policy)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if identity, ok := IdentityFromContext(r.Context()); ok && identity.KeyID != "" {
+				limited.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-func cleanupVisitors() {
-	for {
-		time.Sleep(time.Minute)
-
-		mu.Lock()
-		for ip, v := range visitors {
-			if time.Since(v.lastSeen) > 3*time.Minute {
-				delete(visitors, ip)
-			}
-		}
-		mu.Unlock()
+func (l *RateLimiter) clientKey(r *http.Request, identity *Identity) string {
+	if identity != nil && identity.KeyID != "" {
+		return "key:" + identity.KeyID
 	}
+	if identity != nil && identity.UserID != "" {
+		return "user:" + identity.UserID
+	}
+	return "ip:" + l.clientIP.ClientIP(r)
+}
+
+// setRateLimitHeaders follows the IETF RateLimit header fields draft. The
+// policy window is the time an empty bucket takes to refill.
+func setRateLimitHeaders(header http.Header, quota ratelimit.Quota, result ratelimit.Result) {
+	window := ceilSeconds(time.Duration(float64(quota.Burst) / quota.RequestsPerSecond * float64(time.Second)))
+
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit, window))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return max(int(math.Ceil(d.Seconds())), 0)
 }
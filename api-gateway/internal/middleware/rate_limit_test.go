@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/ratelimit"
+)
+
+// stubStore answers every request with the same result and records the keys
+// and quotas it was asked about.
+type stubStore struct {
+	result ratelimit.Result
+	err    error
+	keys   []string
+	quotas []ratelimit.Quota
+}
+
+func (s *stubStore) Allow(ctx context.Context, key string, quota ratelimit.Quota) (ratelimit.Result, error) {
+	s.keys = append(s.keys, key)
+	s.quotas = append(s.quotas, quota)
+	return s.result, s.err
+}
+
+func (s *stubStore) Backlog(ctx context.Context, key string) (time.Duration, error) {
+	return 0, nil
+}
+
+func TestLimitHeaders(t *testing.T) {
+	quota := ratelimit.Quota{RequestsPerSecond: 2, Burst: 3}
+
+	tests := []struct {
+		name       string
+		result     ratelimit.Result
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{
+			name:      "allowed",
+			result:    ratelimit.Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: 500 * time.Millisecond},
+			status:    http.StatusOK,
+			remaining: "2",
+			reset:     "1",
+		},
+		{
+			name:       "rejected",
+			result:     ratelimit.Result{Limit: 3, ResetAfter: 1500 * time.Millisecond, RetryAfter: 300 * time.Millisecond},
+			status:     http.StatusTooManyRequests,
+			remaining:  "0",
+			reset:      "2",
+			retryAfter: "1",
+		},
+		{
+			name:       "rejected for several seconds",
+			result:     ratelimit.Result{Limit: 3, ResetAfter: 4 * time.Second, RetryAfter: 2500 * time.Millisecond},
+			status:     http.StatusTooManyRequests,
+			remaining:  "0",
+			reset:      "4",
+			retryAfter: "3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(&stubStore{result: tt.result}, &ClientIPResolver{})
+			handler := limiter.Limit("route:test", RatePolicy{Default: quota})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			for header, want := range map[string]string{
+				"RateLimit-Limit":     "3",
+				"RateLimit-Remaining": tt.remaining,
+				"RateLimit-Reset":     tt.reset,
+				"RateLimit-Policy":    "3;w=2",
+				"Retry-After":         tt.retryAfter,
+			} {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLimitAllowsWhenStoreFails(t *testing.T) {
+	limiter := NewRateLimiter(&stubStore{err: errors.New("down")}, &ClientIPResolver{})
+	handler := limiter.Limit("route:test", RatePolicy{Default: ratelimit.Quota{RequestsPerSecond: 1}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("RateLimit-Limit"); got != "" {
+		t.Fatalf("RateLimit-Limit = %q, want none", got)
+	}
+}
+
+func TestRatePolicyQuotaFor(t *testing.T) {
+	policy := RatePolicy{
+		Default: ratelimit.Quota{RequestsPerSecond: 10, Burst: 20},
+		Tiers: map[string]ratelimit.Quota{
+			AnonymousTier: {RequestsPerSecond: 1},
+			APIKeyRole:    {RequestsPerSecond: 5, Burst: 5},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		identity *Identity
+		want     ratelimit.Quota
+	}{
+		{"anonymous tier, burst defaults to twice the rate", nil, ratelimit.Quota{RequestsPerSecond: 1, Burst: 2}},
+		{"role without a tier", &Identity{UserID: "1", Role: "customer"}, ratelimit.Quota{RequestsPerSecond: 10, Burst: 20}},
+		{"API key tier", &Identity{UserID: "1", Role: APIKeyRole, KeyID: "k"}, ratelimit.Quota{RequestsPerSecond: 5, Burst: 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.quotaFor(tt.identity); got != tt.want {
+				t.Fatalf("quotaFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/0Bleak/api-gateway/internal/requestid"
+)
+
+// RequestID gives every request an ID, reusing a well-formed X-Request-ID sent
+// by the client. The ID is set on the request, so upstream calls forward it,
+// stored in the context and returned on the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		r.Header.Set(requestid.Header, id)
+		w.Header().Set(requestid.Header, id)
+
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
@@ -0,0 +1,71 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/0Bleak/api-gateway/internal/requestid"
+)
+
+// ContentType is the media type of problem documents (RFC 7807).
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem document, in the format the services use.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// kinds names the problem type of the statuses the gateway answers with
+// itself; the services use the same names.
+var kinds = map[int]string{
+	http.StatusBadRequest:         "validation",
+	http.StatusUnauthorized:       "unauthorized",
+	http.StatusForbidden:          "forbidden",
+	http.StatusNotFound:           "not-found",
+	http.StatusMethodNotAllowed:   "method-not-allowed",
+	http.StatusConflict:           "conflict",
+	http.StatusPreconditionFailed: "precondition-failed",
+	http.StatusTooManyRequests:    "rate-limited",
+	http.StatusBadGateway:         "upstream-unavailable",
+	http.StatusServiceUnavailable: "upstream-unavailable",
+	http.StatusGatewayTimeout:     "upstream-unavailable",
+}
+
+// TypeURI names the problem type of a status, or about:blank.
+func TypeURI(status int) string {
+	if kind, ok := kinds[status]; ok {
+		return "urn:clayjar:problem:" + kind
+	}
+	return "about:blank"
+}
+
+// Write answers the request with a problem document.
+func Write(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	body, _ := json.Marshal(Problem{
+		Type:      TypeURI(status),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: requestid.FromContext(r.Context()),
+	})
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// NotFound answers requests that match no route.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, http.StatusNotFound, "No route for "+r.URL.Path)
+}
+
+// MethodNotAllowed answers requests whose path is routed for other methods.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
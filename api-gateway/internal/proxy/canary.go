@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"math/rand"
+	"net/http"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// versionMetaKey is the Consul service meta key services register their build version under.
+const versionMetaKey = "version"
+
+// CanaryConfig sends Weight percent of a service's traffic to the instances
+// registered with Version. Requests carrying Header or Cookie choose their side
+// explicitly: the canary version selects the canary, any other value the
+// stable instances.
+type CanaryConfig struct {
+	Version string
+	Weight  float64
+	Header  string
+	Cookie  string
+}
+
+// wantsCanary decides which side of the split the request falls on. Users are
+// bucketed by a hash of their id so they stay on one side across requests;
+// anonymous requests are split at random.
+func (c *CanaryConfig) wantsCanary(serviceName string, r *http.Request) bool {
+	if c.Header != "" {
+		if value := r.Header.Get(c.Header); value != "" {
+			return value == c.Version
+		}
+	}
+	if c.Cookie != "" {
+		if cookie, err := r.Cookie(c.Cookie); err == nil && cookie.Value != "" {
+			return cookie.Value == c.Version
+		}
+	}
+
+	switch {
+	case c.Weight <= 0:
+		return false
+	case c.Weight >= 100:
+		return true
+	}
+
+	if userID := r.Header.Get(userIDHeader); userID != "" {
+		bucket := hashString(serviceName+"|"+userID) % 10000
+		return float64(bucket) < c.Weight*100
+	}
+	return rand.Float64()*100 < c.Weight
+}
+
+// filter keeps the canary or the stable instances depending on the request.
+// When the chosen side has no instances every instance is returned, so an
+// ejected or missing canary falls back to the stable version.
+func (c *CanaryConfig) filter(serviceName string, r *http.Request, services []*api.ServiceEntry) []*api.ServiceEntry {
+	canary := c.wantsCanary(serviceName, r)
+
+	selected := make([]*api.ServiceEntry, 0, len(services))
+	for _, entry := range services {
+		if (entry.Service.Meta[versionMetaKey] == c.Version) == canary {
+			selected = append(selected, entry)
+		}
+	}
+
+	if len(selected) == 0 {
+		return services
+	}
+	return selected
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func versioned(versions map[string]string) []*api.ServiceEntry {
+	services := entries("stable-1", "stable-2", "canary-1")
+	for _, entry := range services {
+		entry.Service.Meta = map[string]string{versionMetaKey: versions[entry.Service.ID]}
+	}
+	return services
+}
+
+func TestCanaryBucketsUsers(t *testing.T) {
+	canary := &CanaryConfig{Version: "v2", Weight: 10}
+
+	users := 0
+	for user := 0; user < 2000; user++ {
+		r := httptest.NewRequest(http.MethodGet, "/api/jars", nil)
+		r.Header.Set(userIDHeader, fmt.Sprint(user))
+
+		first := canary.wantsCanary("jar-service", r)
+		for i := 0; i < 5; i++ {
+			if canary.wantsCanary("jar-service", r) != first {
+				t.Fatalf("user %d switched sides", user)
+			}
+		}
+		if first {
+			users++
+		}
+	}
+
+	// About 10% of users, 200 of 2000, are on the canary.
+	if users < 140 || users > 260 {
+		t.Fatalf("%d of 2000 users on the canary, want about 200", users)
+	}
+}
+
+// A user is bucketed per service, so the same users are not the canary
+// audience of every service.
+func TestCanaryBucketsPerService(t *testing.T) {
+	canary := &CanaryConfig{Version: "v2", Weight: 50}
+
+	differ := 0
+	for user := 0; user < 200; user++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(userIDHeader, fmt.Sprint(user))
+		if canary.wantsCanary("jar-service", r) != canary.wantsCanary("order-service", r) {
+			differ++
+		}
+	}
+	if differ == 0 {
+		t.Fatal("every user is on the same side of both services")
+	}
+}
+
+func TestCanaryWantsCanary(t *testing.T) {
+	tests := []struct {
+		name   string
+		config CanaryConfig
+		header string
+		cookie string
+		want   bool
+	}{
+		{"weight 0", CanaryConfig{Version: "v2"}, "", "", false},
+		{"weight 100", CanaryConfig{Version: "v2", Weight: 100}, "", "", true},
+		{"header selects the canary", CanaryConfig{Version: "v2", Header: "X-Canary"}, "v2", "", true},
+		{"header selects stable", CanaryConfig{Version: "v2", Weight: 100, Header: "X-Canary"}, "v1", "", false},
+		{"cookie selects the canary", CanaryConfig{Version: "v2", Cookie: "canary"}, "", "v2", true},
+		{"cookie selects stable", CanaryConfig{Version: "v2", Weight: 100, Cookie: "canary"}, "", "stable", false},
+		{"header wins over the cookie", CanaryConfig{Version: "v2", Header: "X-Canary", Cookie: "canary"}, "v2", "stable", true},
+		{"unconfigured header is ignored", CanaryConfig{Version: "v2"}, "v2", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Canary", tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "canary", Value: tt.cookie})
+			}
+			if got := tt.config.wantsCanary("jar-service", r); got != tt.want {
+				t.Fatalf("wantsCanary() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanaryFilter(t *testing.T) {
+	versions := map[string]string{"stable-1": "v1", "stable-2": "v1", "canary-1": "v2"}
+
+	tests := []struct {
+		name     string
+		config   CanaryConfig
+		versions map[string]string
+		want     []string
+	}{
+		{"stable side", CanaryConfig{Version: "v2"}, versions, []string{"stable-1", "stable-2"}},
+		{"canary side", CanaryConfig{Version: "v2", Weight: 100}, versions, []string{"canary-1"}},
+		{"no canary registered", CanaryConfig{Version: "v3", Weight: 100}, versions, []string{"stable-1", "stable-2", "canary-1"}},
+		{"only the canary registered", CanaryConfig{Version: "v2"}, map[string]string{"stable-1": "v2", "stable-2": "v2", "canary-1": "v2"}, []string{"stable-1", "stable-2", "canary-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := entryIDs(tt.config.filter("jar-service", httptest.NewRequest(http.MethodGet, "/", nil), versioned(tt.versions)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
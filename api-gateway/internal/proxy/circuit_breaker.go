@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerSettings struct {
+	FailureThreshold int           // consecutive failures that open the breaker
+	OpenTimeout      time.Duration // how long the breaker stays open before probing
+	HalfOpenRequests int           // successful probes required to close again
+}
+
+// CircuitBreaker guards a single upstream service. It opens after a run of
+// consecutive failures, rejects calls while open, and lets a limited number
+// of probe requests through once the open timeout has elapsed.
+type CircuitBreaker struct {
+	name     string
+	settings BreakerSettings
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	probes    int
+	openedAt  time.Time
+	changedAt time.Time
+
+	now func() time.Time
+}
+
+func NewCircuitBreaker(name string, settings BreakerSettings) *CircuitBreaker {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = 5
+	}
+	if settings.OpenTimeout <= 0 {
+		settings.OpenTimeout = 30 * time.Second
+	}
+	if settings.HalfOpenRequests <= 0 {
+		settings.HalfOpenRequests = 1
+	}
+
+	return &CircuitBreaker{
+		name:      name,
+		settings:  settings,
+		changedAt: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by Record or Release.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen {
+		if b.now().Sub(b.openedAt) < b.settings.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.setState(StateHalfOpen)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probes >= b.settings.HalfOpenRequests {
+			return ErrCircuitOpen
+		}
+		b.probes++
+	}
+
+	return nil
+}
+
+// Record reports the outcome of an allowed call.
+func (b *CircuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.settings.FailureThreshold {
+			b.setState(StateOpen)
+		}
+
+	case StateHalfOpen:
+		b.probes--
+		if !success {
+			b.setState(StateOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.settings.HalfOpenRequests {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+// Release frees an allowed call without recording an outcome, e.g. when the
+// client went away before the upstream answered.
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// RetryAfter returns how long until the breaker will accept probe requests.
+func (b *CircuitBreaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != StateOpen {
+		return 0
+	}
+	return b.settings.OpenTimeout - b.now().Sub(b.openedAt)
+}
+
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.failures = 0
+	b.successes = 0
+	b.probes = 0
+	b.changedAt = b.now()
+	if state == StateOpen {
+		b.openedAt = b.changedAt
+	}
+}
+
+type BreakerSnapshot struct {
+	Service             string    `json:"service"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	StateChangedAt      time.Time `json:"state_changed_at"`
+}
+
+func (b *CircuitBreaker) Snapshot() BreakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == StateOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		state = StateHalfOpen
+	}
+
+	return BreakerSnapshot{
+		Service:             b.name,
+		State:               state.String(),
+		ConsecutiveFailures: b.failures,
+		StateChangedAt:      b.changedAt,
+	}
+}
+
+// BreakerRegistry lazily creates one circuit breaker per upstream service.
+type BreakerRegistry struct {
+	settings BreakerSettings
+
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker
+}
+
+func NewBreakerRegistry(settings BreakerSettings) *BreakerRegistry {
+	return &BreakerRegistry{
+		settings: settings,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+func (r *BreakerRegistry) Get(serviceName string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	breaker, ok := r.breakers[serviceName]
+	if !ok {
+		breaker = NewCircuitBreaker(serviceName, r.settings)
+		r.breakers[serviceName] = breaker
+	}
+	return breaker
+}
+
+func (r *BreakerRegistry) Snapshots() []BreakerSnapshot {
+	r.mu.Lock()
+	breakers := make([]*CircuitBreaker, 0, len(r.breakers))
+	for _, breaker := range r.breakers {
+		breakers = append(breakers, breaker)
+	}
+	r.mu.Unlock()
+
+	snapshots := make([]BreakerSnapshot, 0, len(breakers))
+	for _, breaker := range breakers {
+		snapshots = append(snapshots, breaker.Snapshot())
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Service < snapshots[j].Service
+	})
+	return snapshots
+}
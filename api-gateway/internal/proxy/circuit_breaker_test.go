@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// step is one action on a breaker: a call that succeeds or fails, a call that
+// is released without an outcome, or the passing of time.
+type step struct {
+	call    string // "ok", "fail", "release" or "" to only advance the clock
+	advance time.Duration
+	allowed bool
+	state   BreakerState
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	settings := BreakerSettings{FailureThreshold: 3, OpenTimeout: 10 * time.Second, HalfOpenRequests: 2}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "stays closed below the threshold",
+			steps: []step{
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "ok", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateClosed},
+			},
+		},
+		{
+			name: "opens after consecutive failures and rejects calls",
+			steps: []step{
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateOpen},
+				{call: "ok", advance: 9 * time.Second, allowed: false, state: StateOpen},
+			},
+		},
+		{
+			name: "half-opens after the timeout and closes after enough probes",
+			steps: []step{
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateOpen},
+				{call: "ok", advance: 10 * time.Second, allowed: true, state: StateHalfOpen},
+				{call: "ok", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateClosed},
+			},
+		},
+		{
+			name: "reopens when a probe fails",
+			steps: []step{
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateOpen},
+				{call: "fail", advance: 10 * time.Second, allowed: true, state: StateOpen},
+				{call: "ok", advance: 5 * time.Second, allowed: false, state: StateOpen},
+			},
+		},
+		{
+			name: "released probes free their slot",
+			steps: []step{
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateClosed},
+				{call: "fail", allowed: true, state: StateOpen},
+				{call: "release", advance: 10 * time.Second, allowed: true, state: StateHalfOpen},
+				{call: "release", allowed: true, state: StateHalfOpen},
+				{call: "ok", allowed: true, state: StateHalfOpen},
+				{call: "ok", allowed: true, state: StateClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			b := NewCircuitBreaker("test", settings)
+			b.now = func() time.Time { return now }
+
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				err := b.Allow()
+				if allowed := err == nil; allowed != s.allowed {
+					t.Fatalf("step %d: allowed = %v, want %v", i, allowed, s.allowed)
+				}
+				if err != nil && !errors.Is(err, ErrCircuitOpen) {
+					t.Fatalf("step %d: Allow() = %v, want ErrCircuitOpen", i, err)
+				}
+				if err == nil {
+					switch s.call {
+					case "ok":
+						b.Record(true)
+					case "fail":
+						b.Record(false)
+					case "release":
+						b.Release()
+					}
+				}
+				if b.state != s.state {
+					t.Fatalf("step %d: state = %v, want %v", i, b.state, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerLimitsConcurrentProbes(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewCircuitBreaker("test", BreakerSettings{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenRequests: 2})
+	b.now = func() time.Time { return now }
+
+	b.Allow()
+	b.Record(false)
+	now = now.Add(time.Second)
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("probe %d: Allow() = %v, want nil", i, err)
+		}
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("third probe: Allow() = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestCircuitBreakerRetryAfter(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewCircuitBreaker("test", BreakerSettings{FailureThreshold: 1, OpenTimeout: 30 * time.Second})
+	b.now = func() time.Time { return now }
+
+	if got := b.RetryAfter(); got != 0 {
+		t.Fatalf("closed: RetryAfter() = %v, want 0", got)
+	}
+
+	b.Allow()
+	b.Record(false)
+	now = now.Add(12 * time.Second)
+	if got := b.RetryAfter(); got != 18*time.Second {
+		t.Fatalf("open: RetryAfter() = %v, want 18s", got)
+	}
+	if got := b.Snapshot().State; got != "open" {
+		t.Fatalf("open: snapshot state = %q, want open", got)
+	}
+
+	now = now.Add(18 * time.Second)
+	if got := b.Snapshot().State; got != "half-open" {
+		t.Fatalf("after timeout: snapshot state = %q, want half-open", got)
+	}
+}
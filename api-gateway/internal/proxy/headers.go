@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// hopHeaders apply to a single connection and are never forwarded (RFC 9110 section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopHeaders deletes the hop-by-hop headers, including those named in
+// the Connection header.
+func RemoveHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// IsUpgrade reports whether the request asks to switch protocols, e.g. to a WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && headerContainsToken(r.Header, "Connection", "upgrade")
+}
+
+// SetForwardedHeaders describes the client connection of in to the upstream
+// through X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host and Forwarded.
+// Values set by earlier proxies are kept and extended.
+func SetForwardedHeaders(out http.Header, in *http.Request) {
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+
+	clientIP, _, err := net.SplitHostPort(in.RemoteAddr)
+	if err != nil {
+		clientIP = in.RemoteAddr
+	}
+
+	if prior := in.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		out.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIP)
+	} else {
+		out.Set("X-Forwarded-For", clientIP)
+	}
+	out.Set("X-Forwarded-Proto", proto)
+	out.Set("X-Forwarded-Host", in.Host)
+
+	node := clientIP
+	if strings.Contains(node, ":") {
+		node = `"[` + node + `]"`
+	}
+	element := "for=" + node + ";host=" + quoteForwarded(in.Host) + ";proto=" + proto
+	if prior := in.Header.Values("Forwarded"); len(prior) > 0 {
+		element = strings.Join(prior, ", ") + ", " + element
+	}
+	out.Set("Forwarded", element)
+}
+
+// quoteForwarded quotes a Forwarded parameter value unless it is a plain token.
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !(c == '.' || c == '-' || c == '_' || c == '~' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(textproto.TrimString(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRemoveHopHeaders(t *testing.T) {
+	header := http.Header{
+		"Connection":          {"keep-alive, X-Session-Hint", " x-trace-hop "},
+		"Keep-Alive":          {"timeout=5"},
+		"Proxy-Connection":    {"keep-alive"},
+		"Proxy-Authenticate":  {"Basic"},
+		"Proxy-Authorization": {"Basic YWRtaW46YWRtaW4="},
+		"Te":                  {"trailers"},
+		"Trailer":             {"X-Checksum"},
+		"Transfer-Encoding":   {"chunked"},
+		"Upgrade":             {"websocket"},
+		"X-Session-Hint":      {"1"},
+		"X-Trace-Hop":         {"2"},
+		"Authorization":       {"Bearer token"},
+		"Content-Type":        {"application/json"},
+	}
+
+	RemoveHopHeaders(header)
+
+	want := http.Header{
+		"Authorization": {"Bearer token"},
+		"Content-Type":  {"application/json"},
+	}
+	if !reflect.DeepEqual(header, want) {
+		t.Fatalf("headers left = %v, want %v", header, want)
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{"websocket", "Upgrade", "websocket", true},
+		{"token in a list", "keep-alive, upgrade", "websocket", true},
+		{"no Upgrade header", "Upgrade", "", false},
+		{"Upgrade without Connection", "", "websocket", false},
+		{"Connection without the token", "keep-alive", "websocket", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if tt.connection != "" {
+			r.Header.Set("Connection", tt.connection)
+		}
+		if tt.upgrade != "" {
+			r.Header.Set("Upgrade", tt.upgrade)
+		}
+		if got := IsUpgrade(r); got != tt.want {
+			t.Errorf("%s: IsUpgrade() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		host       string
+		tls        bool
+		prior      http.Header
+		want       http.Header
+	}{
+		{
+			name:       "first proxy",
+			remoteAddr: "203.0.113.7:52100",
+			host:       "shop.example.com",
+			want: http.Header{
+				"X-Forwarded-For":   {"203.0.113.7"},
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"shop.example.com"},
+				"Forwarded":         {"for=203.0.113.7;host=shop.example.com;proto=http"},
+			},
+		},
+		{
+			name:       "behind another proxy",
+			remoteAddr: "10.0.0.2:40000",
+			host:       "shop.example.com",
+			tls:        true,
+			prior: http.Header{
+				"X-Forwarded-For": {"203.0.113.7"},
+				"Forwarded":       {"for=203.0.113.7"},
+			},
+			want: http.Header{
+				"X-Forwarded-For":   {"203.0.113.7, 10.0.0.2"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"shop.example.com"},
+				"Forwarded":         {"for=203.0.113.7, for=10.0.0.2;host=shop.example.com;proto=https"},
+			},
+		},
+		{
+			name:       "IPv6 client and a host with a port",
+			remoteAddr: "[2001:db8::1]:52100",
+			host:       "localhost:8000",
+			want: http.Header{
+				"X-Forwarded-For":   {"2001:db8::1"},
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"localhost:8000"},
+				"Forwarded":         {`for="[2001:db8::1]";host="localhost:8000";proto=http`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := httptest.NewRequest(http.MethodGet, "/api/jars", nil)
+			in.RemoteAddr = tt.remoteAddr
+			in.Host = tt.host
+			if tt.tls {
+				in.TLS = &tls.ConnectionState{}
+			}
+			for key, values := range tt.prior {
+				in.Header[key] = values
+			}
+
+			out := make(http.Header)
+			SetForwardedHeaders(out, in)
+			if !reflect.DeepEqual(out, tt.want) {
+				t.Fatalf("forwarded headers = %v, want %v", out, tt.want)
+			}
+		})
+	}
+}
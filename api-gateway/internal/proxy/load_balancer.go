@@ -1,7 +1,10 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -9,89 +12,383 @@ import (
 	"github.com/hashicorp/consul/api"
 )
 
+// initialWatchTimeout bounds how long the first request for a service waits
+// for Consul to answer.
+const initialWatchTimeout = 5 * time.Second
+
+// serviceWatch holds the last instance set Consul reported for a service.
+// ready is closed once Consul first answered or failed; until a set arrives,
+// err holds the last failure so that requests fail without waiting.
+type serviceWatch struct {
+	entries   []*api.ServiceEntry
+	updatedAt time.Time
+	err       error
+	ready     chan struct{}
+	readyOnce sync.Once
+	cancel    context.CancelFunc
+}
+
+func (w *serviceWatch) markReady() {
+	w.readyOnce.Do(func() { close(w.ready) })
+}
+
 type LoadBalancer struct {
-	consul      *discovery.ConsulClient
-	mu          sync.Mutex
-	counter     map[string]uint64
-	cache       map[string][]*api.ServiceEntry
-	cacheExpiry map[string]time.Time
-	cacheTTL    time.Duration
+	consul     *discovery.ConsulClient
+	ctx        context.Context
+	cancel     context.CancelFunc
+	mu         sync.Mutex
+	configs    map[string]ServiceConfig
+	strategies map[string]Strategy
+	inflight   map[string]map[string]int
+	drained    map[string]map[string]bool
+	watches    map[string]*serviceWatch
+	outliers   *outlierDetector
 }
 
-func NewLoadBalancer(consul *discovery.ConsulClient) *LoadBalancer {
-	lb := &LoadBalancer{
-		consul:      consul,
-		counter:     make(map[string]uint64),
-		cache:       make(map[string][]*api.ServiceEntry),
-		cacheExpiry: make(map[string]time.Time),
-		cacheTTL:    5 * time.Second,
+func NewLoadBalancer(consul *discovery.ConsulClient, outlierSettings OutlierSettings) *LoadBalancer {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &LoadBalancer{
+		consul:     consul,
+		ctx:        ctx,
+		cancel:     cancel,
+		configs:    make(map[string]ServiceConfig),
+		strategies: make(map[string]Strategy),
+		inflight:   make(map[string]map[string]int),
+		drained:    make(map[string]map[string]bool),
+		watches:    make(map[string]*serviceWatch),
+		outliers:   newOutlierDetector(outlierSettings),
 	}
+}
+
+// Close stops every Consul watch.
+func (lb *LoadBalancer) Close() {
+	lb.cancel()
+}
+
+// Instance is a single upstream endpoint selected by the load balancer.
+type Instance struct {
+	ID      string
+	Address string
+	Port    int
+}
+
+func (i *Instance) URL() string {
+	return fmt.Sprintf("http://%s:%d", i.Address, i.Port)
+}
 
-	go lb.backgroundRefresh()
-	return lb
+// ServiceConfig holds the load-balancing settings of one upstream service.
+type ServiceConfig struct {
+	Strategy StrategyConfig
+	Canary   *CanaryConfig
 }
 
-func (lb *LoadBalancer) GetServiceURL(serviceName string) (string, error) {
+// Configure replaces the per-service configuration. Services without an entry
+// use round robin across every version. Services whose strategy is unchanged
+// keep their state.
+func (lb *LoadBalancer) Configure(configs map[string]ServiceConfig) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for name := range lb.strategies {
+		if configs[name].Strategy != lb.configs[name].Strategy {
+			delete(lb.strategies, name)
+		}
+	}
+	lb.configs = configs
+}
+
+// NextInstance picks an instance of the service for the request, skipping the
+// excluded instance IDs unless no other instance is available. Every returned
+// instance must be handed back through ReportResult or Release.
+func (lb *LoadBalancer) NextInstance(serviceName string, r *http.Request, exclude map[string]bool) (*Instance, error) {
 	services, err := lb.getCachedInstances(serviceName)
 	if err != nil {
-		return "", fmt.Errorf("failed to get service instances: %w", err)
+		return nil, fmt.Errorf("failed to get service instances: %w", err)
 	}
 
 	if len(services) == 0 {
-		return "", fmt.Errorf("no healthy instances found for service: %s", serviceName)
+		return nil, fmt.Errorf("no healthy instances found for service: %s", serviceName)
+	}
+
+	lb.mu.Lock()
+	services = lb.withoutDrained(serviceName, services)
+	canary := lb.configs[serviceName].Canary
+	lb.mu.Unlock()
+
+	services = lb.outliers.filter(serviceName, services)
+	if canary != nil {
+		services = canary.filter(serviceName, r, services)
+	}
+
+	if len(exclude) > 0 {
+		remaining := make([]*api.ServiceEntry, 0, len(services))
+		for _, entry := range services {
+			if !exclude[entry.Service.ID] {
+				remaining = append(remaining, entry)
+			}
+		}
+		if len(remaining) > 0 {
+			services = remaining
+		}
 	}
 
-	instance := lb.selectInstance(serviceName, services)
-	return fmt.Sprintf("http://%s:%d", instance.Service.Address, instance.Service.Port), nil
+	lb.mu.Lock()
+	entry := lb.selectInstance(serviceName, r, services)
+	lb.mu.Unlock()
+
+	return &Instance{
+		ID:      entry.Service.ID,
+		Address: entry.Service.Address,
+		Port:    entry.Service.Port,
+	}, nil
 }
 
-func (lb *LoadBalancer) getCachedInstances(serviceName string) ([]*api.ServiceEntry, error) {
+// ReportResult feeds the outcome of a proxied request into outlier detection
+// and releases the instance.
+func (lb *LoadBalancer) ReportResult(serviceName, instanceID string, success bool, latency time.Duration) {
+	lb.Release(serviceName, instanceID)
+	lb.outliers.record(serviceName, instanceID, success, latency)
+}
+
+// Release hands back an instance without reporting an outcome.
+func (lb *LoadBalancer) Release(serviceName, instanceID string) {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
-	if expiry, ok := lb.cacheExpiry[serviceName]; ok && time.Now().Before(expiry) {
-		return lb.cache[serviceName], nil
+	if inflight := lb.inflight[serviceName]; inflight[instanceID] > 0 {
+		inflight[instanceID]--
+		if inflight[instanceID] == 0 {
+			delete(inflight, instanceID)
+		}
+	}
+}
+
+// Drain stops sending new requests to the instance, as long as the service has
+// other instances to use. Requests in flight are not affected.
+func (lb *LoadBalancer) Drain(serviceName, instanceID string) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	drained, ok := lb.drained[serviceName]
+	if !ok {
+		drained = make(map[string]bool)
+		lb.drained[serviceName] = drained
+	}
+	drained[instanceID] = true
+}
+
+// Undrain puts a drained instance back into rotation.
+func (lb *LoadBalancer) Undrain(serviceName, instanceID string) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	delete(lb.drained[serviceName], instanceID)
+	if len(lb.drained[serviceName]) == 0 {
+		delete(lb.drained, serviceName)
+	}
+}
+
+// withoutDrained drops drained instances unless none would be left. lb.mu
+// must be held.
+func (lb *LoadBalancer) withoutDrained(serviceName string, services []*api.ServiceEntry) []*api.ServiceEntry {
+	drained := lb.drained[serviceName]
+	if len(drained) == 0 {
+		return services
 	}
 
+	available := make([]*api.ServiceEntry, 0, len(services))
+	for _, entry := range services {
+		if !drained[entry.Service.ID] {
+			available = append(available, entry)
+		}
+	}
+	if len(available) == 0 {
+		return services
+	}
+	return available
+}
+
+// Refresh asks Consul for the instances of the service right away instead of
+// waiting for the watch to report a change.
+func (lb *LoadBalancer) Refresh(serviceName string) error {
 	services, err := lb.consul.GetServiceInstances(serviceName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	lb.cache[serviceName] = services
-	lb.cacheExpiry[serviceName] = time.Now().Add(lb.cacheTTL)
+	lb.mu.Lock()
+	watch := lb.watch(serviceName)
+	lb.mu.Unlock()
 
-	return services, nil
+	lb.updateInstances(serviceName, watch, services)
+	return nil
 }
 
-func (lb *LoadBalancer) selectInstance(serviceName string, services []*api.ServiceEntry) *api.ServiceEntry {
-	count := lb.counter[serviceName]
-	index := count % uint64(len(services))
-	lb.counter[serviceName] = count + 1
+// ServiceSnapshot describes the instances the load balancer currently knows
+// for one upstream service, and the state of its strategy.
+type ServiceSnapshot struct {
+	Service       string             `json:"service"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+	Strategy      string             `json:"strategy"`
+	StrategyState any                `json:"strategy_state,omitempty"`
+	Instances     []InstanceSnapshot `json:"instances"`
+}
 
-	return services[index]
+type InstanceSnapshot struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	Version  string `json:"version,omitempty"`
+	Inflight int    `json:"inflight"`
+	Ejected  bool   `json:"ejected"`
+	Draining bool   `json:"draining"`
 }
 
-func (lb *LoadBalancer) backgroundRefresh() {
-	ticker := time.NewTicker(lb.cacheTTL / 2)
-	defer ticker.Stop()
+// Snapshots returns the cached instances of every watched service, sorted by
+// service name. Services still waiting for their first Consul answer are omitted.
+func (lb *LoadBalancer) Snapshots() []ServiceSnapshot {
+	lb.mu.Lock()
+	snapshots := make([]ServiceSnapshot, 0, len(lb.watches))
+	for name, watch := range lb.watches {
+		if watch.updatedAt.IsZero() {
+			continue
+		}
+		snapshot := ServiceSnapshot{
+			Service:   name,
+			UpdatedAt: watch.updatedAt,
+			Strategy:  lb.configs[name].Strategy.Name,
+			Instances: make([]InstanceSnapshot, 0, len(watch.entries)),
+		}
+		if snapshot.Strategy == "" {
+			snapshot.Strategy = StrategyRoundRobin
+		}
+		if reporter, ok := lb.strategies[name].(StateReporter); ok {
+			snapshot.StrategyState = reporter.State()
+		}
+		for _, entry := range watch.entries {
+			snapshot.Instances = append(snapshot.Instances, InstanceSnapshot{
+				ID:       entry.Service.ID,
+				Address:  entry.Service.Address,
+				Port:     entry.Service.Port,
+				Version:  entry.Service.Meta[versionMetaKey],
+				Inflight: lb.inflight[name][entry.Service.ID],
+				Draining: lb.drained[name][entry.Service.ID],
+			})
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	lb.mu.Unlock()
 
-	for range ticker.C {
-		lb.mu.Lock()
-		serviceNames := make([]string, 0, len(lb.cache))
-		for name := range lb.cache {
-			serviceNames = append(serviceNames, name)
+	for _, snapshot := range snapshots {
+		for i := range snapshot.Instances {
+			snapshot.Instances[i].Ejected = lb.outliers.isEjected(snapshot.Service, snapshot.Instances[i].ID)
 		}
-		lb.mu.Unlock()
-
-		for _, name := range serviceNames {
-			services, err := lb.consul.GetServiceInstances(name)
-			if err == nil {
-				lb.mu.Lock()
-				lb.cache[name] = services
-				lb.cacheExpiry[name] = time.Now().Add(lb.cacheTTL)
-				lb.mu.Unlock()
-			}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Service < snapshots[j].Service
+	})
+	return snapshots
+}
+
+// getCachedInstances returns the last instance set reported by Consul. The
+// first call for a service starts a blocking-query watch and waits for its
+// initial result. If Consul failed before ever listing the service, the
+// failure is returned at once; the lock is never held while talking to Consul.
+func (lb *LoadBalancer) getCachedInstances(serviceName string) ([]*api.ServiceEntry, error) {
+	lb.mu.Lock()
+	watch := lb.watch(serviceName)
+	lb.mu.Unlock()
+
+	select {
+	case <-watch.ready:
+	case <-time.After(initialWatchTimeout):
+		return nil, fmt.Errorf("timed out waiting for consul to list %s", serviceName)
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if watch.updatedAt.IsZero() {
+		return nil, fmt.Errorf("consul has not listed %s yet: %w", serviceName, watch.err)
+	}
+	return watch.entries, nil
+}
+
+// WatchOnly follows the instances of the named services ahead of their first
+// request, and stops the watches of every other service. Services watched
+// already keep their watch.
+func (lb *LoadBalancer) WatchOnly(serviceNames []string) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	keep := make(map[string]bool, len(serviceNames))
+	for _, name := range serviceNames {
+		keep[name] = true
+		lb.watch(name)
+	}
+	for name, watch := range lb.watches {
+		if !keep[name] {
+			watch.cancel()
+			delete(lb.watches, name)
+			delete(lb.strategies, name)
 		}
 	}
 }
+
+// watch returns the watch of a service, starting it if needed. lb.mu must be held.
+func (lb *LoadBalancer) watch(serviceName string) *serviceWatch {
+	watch, ok := lb.watches[serviceName]
+	if !ok {
+		ctx, cancel := context.WithCancel(lb.ctx)
+		watch = &serviceWatch{ready: make(chan struct{}), cancel: cancel}
+		lb.watches[serviceName] = watch
+		go lb.consul.WatchService(ctx, serviceName, func(services []*api.ServiceEntry) {
+			lb.updateInstances(serviceName, watch, services)
+		}, func(err error) {
+			lb.mu.Lock()
+			if watch.updatedAt.IsZero() {
+				watch.err = err
+			}
+			lb.mu.Unlock()
+			watch.markReady()
+		})
+	}
+	return watch
+}
+
+func (lb *LoadBalancer) updateInstances(serviceName string, watch *serviceWatch, services []*api.ServiceEntry) {
+	lb.mu.Lock()
+	watch.entries = services
+	watch.updatedAt = time.Now()
+	watch.err = nil
+	current := lb.watches[serviceName] == watch
+	lb.mu.Unlock()
+
+	watch.markReady()
+	if current {
+		lb.outliers.prune(serviceName, services)
+	}
+}
+
+func (lb *LoadBalancer) selectInstance(serviceName string, r *http.Request, services []*api.ServiceEntry) *api.ServiceEntry {
+	strategy, ok := lb.strategies[serviceName]
+	if !ok {
+		strategy = NewStrategy(lb.configs[serviceName].Strategy)
+		lb.strategies[serviceName] = strategy
+	}
+
+	inflight, ok := lb.inflight[serviceName]
+	if !ok {
+		inflight = make(map[string]int)
+		lb.inflight[serviceName] = inflight
+	}
+
+	candidates := make([]Candidate, len(services))
+	for i, entry := range services {
+		candidates[i] = Candidate{Entry: entry, Inflight: inflight[entry.Service.ID]}
+	}
+
+	selected := strategy.Select(r, candidates)
+	inflight[selected.Entry.Service.ID]++
+	return selected.Entry
+}
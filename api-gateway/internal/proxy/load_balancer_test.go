@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/discovery/consultest"
+)
+
+func newLoadBalancer(t *testing.T, consul *consultest.Server) *LoadBalancer {
+	t.Helper()
+	lb := NewLoadBalancer(consul.Client(t), OutlierSettings{})
+	t.Cleanup(lb.Close)
+	return lb
+}
+
+// nextID returns the ID of the instance picked for a request, or "" with the
+// error.
+func nextID(lb *LoadBalancer, service string) (string, error) {
+	instance, err := lb.NextInstance(service, httptest.NewRequest(http.MethodGet, "/", nil), nil)
+	if err != nil {
+		return "", err
+	}
+	lb.Release(service, instance.ID)
+	return instance.ID, nil
+}
+
+// eventually retries check until it passes or a few seconds went by.
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadBalancerFollowsConsul(t *testing.T) {
+	consul := consultest.NewServer(t)
+	consul.SetInstances("jar-service", consultest.Instance(t, "jar-1", "http://10.0.0.1:8080", nil))
+	lb := newLoadBalancer(t, consul)
+
+	instance, err := lb.NextInstance("jar-service", httptest.NewRequest(http.MethodGet, "/", nil), nil)
+	if err != nil {
+		t.Fatalf("NextInstance() error = %v", err)
+	}
+	if instance.ID != "jar-1" || instance.URL() != "http://10.0.0.1:8080" {
+		t.Fatalf("NextInstance() = %+v, want jar-1 at http://10.0.0.1:8080", instance)
+	}
+	lb.Release("jar-service", instance.ID)
+
+	// Requests are served from the watch, not by asking Consul each time.
+	for i := 0; i < 10; i++ {
+		if _, err := nextID(lb, "jar-service"); err != nil {
+			t.Fatalf("NextInstance() error = %v", err)
+		}
+	}
+	if queries := consul.Queries("jar-service"); queries > 2 {
+		t.Fatalf("%d queries to Consul for 11 requests, want the initial one and a blocking one", queries)
+	}
+
+	// A registration shows up without another request having to miss.
+	consul.SetInstances("jar-service", consultest.Instance(t, "jar-2", "http://10.0.0.2:8080", nil))
+	eventually(t, "jar-2 to replace jar-1", func() bool {
+		id, err := nextID(lb, "jar-service")
+		return err == nil && id == "jar-2"
+	})
+
+	// A service left without instances fails its requests.
+	consul.SetInstances("jar-service")
+	eventually(t, "jar-2 to be deregistered", func() bool {
+		_, err := nextID(lb, "jar-service")
+		return err != nil && strings.Contains(err.Error(), "no healthy instances")
+	})
+}
+
+func TestLoadBalancerInitialWatchFailure(t *testing.T) {
+	consul := consultest.NewServer(t)
+	consul.SetInstances("jar-service", consultest.Instance(t, "jar-1", "http://10.0.0.1:8080", nil))
+	consul.SetFailing(true)
+	lb := newLoadBalancer(t, consul)
+
+	// The request fails as soon as Consul does, without waiting for the
+	// initial watch timeout.
+	start := time.Now()
+	_, err := nextID(lb, "jar-service")
+	if err == nil || !strings.Contains(err.Error(), "consul has not listed jar-service") {
+		t.Fatalf("NextInstance() error = %v, want the Consul failure", err)
+	}
+	if elapsed := time.Since(start); elapsed >= initialWatchTimeout {
+		t.Fatalf("NextInstance() took %v to fail", elapsed)
+	}
+
+	// The watch retries and recovers once Consul does.
+	consul.SetFailing(false)
+	eventually(t, "the watch to recover", func() bool {
+		id, err := nextID(lb, "jar-service")
+		return err == nil && id == "jar-1"
+	})
+}
+
+// Once listed, a service keeps its last instances while Consul is down.
+func TestLoadBalancerKeepsInstancesWhileConsulFails(t *testing.T) {
+	consul := consultest.NewServer(t)
+	consul.SetInstances("jar-service", consultest.Instance(t, "jar-1", "http://10.0.0.1:8080", nil))
+	lb := newLoadBalancer(t, consul)
+
+	if _, err := nextID(lb, "jar-service"); err != nil {
+		t.Fatalf("NextInstance() error = %v", err)
+	}
+	queries := consul.Queries("jar-service")
+	consul.SetFailing(true)
+	eventually(t, "the watch to see the failure", func() bool { return consul.Queries("jar-service") > queries })
+
+	if id, err := nextID(lb, "jar-service"); err != nil || id != "jar-1" {
+		t.Fatalf("NextInstance() = %q, %v; want the cached jar-1", id, err)
+	}
+}
+
+func TestLoadBalancerWatchOnly(t *testing.T) {
+	consul := consultest.NewServer(t)
+	consul.SetInstances("jar-service", consultest.Instance(t, "jar-1", "http://10.0.0.1:8080", nil))
+	consul.SetInstances("order-service", consultest.Instance(t, "order-1", "http://10.0.0.3:8080", nil))
+	lb := newLoadBalancer(t, consul)
+
+	lb.WatchOnly([]string{"jar-service", "order-service"})
+	eventually(t, "both services to be listed", func() bool { return len(lb.Snapshots()) == 2 })
+
+	lb.WatchOnly([]string{"order-service"})
+	snapshots := lb.Snapshots()
+	if len(snapshots) != 1 || snapshots[0].Service != "order-service" {
+		t.Fatalf("Snapshots() = %+v, want order-service only", snapshots)
+	}
+
+	// The stopped watch no longer queries Consul.
+	queries := consul.Queries("jar-service")
+	consul.SetInstances("jar-service", consultest.Instance(t, "jar-2", "http://10.0.0.2:8080", nil))
+	time.Sleep(50 * time.Millisecond)
+	if got := consul.Queries("jar-service"); got != queries {
+		t.Fatalf("stopped watch made %d more queries", got-queries)
+	}
+}
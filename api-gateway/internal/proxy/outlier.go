@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+type OutlierSettings struct {
+	ConsecutiveErrors  int           // failures in a row that eject an instance
+	ErrorRate          float64       // failure ratio within an interval that ejects an instance
+	MinRequests        int           // requests needed in an interval before rates are evaluated
+	Interval           time.Duration // length of the error rate and latency window
+	LatencyThreshold   time.Duration // average latency that ejects an instance, 0 disables it
+	EjectionTime       time.Duration // base ejection time, multiplied by the ejection count
+	MaxEjectionPercent int           // upper bound of ejected instances per service
+	SlowStart          time.Duration // ramp-up period after an instance is reintroduced
+}
+
+type instanceStats struct {
+	consecutiveErrors int
+	windowStart       time.Time
+	requests          int
+	errors            int
+	totalLatency      time.Duration
+	ejections         int
+	ejectedUntil      time.Time
+}
+
+// outlierDetector tracks the outcome of proxied requests per instance and
+// temporarily removes instances that misbehave between Consul health checks.
+type outlierDetector struct {
+	settings OutlierSettings
+
+	mu        sync.Mutex
+	instances map[string]map[string]*instanceStats
+}
+
+func newOutlierDetector(settings OutlierSettings) *outlierDetector {
+	if settings.Interval <= 0 {
+		settings.Interval = 10 * time.Second
+	}
+	if settings.EjectionTime <= 0 {
+		settings.EjectionTime = 30 * time.Second
+	}
+	if settings.MaxEjectionPercent <= 0 {
+		settings.MaxEjectionPercent = 50
+	}
+
+	return &outlierDetector{
+		settings:  settings,
+		instances: make(map[string]map[string]*instanceStats),
+	}
+}
+
+func (d *outlierDetector) stats(serviceName, instanceID string) *instanceStats {
+	service, ok := d.instances[serviceName]
+	if !ok {
+		service = make(map[string]*instanceStats)
+		d.instances[serviceName] = service
+	}
+
+	stats, ok := service[instanceID]
+	if !ok {
+		stats = &instanceStats{windowStart: time.Now()}
+		service[instanceID] = stats
+	}
+	return stats
+}
+
+func (d *outlierDetector) record(serviceName, instanceID string, success bool, latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	stats := d.stats(serviceName, instanceID)
+	if now.Before(stats.ejectedUntil) {
+		return
+	}
+
+	if now.Sub(stats.windowStart) > d.settings.Interval {
+		// A clean interval after the slow start lowers the next ejection time.
+		if stats.ejections > 0 && stats.errors == 0 && now.Sub(stats.ejectedUntil) > d.settings.SlowStart {
+			stats.ejections--
+		}
+		stats.windowStart = now
+		stats.requests = 0
+		stats.errors = 0
+		stats.totalLatency = 0
+	}
+
+	stats.requests++
+	stats.totalLatency += latency
+	if success {
+		stats.consecutiveErrors = 0
+	} else {
+		stats.errors++
+		stats.consecutiveErrors++
+	}
+
+	if d.isOutlier(stats) && d.canEject(serviceName, now) {
+		stats.ejections++
+		stats.ejectedUntil = now.Add(d.settings.EjectionTime * time.Duration(min(stats.ejections, 10)))
+		stats.consecutiveErrors = 0
+		stats.windowStart = stats.ejectedUntil
+		stats.requests = 0
+		stats.errors = 0
+		stats.totalLatency = 0
+	}
+}
+
+func (d *outlierDetector) isOutlier(stats *instanceStats) bool {
+	if d.settings.ConsecutiveErrors > 0 && stats.consecutiveErrors >= d.settings.ConsecutiveErrors {
+		return true
+	}
+	if stats.requests < d.settings.MinRequests || stats.requests == 0 {
+		return false
+	}
+	if d.settings.ErrorRate > 0 && float64(stats.errors)/float64(stats.requests) >= d.settings.ErrorRate {
+		return true
+	}
+	if d.settings.LatencyThreshold > 0 && stats.totalLatency/time.Duration(stats.requests) >= d.settings.LatencyThreshold {
+		return true
+	}
+	return false
+}
+
+// canEject keeps MaxEjectionPercent of the known instances of a service in rotation.
+func (d *outlierDetector) canEject(serviceName string, now time.Time) bool {
+	service := d.instances[serviceName]
+
+	ejected := 0
+	for _, stats := range service {
+		if now.Before(stats.ejectedUntil) {
+			ejected++
+		}
+	}
+
+	return (ejected+1)*100 <= len(service)*d.settings.MaxEjectionPercent
+}
+
+// filter drops ejected instances and instances that are still ramping up and
+// lost the slow start draw. It never returns an empty set for a non-empty input.
+func (d *outlierDetector) filter(serviceName string, services []*api.ServiceEntry) []*api.ServiceEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	available := make([]*api.ServiceEntry, 0, len(services))
+	for _, entry := range services {
+		stats := d.stats(serviceName, entry.Service.ID)
+		if now.Before(stats.ejectedUntil) {
+			continue
+		}
+
+		if stats.ejections > 0 && d.settings.SlowStart > 0 {
+			ramp := now.Sub(stats.ejectedUntil)
+			if ramp < d.settings.SlowStart && rand.Float64() > float64(ramp)/float64(d.settings.SlowStart) {
+				continue
+			}
+		}
+
+		available = append(available, entry)
+	}
+
+	if len(available) == 0 {
+		return services
+	}
+	return available
+}
+
+func (d *outlierDetector) isEjected(serviceName, instanceID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats, ok := d.instances[serviceName][instanceID]
+	return ok && time.Now().Before(stats.ejectedUntil)
+}
+
+// prune forgets instances that are no longer registered in Consul.
+func (d *outlierDetector) prune(serviceName string, services []*api.ServiceEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	service, ok := d.instances[serviceName]
+	if !ok {
+		return
+	}
+
+	current := make(map[string]bool, len(services))
+	for _, entry := range services {
+		current[entry.Service.ID] = true
+	}
+	for id := range service {
+		if !current[id] {
+			delete(service, id)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func entries(ids ...string) []*api.ServiceEntry {
+	services := make([]*api.ServiceEntry, len(ids))
+	for i, id := range ids {
+		services[i] = &api.ServiceEntry{Service: &api.AgentService{ID: id}}
+	}
+	return services
+}
+
+func entryIDs(services []*api.ServiceEntry) []string {
+	ids := make([]string, len(services))
+	for i, entry := range services {
+		ids[i] = entry.Service.ID
+	}
+	return ids
+}
+
+func TestOutlierEjection(t *testing.T) {
+	type result struct {
+		success bool
+		latency time.Duration
+	}
+	fail := result{success: false}
+	ok := result{success: true}
+	slow := result{success: true, latency: 300 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		settings OutlierSettings
+		results  []result
+		ejected  bool
+	}{
+		{"consecutive errors", OutlierSettings{ConsecutiveErrors: 3}, []result{fail, fail, fail}, true},
+		{"a success resets the streak", OutlierSettings{ConsecutiveErrors: 3}, []result{fail, fail, ok, fail, fail}, false},
+		{"error rate", OutlierSettings{ErrorRate: 0.5, MinRequests: 4}, []result{ok, fail, ok, fail}, true},
+		{"error rate below the minimum requests", OutlierSettings{ErrorRate: 0.5, MinRequests: 4}, []result{fail, fail, fail}, false},
+		{"error rate under the threshold", OutlierSettings{ErrorRate: 0.5, MinRequests: 4}, []result{ok, ok, ok, fail}, false},
+		{"average latency", OutlierSettings{LatencyThreshold: 200 * time.Millisecond, MinRequests: 2}, []result{slow, slow}, true},
+		{"latency under the threshold", OutlierSettings{LatencyThreshold: 200 * time.Millisecond, MinRequests: 2}, []result{slow, ok}, false},
+		{"latency detection disabled", OutlierSettings{MinRequests: 2}, []result{slow, slow}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A second instance keeps the ejection within the default 50%.
+			d := newOutlierDetector(tt.settings)
+			d.filter("jar-service", entries("jar-1", "jar-2"))
+
+			for _, r := range tt.results {
+				d.record("jar-service", "jar-1", r.success, r.latency)
+			}
+
+			if got := d.isEjected("jar-service", "jar-1"); got != tt.ejected {
+				t.Fatalf("isEjected() = %v, want %v", got, tt.ejected)
+			}
+			want := []string{"jar-1", "jar-2"}
+			if tt.ejected {
+				want = []string{"jar-2"}
+			}
+			if got := entryIDs(d.filter("jar-service", entries("jar-1", "jar-2"))); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("filter() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	tests := []struct {
+		percent   int
+		instances int
+		ejected   int
+	}{
+		{percent: 50, instances: 4, ejected: 2},
+		{percent: 50, instances: 3, ejected: 1},
+		{percent: 50, instances: 1, ejected: 0},
+		{percent: 100, instances: 2, ejected: 2},
+		{percent: 10, instances: 5, ejected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d%% of %d", tt.percent, tt.instances), func(t *testing.T) {
+			d := newOutlierDetector(OutlierSettings{ConsecutiveErrors: 1, MaxEjectionPercent: tt.percent})
+
+			var ids []string
+			for i := 1; i <= tt.instances; i++ {
+				ids = append(ids, fmt.Sprintf("jar-%d", i))
+			}
+			d.filter("jar-service", entries(ids...))
+
+			// Every instance fails; only the allowed share is ejected.
+			ejected := 0
+			for _, id := range ids {
+				d.record("jar-service", id, false, 0)
+				if d.isEjected("jar-service", id) {
+					ejected++
+				}
+			}
+			if ejected != tt.ejected {
+				t.Fatalf("%d instances ejected, want %d", ejected, tt.ejected)
+			}
+			// filter falls back to every instance rather than none.
+			want := tt.instances - tt.ejected
+			if want == 0 {
+				want = tt.instances
+			}
+			if available := d.filter("jar-service", entries(ids...)); len(available) != want {
+				t.Fatalf("filter() kept %d instances, want %d", len(available), want)
+			}
+		})
+	}
+}
+
+func TestOutlierEjectionTimeGrows(t *testing.T) {
+	d := newOutlierDetector(OutlierSettings{ConsecutiveErrors: 1, EjectionTime: time.Minute})
+	d.filter("jar-service", entries("jar-1", "jar-2"))
+
+	for ejection := 1; ejection <= 2; ejection++ {
+		before := time.Now()
+		d.record("jar-service", "jar-1", false, 0)
+
+		stats := d.instances["jar-service"]["jar-1"]
+		if got := stats.ejectedUntil.Sub(before); got < time.Duration(ejection)*time.Minute || got > time.Duration(ejection)*time.Minute+time.Second {
+			t.Fatalf("ejection %d lasts %v, want %v", ejection, got, time.Duration(ejection)*time.Minute)
+		}
+
+		// Let the ejection run out.
+		stats.ejectedUntil = time.Now().Add(-time.Millisecond)
+		stats.windowStart = stats.ejectedUntil
+	}
+}
+
+func TestOutlierFilter(t *testing.T) {
+	t.Run("never empties the set", func(t *testing.T) {
+		d := newOutlierDetector(OutlierSettings{ConsecutiveErrors: 1, MaxEjectionPercent: 100})
+		d.filter("jar-service", entries("jar-1"))
+		d.record("jar-service", "jar-1", false, 0)
+
+		if !d.isEjected("jar-service", "jar-1") {
+			t.Fatal("instance not ejected")
+		}
+		if got := entryIDs(d.filter("jar-service", entries("jar-1"))); len(got) != 1 {
+			t.Fatalf("filter() = %v, want the only instance back", got)
+		}
+	})
+
+	t.Run("services are tracked apart", func(t *testing.T) {
+		d := newOutlierDetector(OutlierSettings{ConsecutiveErrors: 1})
+		d.filter("jar-service", entries("shared-1", "shared-2"))
+		d.filter("order-service", entries("shared-1", "shared-2"))
+		d.record("jar-service", "shared-1", false, 0)
+
+		if d.isEjected("order-service", "shared-1") {
+			t.Fatal("ejection leaked to another service")
+		}
+	})
+
+	t.Run("prune forgets deregistered instances", func(t *testing.T) {
+		d := newOutlierDetector(OutlierSettings{ConsecutiveErrors: 1})
+		d.filter("jar-service", entries("jar-1", "jar-2"))
+		d.record("jar-service", "jar-1", false, 0)
+
+		d.prune("jar-service", entries("jar-2"))
+		if d.isEjected("jar-service", "jar-1") {
+			t.Fatal("pruned instance still ejected")
+		}
+		if _, ok := d.instances["jar-service"]["jar-1"]; ok {
+			t.Fatal("pruned instance still tracked")
+		}
+	})
+}
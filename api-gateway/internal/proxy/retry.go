@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy bounds how often an idempotent request is retried on another instance.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// BackoffFor returns the jittered exponential delay before the given retry (1-based).
+func (p RetryPolicy) BackoffFor(retry int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+
+	delay := p.Backoff << (retry - 1)
+	if p.MaxBackoff > 0 && (delay > p.MaxBackoff || delay <= 0) {
+		delay = p.MaxBackoff
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// IsIdempotent reports whether a request with this method can safely be sent twice.
+func IsIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
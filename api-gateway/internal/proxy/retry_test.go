@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoffFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		retry    int
+		min, max time.Duration
+	}{
+		{"no backoff", RetryPolicy{}, 3, 0, 0},
+		{"first retry", RetryPolicy{Backoff: 100 * time.Millisecond}, 1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{"doubles per retry", RetryPolicy{Backoff: 100 * time.Millisecond}, 3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{"capped", RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 250 * time.Millisecond}, 4, 125 * time.Millisecond, 250 * time.Millisecond},
+		{"capped on overflow", RetryPolicy{Backoff: time.Second, MaxBackoff: time.Minute}, 80, 30 * time.Second, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := tt.policy.BackoffFor(tt.retry)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("BackoffFor(%d) = %v, want between %v and %v", tt.retry, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	tests := map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodOptions: true,
+		http.MethodPut:     true,
+		http.MethodDelete:  true,
+		http.MethodPost:    false,
+		http.MethodPatch:   false,
+	}
+
+	for method, want := range tests {
+		if got := IsIdempotent(method); got != want {
+			t.Errorf("IsIdempotent(%s) = %v, want %v", method, got, want)
+		}
+	}
+}
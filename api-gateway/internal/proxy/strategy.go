@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	StrategyRoundRobin         = "round_robin"
+	StrategyLeastRequests      = "least_requests"
+	StrategyWeightedRoundRobin = "weighted_round_robin"
+	StrategyConsistentHash     = "consistent_hash"
+)
+
+// userIDHeader is set by the gateway's auth middleware once the token is verified.
+const userIDHeader = "X-User-ID"
+
+// weightMetaKey is the Consul service meta key read by weighted round robin.
+const weightMetaKey = "weight"
+
+// StrategyConfig selects the load-balancing strategy of a service. HashOn is
+// used by consistent hashing and is either "user" or "header:<name>".
+type StrategyConfig struct {
+	Name   string
+	HashOn string
+}
+
+// Candidate is an instance eligible for the current request together with the
+// number of requests the gateway currently has outstanding against it.
+type Candidate struct {
+	Entry    *api.ServiceEntry
+	Inflight int
+}
+
+// Strategy picks one of the candidates for a request. Candidates are never empty.
+type Strategy interface {
+	Select(r *http.Request, candidates []Candidate) Candidate
+}
+
+// StateReporter is implemented by strategies that keep state worth showing
+// when debugging their choices.
+type StateReporter interface {
+	State() any
+}
+
+func NewStrategy(config StrategyConfig) Strategy {
+	switch config.Name {
+	case "", StrategyRoundRobin:
+		return &roundRobin{}
+	case StrategyLeastRequests:
+		return &leastRequests{}
+	case StrategyWeightedRoundRobin:
+		return &weightedRoundRobin{current: make(map[string]int)}
+	case StrategyConsistentHash:
+		return &consistentHash{hashOn: config.HashOn}
+	default:
+		log.Printf("Unknown load-balancing strategy %q, using %s", config.Name, StrategyRoundRobin)
+		return &roundRobin{}
+	}
+}
+
+type roundRobin struct {
+	mu      sync.Mutex
+	counter uint64
+}
+
+func (s *roundRobin) Select(r *http.Request, candidates []Candidate) Candidate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.counter % uint64(len(candidates))
+	s.counter++
+	return candidates[index]
+}
+
+func (s *roundRobin) State() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return map[string]any{"counter": s.counter}
+}
+
+// leastRequests uses the power of two random choices: it compares two random
+// candidates and keeps the one with fewer outstanding requests.
+type leastRequests struct{}
+
+func (s *leastRequests) Select(r *http.Request, candidates []Candidate) Candidate {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	if candidates[j].Inflight < candidates[i].Inflight {
+		return candidates[j]
+	}
+	return candidates[i]
+}
+
+// weightedRoundRobin is the smooth weighted round robin used by nginx, with
+// weights taken from the "weight" service meta registered in Consul.
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+func (s *weightedRoundRobin) Select(r *http.Request, candidates []Candidate) Candidate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	best := -1
+	seen := make(map[string]bool, len(candidates))
+	for i, candidate := range candidates {
+		id := candidate.Entry.Service.ID
+		weight := instanceWeight(candidate.Entry)
+		seen[id] = true
+		total += weight
+		s.current[id] += weight
+		if best < 0 || s.current[id] > s.current[candidates[best].Entry.Service.ID] {
+			best = i
+		}
+	}
+	s.current[candidates[best].Entry.Service.ID] -= total
+
+	for id := range s.current {
+		if !seen[id] {
+			delete(s.current, id)
+		}
+	}
+
+	return candidates[best]
+}
+
+func (s *weightedRoundRobin) State() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := make(map[string]int, len(s.current))
+	for id, weight := range s.current {
+		current[id] = weight
+	}
+	return map[string]any{"current_weights": current}
+}
+
+func instanceWeight(entry *api.ServiceEntry) int {
+	weight, err := strconv.Atoi(entry.Service.Meta[weightMetaKey])
+	if err != nil || weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+const virtualNodes = 100
+
+// maxCachedRings bounds the rings kept per service; canary splits and retries
+// hash over several instance subsets.
+const maxCachedRings = 8
+
+type ringNode struct {
+	hash  uint32
+	index int
+}
+
+// consistentHash keeps requests with the same key on the same instance while
+// the instance set is stable. Requests without a key are round-robined.
+type consistentHash struct {
+	hashOn   string
+	fallback roundRobin
+
+	mu    sync.Mutex
+	rings map[string][]ringNode
+}
+
+func (s *consistentHash) Select(r *http.Request, candidates []Candidate) Candidate {
+	key := s.key(r)
+	if key == "" || len(candidates) == 1 {
+		return s.fallback.Select(r, candidates)
+	}
+
+	sorted := make([]Candidate, len(candidates))
+	copy(sorted, candidates)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Entry.Service.ID < sorted[j].Entry.Service.ID
+	})
+
+	ring := s.ringFor(sorted)
+	hash := hashString(key)
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= hash })
+	if i == len(ring) {
+		i = 0
+	}
+	return sorted[ring[i].index]
+}
+
+func (s *consistentHash) State() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return map[string]any{"hash_on": s.hashOn, "cached_rings": len(s.rings)}
+}
+
+func (s *consistentHash) key(r *http.Request) string {
+	switch {
+	case s.hashOn == "user":
+		return r.Header.Get(userIDHeader)
+	case strings.HasPrefix(s.hashOn, "header:"):
+		return r.Header.Get(strings.TrimPrefix(s.hashOn, "header:"))
+	default:
+		return ""
+	}
+}
+
+// ringFor returns the hash ring for the sorted candidates, building it only
+// for instance sets not seen before.
+func (s *consistentHash) ringFor(sorted []Candidate) []ringNode {
+	ids := make([]string, len(sorted))
+	for i, candidate := range sorted {
+		ids[i] = candidate.Entry.Service.ID
+	}
+	signature := strings.Join(ids, ",")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ring, ok := s.rings[signature]; ok {
+		return ring
+	}
+
+	ring := make([]ringNode, 0, len(ids)*virtualNodes)
+	for index, id := range ids {
+		for v := 0; v < virtualNodes; v++ {
+			ring = append(ring, ringNode{hash: hashString(id + "#" + strconv.Itoa(v)), index: index})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	if s.rings == nil || len(s.rings) >= maxCachedRings {
+		s.rings = make(map[string][]ringNode)
+	}
+	s.rings[signature] = ring
+	return ring
+}
+
+func hashString(value string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return h.Sum32()
+}
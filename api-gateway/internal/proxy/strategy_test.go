@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func candidates(ids ...string) []Candidate {
+	list := make([]Candidate, len(ids))
+	for i, entry := range entries(ids...) {
+		list[i] = Candidate{Entry: entry}
+	}
+	return list
+}
+
+func pick(s Strategy, r *http.Request, list []Candidate, n int) []string {
+	picked := make([]string, n)
+	for i := range picked {
+		picked[i] = s.Select(r, list).Entry.Service.ID
+	}
+	return picked
+}
+
+func TestNewStrategy(t *testing.T) {
+	tests := []struct {
+		name string
+		want Strategy
+	}{
+		{"", &roundRobin{}},
+		{StrategyRoundRobin, &roundRobin{}},
+		{StrategyLeastRequests, &leastRequests{}},
+		{StrategyWeightedRoundRobin, &weightedRoundRobin{}},
+		{StrategyConsistentHash, &consistentHash{}},
+		{"random", &roundRobin{}},
+	}
+
+	for _, tt := range tests {
+		if got := NewStrategy(StrategyConfig{Name: tt.name}); reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+			t.Errorf("NewStrategy(%q) = %T, want %T", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/jars", nil)
+	got := pick(NewStrategy(StrategyConfig{}), r, candidates("a", "b", "c"), 7)
+
+	if want := []string{"a", "b", "c", "a", "b", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("picks = %v, want %v", got, want)
+	}
+}
+
+func TestLeastRequests(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/jars", nil)
+	s := NewStrategy(StrategyConfig{Name: StrategyLeastRequests})
+
+	// Of two instances the idler one always wins.
+	pair := candidates("a", "b")
+	pair[0].Inflight = 4
+	for _, id := range pick(s, r, pair, 50) {
+		if id != "b" {
+			t.Fatalf("picked %s with %d requests outstanding over b with 0", id, pair[0].Inflight)
+		}
+	}
+
+	// The busiest instance loses every comparison, so it is never picked,
+	// while both others are.
+	three := candidates("a", "b", "c")
+	three[0].Inflight, three[1].Inflight, three[2].Inflight = 9, 1, 2
+	counts := make(map[string]int)
+	for _, id := range pick(s, r, three, 300) {
+		counts[id]++
+	}
+	if counts["a"] != 0 || counts["b"] == 0 || counts["c"] == 0 {
+		t.Fatalf("picks = %v, want b and c only", counts)
+	}
+
+	if got := s.Select(r, candidates("only")).Entry.Service.ID; got != "only" {
+		t.Fatalf("single candidate: picked %s", got)
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/jars", nil)
+	weighted := func(weights ...string) []Candidate {
+		list := make([]Candidate, len(weights))
+		for i, weight := range weights {
+			id := string(rune('a' + i))
+			list[i] = Candidate{Entry: entries(id)[0]}
+			list[i].Entry.Service.Meta = map[string]string{weightMetaKey: weight}
+		}
+		return list
+	}
+
+	tests := []struct {
+		name    string
+		weights []string
+		want    string
+	}{
+		// The smooth variant spreads the heavy instance's turns out.
+		{"smooth", []string{"5", "1", "1"}, "aabacaa"},
+		{"equal weights", []string{"2", "2"}, "abab"},
+		{"missing or invalid weights count as 1", []string{"", "0", "x"}, "abcabc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStrategy(StrategyConfig{Name: StrategyWeightedRoundRobin})
+			got := strings.Join(pick(s, r, weighted(tt.weights...), len(tt.want)), "")
+			if got != tt.want {
+				t.Fatalf("picks = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("forgets deregistered instances", func(t *testing.T) {
+		s := NewStrategy(StrategyConfig{Name: StrategyWeightedRoundRobin}).(*weightedRoundRobin)
+		pick(s, r, weighted("3", "1"), 3)
+		s.Select(r, weighted("3"))
+		if _, ok := s.current["b"]; ok || len(s.current) != 1 {
+			t.Fatalf("current weights = %v, want only a", s.current)
+		}
+	})
+}
+
+func TestConsistentHash(t *testing.T) {
+	request := func(header, value string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+		if value != "" {
+			r.Header.Set(header, value)
+		}
+		return r
+	}
+	three := candidates("a", "b", "c")
+	four := candidates("a", "b", "c", "d")
+
+	t.Run("same key, same instance, whatever the candidate order", func(t *testing.T) {
+		s := NewStrategy(StrategyConfig{Name: StrategyConsistentHash, HashOn: "user"})
+		reversed := candidates("c", "b", "a")
+		for user := 0; user < 50; user++ {
+			r := request(userIDHeader, fmt.Sprint(user))
+			first := s.Select(r, three).Entry.Service.ID
+			for _, id := range pick(s, r, three, 3) {
+				if id != first {
+					t.Fatalf("user %d moved from %s to %s", user, first, id)
+				}
+			}
+			if id := s.Select(r, reversed).Entry.Service.ID; id != first {
+				t.Fatalf("user %d moved from %s to %s when the candidates were reordered", user, first, id)
+			}
+		}
+	})
+
+	t.Run("a new instance only takes keys over", func(t *testing.T) {
+		s := NewStrategy(StrategyConfig{Name: StrategyConsistentHash, HashOn: "header:X-Cart-ID"})
+		moved := 0
+		for cart := 0; cart < 400; cart++ {
+			r := request("X-Cart-ID", fmt.Sprint("cart-", cart))
+			before := s.Select(r, three).Entry.Service.ID
+			after := s.Select(r, four).Entry.Service.ID
+			if after != before {
+				if after != "d" {
+					t.Fatalf("cart %d moved from %s to %s", cart, before, after)
+				}
+				moved++
+			}
+		}
+		// A modulo hash would move three quarters of the keys.
+		if moved == 0 || moved >= 200 {
+			t.Fatalf("%d of 400 keys moved, want some but under half", moved)
+		}
+	})
+
+	t.Run("requests without a key are round-robined", func(t *testing.T) {
+		s := NewStrategy(StrategyConfig{Name: StrategyConsistentHash, HashOn: "user"})
+		got := pick(s, request(userIDHeader, ""), three, 4)
+		if want := []string{"a", "b", "c", "a"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("picks = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("bounds the cached rings", func(t *testing.T) {
+		s := NewStrategy(StrategyConfig{Name: StrategyConsistentHash, HashOn: "user"}).(*consistentHash)
+		r := request(userIDHeader, "42")
+		for i := 0; i < 3*maxCachedRings; i++ {
+			s.Select(r, candidates("a", fmt.Sprint("b-", i)))
+		}
+		if len(s.rings) > maxCachedRings {
+			t.Fatalf("%d rings cached, want at most %d", len(s.rings), maxCachedRings)
+		}
+	})
+}
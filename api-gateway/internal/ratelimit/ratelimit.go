@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Quota is a token bucket: RequestsPerSecond tokens are added continuously up
+// to Burst.
+type Quota struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// Result describes the bucket after a request was counted against it.
+type Result struct {
+	Allowed    bool
+	Limit      int           // bucket size
+	Remaining  int           // requests that would be allowed right now
+	ResetAfter time.Duration // time until the bucket is full again
+	RetryAfter time.Duration // time until the next request is allowed, 0 when allowed
+}
+
+// Store counts requests against per-key buckets. Backlog reports how long
+// until a bucket is full again, zero for a full or unknown bucket.
+type Store interface {
+	Allow(ctx context.Context, key string, quota Quota) (Result, error)
+	Backlog(ctx context.Context, key string) (time.Duration, error)
+}
+
+// gcra implements the generic cell rate algorithm on the theoretical arrival
+// time (tat) of the bucket. It returns the result and the new tat.
+func gcra(now, tat time.Time, quota Quota) (Result, time.Time) {
+	interval := time.Duration(float64(time.Second) / quota.RequestsPerSecond)
+	tolerance := interval * time.Duration(quota.Burst)
+
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(interval)
+	allowAt := newTat.Add(-tolerance)
+
+	if now.Before(allowAt) {
+		return Result{
+			Allowed:    false,
+			Limit:      quota.Burst,
+			Remaining:  0,
+			ResetAfter: tat.Sub(now),
+			RetryAfter: allowAt.Sub(now),
+		}, tat
+	}
+
+	return Result{
+		Allowed:    true,
+		Limit:      quota.Burst,
+		Remaining:  int(math.Floor(float64(now.Sub(allowAt)) / float64(interval))),
+		ResetAfter: newTat.Sub(now),
+	}, newTat
+}
+
+// MemoryStore keeps buckets in the gateway process. Each replica enforces the
+// quota on its own.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]time.Time
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		buckets: make(map[string]time.Time),
+		now:     time.Now,
+	}
+
+	go s.cleanupBuckets()
+	return s
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, quota Quota) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, tat := gcra(s.now(), s.buckets[key], quota)
+	s.buckets[key] = tat
+	return result, nil
+}
+
+func (s *MemoryStore) Backlog(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return max(s.buckets[key].Sub(s.now()), 0), nil
+}
+
+// cleanupBuckets drops buckets that have refilled completely.
+func (s *MemoryStore) cleanupBuckets() {
+	for {
+		time.Sleep(time.Minute)
+
+		s.mu.Lock()
+		now := s.now()
+		for key, tat := range s.buckets {
+			if tat.Before(now) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// check is one request counted at an offset from the start of a test, and the
+// result expected for it.
+type check struct {
+	at         time.Duration
+	allowed    bool
+	remaining  int
+	resetAfter time.Duration
+	retryAfter time.Duration
+}
+
+// twoPerSecond refills a bucket of three every 500ms.
+var twoPerSecond = Quota{RequestsPerSecond: 2, Burst: 3}
+
+var gcraChecks = []struct {
+	name   string
+	checks []check
+}{
+	{
+		name: "burst then reject",
+		checks: []check{
+			{at: 0, allowed: true, remaining: 2, resetAfter: 500 * time.Millisecond},
+			{at: 0, allowed: true, remaining: 1, resetAfter: time.Second},
+			{at: 0, allowed: true, remaining: 0, resetAfter: 1500 * time.Millisecond},
+			{at: 0, allowed: false, remaining: 0, resetAfter: 1500 * time.Millisecond, retryAfter: 500 * time.Millisecond},
+			{at: 200 * time.Millisecond, allowed: false, remaining: 0, resetAfter: 1300 * time.Millisecond, retryAfter: 300 * time.Millisecond},
+		},
+	},
+	{
+		name: "refills one request per interval",
+		checks: []check{
+			{at: 0, allowed: true, remaining: 2, resetAfter: 500 * time.Millisecond},
+			{at: 0, allowed: true, remaining: 1, resetAfter: time.Second},
+			{at: 0, allowed: true, remaining: 0, resetAfter: 1500 * time.Millisecond},
+			{at: 500 * time.Millisecond, allowed: true, remaining: 0, resetAfter: 1500 * time.Millisecond},
+			{at: 500 * time.Millisecond, allowed: false, remaining: 0, resetAfter: 1500 * time.Millisecond, retryAfter: 500 * time.Millisecond},
+			{at: time.Second, allowed: true, remaining: 0, resetAfter: 1500 * time.Millisecond},
+		},
+	},
+	{
+		name: "full again after the reset time",
+		checks: []check{
+			{at: 0, allowed: true, remaining: 2, resetAfter: 500 * time.Millisecond},
+			{at: 0, allowed: true, remaining: 1, resetAfter: time.Second},
+			{at: 0, allowed: true, remaining: 0, resetAfter: 1500 * time.Millisecond},
+			{at: 1500 * time.Millisecond, allowed: true, remaining: 2, resetAfter: 500 * time.Millisecond},
+		},
+	},
+}
+
+func TestGCRA(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+
+	for _, tt := range gcraChecks {
+		t.Run(tt.name, func(t *testing.T) {
+			var tat time.Time
+			for i, c := range tt.checks {
+				var result Result
+				result, tat = gcra(start.Add(c.at), tat, twoPerSecond)
+				assertResult(t, i, result, c)
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	ctx := context.Background()
+
+	for _, tt := range gcraChecks {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			store := &MemoryStore{buckets: make(map[string]time.Time), now: func() time.Time { return now }}
+			for i, c := range tt.checks {
+				now = start.Add(c.at)
+				result, err := store.Allow(ctx, "client", twoPerSecond)
+				if err != nil {
+					t.Fatalf("check %d: Allow() error = %v", i, err)
+				}
+				assertResult(t, i, result, c)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreBacklog(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	store := &MemoryStore{buckets: make(map[string]time.Time), now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	if backlog, _ := store.Backlog(ctx, "client"); backlog != 0 {
+		t.Fatalf("unknown bucket: Backlog() = %v, want 0", backlog)
+	}
+
+	store.Allow(ctx, "client", twoPerSecond)
+	store.Allow(ctx, "client", twoPerSecond)
+	if backlog, _ := store.Backlog(ctx, "client"); backlog != time.Second {
+		t.Fatalf("Backlog() = %v, want 1s", backlog)
+	}
+
+	now = now.Add(2 * time.Second)
+	if backlog, _ := store.Backlog(ctx, "client"); backlog != 0 {
+		t.Fatalf("refilled bucket: Backlog() = %v, want 0", backlog)
+	}
+}
+
+func TestMemoryStoreKeepsKeysApart(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	store := &MemoryStore{buckets: make(map[string]time.Time), now: func() time.Time { return now }}
+	ctx := context.Background()
+	quota := Quota{RequestsPerSecond: 1, Burst: 1}
+
+	if result, _ := store.Allow(ctx, "a", quota); !result.Allowed {
+		t.Fatal("first request of a rejected")
+	}
+	if result, _ := store.Allow(ctx, "a", quota); result.Allowed {
+		t.Fatal("second request of a allowed")
+	}
+	if result, _ := store.Allow(ctx, "b", quota); !result.Allowed {
+		t.Fatal("first request of b rejected")
+	}
+}
+
+func assertResult(t *testing.T, i int, got Result, want check) {
+	t.Helper()
+	if got.Allowed != want.allowed {
+		t.Fatalf("check %d: Allowed = %v, want %v", i, got.Allowed, want.allowed)
+	}
+	if got.Limit != twoPerSecond.Burst {
+		t.Errorf("check %d: Limit = %d, want %d", i, got.Limit, twoPerSecond.Burst)
+	}
+	if got.Remaining != want.remaining {
+		t.Errorf("check %d: Remaining = %d, want %d", i, got.Remaining, want.remaining)
+	}
+	if got.ResetAfter != want.resetAfter {
+		t.Errorf("check %d: ResetAfter = %v, want %v", i, got.ResetAfter, want.resetAfter)
+	}
+	if got.RetryAfter != want.retryAfter {
+		t.Errorf("check %d: RetryAfter = %v, want %v", i, got.RetryAfter, want.retryAfter)
+	}
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcraScript applies the algorithm of gcra atomically on the Redis server
+// clock, so every gateway replica draws from the same bucket.
+//
+// KEYS[1] bucket key; ARGV[1] emission interval and ARGV[2] burst tolerance in
+// microseconds. Returns {allowed, remaining, reset_after_us, retry_after_us}.
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if not tat or tat < now then
+  tat = now
+end
+
+local new_tat = tat + interval
+local allow_at = new_tat - tolerance
+
+if now < allow_at then
+  return {0, 0, tat - now, allow_at - now}
+end
+
+redis.call("SET", KEYS[1], string.format("%.0f", new_tat), "PX", math.ceil((new_tat - now) / 1000))
+return {1, math.floor((now - allow_at) / interval), new_tat - now, 0}
+`)
+
+// backlogScript returns how far the bucket's tat is ahead of the server
+// clock, in microseconds.
+var backlogScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if not tat or tat < now then
+  return 0
+end
+return tat - now
+`)
+
+// failoverLogInterval limits how often an unreachable Redis is logged.
+const failoverLogInterval = 30 * time.Second
+
+// RedisStore keeps buckets in Redis so that all gateway replicas enforce one
+// budget. While Redis is unreachable each replica falls back to local buckets.
+type RedisStore struct {
+	client   *redis.Client
+	prefix   string
+	fallback *MemoryStore
+
+	mu           sync.Mutex
+	lastLoggedAt time.Time
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client:   client,
+		prefix:   "ratelimit:",
+		fallback: NewMemoryStore(),
+	}
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, quota Quota) (Result, error) {
+	interval := time.Duration(float64(time.Second) / quota.RequestsPerSecond)
+	tolerance := interval * time.Duration(quota.Burst)
+
+	values, err := gcraScript.Run(ctx, s.client, []string{s.prefix + key}, interval.Microseconds(), tolerance.Microseconds()).Int64Slice()
+	if err != nil || len(values) != 4 {
+		if err == nil {
+			err = fmt.Errorf("unexpected reply %v", values)
+		}
+		s.logFailover(err)
+		return s.fallback.Allow(ctx, key, quota)
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      quota.Burst,
+		Remaining:  int(values[1]),
+		ResetAfter: time.Duration(values[2]) * time.Microsecond,
+		RetryAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
+
+func (s *RedisStore) Backlog(ctx context.Context, key string) (time.Duration, error) {
+	backlog, err := backlogScript.Run(ctx, s.client, []string{s.prefix + key}).Int64()
+	if err != nil {
+		s.logFailover(err)
+		return s.fallback.Backlog(ctx, key)
+	}
+	return time.Duration(backlog) * time.Microsecond, nil
+}
+
+func (s *RedisStore) logFailover(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastLoggedAt) < failoverLogInterval {
+		return
+	}
+	s.lastLoggedAt = time.Now()
+	log.Printf("Rate limit store unavailable, using local buckets: %v", err)
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// The Lua script must give the same answers as gcra. miniredis runs it with
+// the server clock set by each check.
+func TestRedisStoreScript(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	ctx := context.Background()
+
+	for _, tt := range gcraChecks {
+		t.Run(tt.name, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			store := NewRedisStore(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+
+			for i, c := range tt.checks {
+				server.SetTime(start.Add(c.at))
+				result, err := store.Allow(ctx, "client", twoPerSecond)
+				if err != nil {
+					t.Fatalf("check %d: Allow() error = %v", i, err)
+				}
+				assertResult(t, i, result, c)
+			}
+
+			if !server.Exists("ratelimit:client") {
+				t.Fatal("bucket not stored under the ratelimit: prefix")
+			}
+		})
+	}
+}
+
+func TestRedisStoreBacklog(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	store := NewRedisStore(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+
+	server.SetTime(start)
+	store.Allow(ctx, "client", twoPerSecond)
+	store.Allow(ctx, "client", twoPerSecond)
+
+	server.SetTime(start.Add(250 * time.Millisecond))
+	backlog, err := store.Backlog(ctx, "client")
+	if err != nil {
+		t.Fatalf("Backlog() error = %v", err)
+	}
+	if backlog != 750*time.Millisecond {
+		t.Fatalf("Backlog() = %v, want 750ms", backlog)
+	}
+}
+
+func TestRedisStoreFallsBackToLocalBuckets(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1}))
+	server.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	store.fallback.now = func() time.Time { return now }
+	store.lastLoggedAt = time.Now() // keep the test output quiet
+	ctx := context.Background()
+	quota := Quota{RequestsPerSecond: 1, Burst: 2}
+
+	for i, want := range []bool{true, true, false} {
+		result, err := store.Allow(ctx, "client", quota)
+		if err != nil {
+			t.Fatalf("request %d: Allow() error = %v", i, err)
+		}
+		if result.Allowed != want {
+			t.Fatalf("request %d: Allowed = %v, want %v", i, result.Allowed, want)
+		}
+	}
+
+	backlog, err := store.Backlog(ctx, "client")
+	if err != nil {
+		t.Fatalf("Backlog() error = %v", err)
+	}
+	if backlog != 2*time.Second {
+		t.Fatalf("Backlog() = %v, want 2s from the local bucket", backlog)
+	}
+}
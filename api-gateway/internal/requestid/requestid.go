@@ -0,0 +1,57 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header carries the request ID on HTTP requests and responses.
+const Header = "X-Request-ID"
+
+// Kafka message headers. Events carry the ID of the request that started the
+// chain they belong to, and the ID of the request or event that caused them.
+const (
+	MessageHeader   = "request-id"
+	CausationHeader = "causation-id"
+)
+
+// maxLength bounds IDs accepted from clients, which end up in every log line.
+const maxLength = 128
+
+type contextKey struct{}
+
+// New returns a random 128-bit ID.
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether an ID received from a client may be reused: it must be
+// short and made of URL-safe characters only.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID, or "" outside a request.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
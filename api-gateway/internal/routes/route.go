@@ -0,0 +1,380 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Route maps a public path prefix, or a single exact Path, on the gateway to
+// an upstream service.
+type Route struct {
+	Name       string        `yaml:"name"`
+	Path       string        `yaml:"path,omitempty"`
+	PathPrefix string        `yaml:"path_prefix,omitempty"`
+	Service    string        `yaml:"service"`
+	Methods    []string      `yaml:"methods,omitempty"`
+	Rewrite    Rewrite       `yaml:"rewrite,omitempty"`
+	Timeout    time.Duration `yaml:"timeout,omitempty"`
+	Retry      *Retry        `yaml:"retry,omitempty"`
+	Public     bool          `yaml:"public,omitempty"`
+	RateLimit  *RateLimit    `yaml:"rate_limit,omitempty"`
+	Cache      *Cache        `yaml:"cache,omitempty"`
+	Mirror     *Mirror       `yaml:"mirror,omitempty"`
+}
+
+// Rewrite describes how the incoming path is translated for the upstream.
+type Rewrite struct {
+	StripPrefix string `yaml:"strip_prefix,omitempty"`
+	AddPrefix   string `yaml:"add_prefix,omitempty"`
+}
+
+// Retry overrides the gateway retry policy for idempotent requests on this route.
+type Retry struct {
+	Attempts int           `yaml:"attempts"`
+	Backoff  time.Duration `yaml:"backoff"`
+}
+
+// Cache stores the route's GET responses in the gateway. TTL applies when the
+// upstream sends no Cache-Control max-age; any event on an InvalidatedBy topic
+// purges the route's entries.
+type Cache struct {
+	TTL           time.Duration `yaml:"ttl"`
+	InvalidatedBy []string      `yaml:"invalidated_by,omitempty"`
+}
+
+// Mirror duplicates Percent of the route's requests to a shadow service and
+// compares its responses with the primary's. LogPercent of the mismatches are
+// logged. Percent defaults to 100 when unset and turns mirroring off at 0;
+// LogPercent defaults to 1 when left at 0. Only the listed Methods are
+// mirrored, GET and HEAD unless the route opts in to others.
+type Mirror struct {
+	Service    string        `yaml:"service"`
+	Percent    *float64      `yaml:"percent,omitempty"`
+	LogPercent float64       `yaml:"log_percent,omitempty"`
+	Methods    []string      `yaml:"methods,omitempty"`
+	Timeout    time.Duration `yaml:"timeout,omitempty"`
+}
+
+// defaultMirrorMethods are mirrored when a route lists none. They are safe, so
+// the shadow cannot repeat the primary's writes.
+var defaultMirrorMethods = []string{http.MethodGet, http.MethodHead}
+
+// Quota is a token bucket refilled at RequestsPerSecond up to Burst requests.
+type Quota struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// RateLimit applies its quota to every client of the route. Tiers override it
+// by the role of the authenticated user, or "anonymous" for other requests.
+type RateLimit struct {
+	Quota `yaml:",inline"`
+	Tiers map[string]Quota `yaml:"tiers,omitempty"`
+}
+
+// Service holds gateway settings shared by every route of an upstream service.
+// Critical services must have healthy instances for the gateway to report ready.
+type Service struct {
+	Critical      bool          `yaml:"critical,omitempty"`
+	LoadBalancing LoadBalancing `yaml:"load_balancing,omitempty"`
+	Canary        *Canary       `yaml:"canary,omitempty"`
+}
+
+// LoadBalancing selects how instances of a service are picked. HashOn applies
+// to consistent_hash and is either "user" or "header:<name>".
+type LoadBalancing struct {
+	Strategy string `yaml:"strategy,omitempty"`
+	HashOn   string `yaml:"hash_on,omitempty"`
+}
+
+// Canary routes Weight percent of users to the instances registered with
+// Version. A request whose Header or Cookie names a version picks its side
+// regardless of the weight.
+type Canary struct {
+	Version string  `yaml:"version"`
+	Weight  float64 `yaml:"weight"`
+	Header  string  `yaml:"header,omitempty"`
+	Cookie  string  `yaml:"cookie,omitempty"`
+}
+
+var strategies = map[string]bool{
+	"":                     true,
+	"round_robin":          true,
+	"least_requests":       true,
+	"weighted_round_robin": true,
+	"consistent_hash":      true,
+}
+
+// Table is an immutable, validated set of routes ordered from the most to the
+// least specific: exact paths first, then path prefixes from the longest.
+type Table struct {
+	Routes   []*Route            `yaml:"routes"`
+	Services map[string]*Service `yaml:"services,omitempty"`
+}
+
+type routeKey struct{}
+
+// FromContext returns the route matched for the current request.
+func FromContext(ctx context.Context) (*Route, bool) {
+	route, ok := ctx.Value(routeKey{}).(*Route)
+	return route, ok
+}
+
+func withRoute(ctx context.Context, route *Route) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// Parse decodes a route table from YAML. JSON documents are accepted as well.
+func Parse(data []byte) (*Table, error) {
+	var table Table
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse route table: %w", err)
+	}
+
+	if err := table.Validate(); err != nil {
+		return nil, err
+	}
+
+	for _, route := range table.Routes {
+		for i, method := range route.Methods {
+			route.Methods[i] = strings.ToUpper(method)
+		}
+		if route.Mirror != nil {
+			for i, method := range route.Mirror.Methods {
+				route.Mirror.Methods[i] = strings.ToUpper(method)
+			}
+		}
+	}
+
+	sort.SliceStable(table.Routes, func(i, j int) bool {
+		a, b := table.Routes[i], table.Routes[j]
+		if (a.Path != "") != (b.Path != "") {
+			return a.Path != ""
+		}
+		return len(a.PathPrefix) > len(b.PathPrefix)
+	})
+
+	return &table, nil
+}
+
+// ServiceNames returns every upstream service named by a route, as primary or
+// mirror, or in the services section, sorted.
+func (t *Table) ServiceNames() []string {
+	seen := make(map[string]bool)
+	for _, route := range t.Routes {
+		seen[route.Service] = true
+		if route.Mirror != nil {
+			seen[route.Mirror.Service] = true
+		}
+	}
+	for name := range t.Services {
+		seen[name] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t *Table) Validate() error {
+	if len(t.Routes) == 0 {
+		return fmt.Errorf("route table has no routes")
+	}
+
+	names := make(map[string]bool, len(t.Routes))
+	for i, route := range t.Routes {
+		if route == nil {
+			return fmt.Errorf("route %d is empty", i)
+		}
+		if route.Name == "" {
+			return fmt.Errorf("route %d: name is required", i)
+		}
+		if names[route.Name] {
+			return fmt.Errorf("route %s: duplicate name", route.Name)
+		}
+		names[route.Name] = true
+
+		if err := route.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Name, err)
+		}
+	}
+
+	for name, service := range t.Services {
+		if service == nil {
+			continue
+		}
+		if err := service.Validate(); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) Validate() error {
+	lb := s.LoadBalancing
+	if !strategies[lb.Strategy] {
+		return fmt.Errorf("unknown load_balancing.strategy %q", lb.Strategy)
+	}
+	if lb.Strategy == "consistent_hash" && lb.HashOn != "user" && !strings.HasPrefix(lb.HashOn, "header:") {
+		return fmt.Errorf("load_balancing.hash_on must be \"user\" or \"header:<name>\"")
+	}
+	if s.Canary != nil && s.Canary.Version == "" {
+		return fmt.Errorf("canary.version is required")
+	}
+	if s.Canary != nil && (s.Canary.Weight < 0 || s.Canary.Weight > 100) {
+		return fmt.Errorf("canary.weight must be between 0 and 100")
+	}
+	return nil
+}
+
+func (r *Route) Validate() error {
+	if (r.Path == "") == (r.PathPrefix == "") {
+		return fmt.Errorf("exactly one of path and path_prefix is required")
+	}
+	if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /")
+	}
+	if r.Service == "" {
+		return fmt.Errorf("service is required")
+	}
+	if r.Rewrite.StripPrefix != "" && !strings.HasPrefix(r.Path+r.PathPrefix, r.Rewrite.StripPrefix) {
+		return fmt.Errorf("rewrite.strip_prefix must be a prefix of the route path")
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	if r.Retry != nil && r.Retry.Attempts < 1 {
+		return fmt.Errorf("retry.attempts must be at least 1")
+	}
+	if r.Retry != nil && r.Retry.Backoff < 0 {
+		return fmt.Errorf("retry.backoff cannot be negative")
+	}
+	if r.Cache != nil && r.Cache.TTL < 0 {
+		return fmt.Errorf("cache.ttl cannot be negative")
+	}
+	if r.Mirror != nil {
+		if err := r.Mirror.Validate(r.Service); err != nil {
+			return fmt.Errorf("mirror: %w", err)
+		}
+	}
+	if r.RateLimit != nil {
+		if err := r.RateLimit.Quota.Validate(); err != nil {
+			return fmt.Errorf("rate_limit: %w", err)
+		}
+		for tier, quota := range r.RateLimit.Tiers {
+			if err := quota.Validate(); err != nil {
+				return fmt.Errorf("rate_limit.tiers.%s: %w", tier, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) Validate(primary string) error {
+	if m.Service == "" {
+		return fmt.Errorf("service is required")
+	}
+	if m.Service == primary {
+		return fmt.Errorf("service must differ from the route service")
+	}
+	if m.Percent != nil && (*m.Percent < 0 || *m.Percent > 100) {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+	if m.LogPercent < 0 || m.LogPercent > 100 {
+		return fmt.Errorf("log_percent must be between 0 and 100")
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	return nil
+}
+
+func (q Quota) Validate() error {
+	if q.RequestsPerSecond <= 0 {
+		return fmt.Errorf("requests_per_second must be positive")
+	}
+	if q.Burst < 0 {
+		return fmt.Errorf("burst cannot be negative")
+	}
+	return nil
+}
+
+// Mirrors reports whether requests with the method are copied to the shadow.
+func (m *Mirror) Mirrors(method string) bool {
+	methods := m.Methods
+	if len(methods) == 0 {
+		methods = defaultMirrorMethods
+	}
+	for _, allowed := range methods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether the path equals the route path, or falls under the
+// route prefix on a segment boundary.
+func (r *Route) Matches(path string) bool {
+	if r.Path != "" {
+		return path == r.Path
+	}
+	if !strings.HasPrefix(path, r.PathPrefix) {
+		return false
+	}
+	rest := path[len(r.PathPrefix):]
+	return rest == "" || rest[0] == '/' || strings.HasSuffix(r.PathPrefix, "/")
+}
+
+// Allows reports whether the method is accepted by the route. A route without
+// methods accepts all of them.
+func (r *Route) Allows(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// RewritePath translates the incoming gateway path into the upstream path.
+func (r *Route) RewritePath(path string) string {
+	path = strings.TrimPrefix(path, r.Rewrite.StripPrefix)
+	path = r.Rewrite.AddPrefix + path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// Match returns the most specific route for the request. When a route matches
+// the path but not the method, the allowed methods are returned instead.
+func (t *Table) Match(r *http.Request) (*Route, []string) {
+	var allowed []string
+	for _, route := range t.Routes {
+		if !route.Matches(r.URL.Path) {
+			continue
+		}
+		if route.Allows(r.Method) {
+			return route, nil
+		}
+		allowed = append(allowed, route.Methods...)
+	}
+	return nil, allowed
+}
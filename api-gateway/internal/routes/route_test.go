@@ -0,0 +1,259 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const table = `
+routes:
+  - name: users
+    path_prefix: /api/users
+    service: user-service
+  - name: users-login
+    path: /api/users/login
+    service: user-service
+    methods: [post]
+    public: true
+  - name: api
+    path_prefix: /api
+    service: fallback-service
+  - name: orders
+    path_prefix: /api/orders
+    service: order-service
+    methods: [GET, post]
+  - name: order-admin
+    path_prefix: /api/orders/admin
+    service: order-service
+    methods: [DELETE]
+  - name: static
+    path_prefix: /static/
+    service: static-service
+    methods: [GET, HEAD]
+`
+
+func TestMatch(t *testing.T) {
+	routes, err := Parse([]byte(table))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		route   string
+		allowed []string
+	}{
+		{"exact path wins over a longer prefix match", http.MethodPost, "/api/users/login", "users-login", nil},
+		{"exact path falls back to the prefix for other methods", http.MethodGet, "/api/users/login", "users", nil},
+		{"exact path does not match below it", http.MethodPost, "/api/users/login/extra", "users", nil},
+		{"prefix itself", http.MethodGet, "/api/users", "users", nil},
+		{"below the prefix", http.MethodGet, "/api/users/7", "users", nil},
+		{"segment boundary", http.MethodGet, "/api/usersX", "api", nil},
+		{"longest prefix first", http.MethodDelete, "/api/orders/admin/7", "order-admin", nil},
+		{"method filter moves on to shorter prefixes", http.MethodGet, "/api/orders/admin/7", "orders", nil},
+		{"methods are matched case-insensitively in the table", http.MethodPost, "/api/orders", "orders", nil},
+		{"other methods fall through to the catch-all", http.MethodPut, "/api/orders/7", "api", nil},
+		{"method not allowed", http.MethodPut, "/static/app.js", "", []string{http.MethodGet, http.MethodHead}},
+		{"prefix ending in a slash", http.MethodGet, "/static/app.js", "static", nil},
+		{"prefix ending in a slash needs the slash", http.MethodGet, "/static", "", nil},
+		{"no route", http.MethodGet, "/health", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, allowed := routes.Match(httptest.NewRequest(tt.method, tt.path, nil))
+
+			var name string
+			if route != nil {
+				name = route.Name
+			}
+			if name != tt.route {
+				t.Fatalf("Match(%s %s) = %q, want %q", tt.method, tt.path, name, tt.route)
+			}
+			if !reflect.DeepEqual(allowed, tt.allowed) {
+				t.Fatalf("allowed methods = %v, want %v", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestParseSortsRoutes(t *testing.T) {
+	routes, err := Parse([]byte(table))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var names []string
+	for _, route := range routes.Routes {
+		names = append(names, route.Name)
+	}
+	want := []string{"users-login", "order-admin", "orders", "users", "static", "api"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("route order = %v, want %v", names, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		route string
+		err   string
+	}{
+		{"valid", "path_prefix: /api/jars\n    service: jar-service", ""},
+		{"path and prefix", "path: /api/jars\n    path_prefix: /api/jars\n    service: jar-service", "exactly one of path and path_prefix"},
+		{"neither path nor prefix", "service: jar-service", "exactly one of path and path_prefix"},
+		{"relative prefix", "path_prefix: api/jars\n    service: jar-service", "path_prefix must start with /"},
+		{"relative path", "path: api/jars\n    service: jar-service", "path must start with /"},
+		{"no service", "path_prefix: /api/jars", "service is required"},
+		{"strip_prefix of the prefix", "path_prefix: /api/jars\n    service: jar-service\n    rewrite: {strip_prefix: /api}", ""},
+		{"strip_prefix of an exact path", "path: /api/jars/facets\n    service: jar-service\n    rewrite: {strip_prefix: /api/jars}", ""},
+		{"strip_prefix outside the path", "path_prefix: /api/jars\n    service: jar-service\n    rewrite: {strip_prefix: /v1}", "rewrite.strip_prefix must be a prefix"},
+		{"strip_prefix longer than the prefix", "path_prefix: /api\n    service: jar-service\n    rewrite: {strip_prefix: /api/jars}", "rewrite.strip_prefix must be a prefix"},
+		{"mirror to the route service", "path_prefix: /api/jars\n    service: jar-service\n    mirror: {service: jar-service}", "mirror: service must differ"},
+		{"mirror without a service", "path_prefix: /api/jars\n    service: jar-service\n    mirror: {percent: 10}", "mirror: service is required"},
+		{"mirror percent out of range", "path_prefix: /api/jars\n    service: jar-service\n    mirror: {service: jar-v2, percent: 120}", "mirror: percent must be between 0 and 100"},
+		{"retry without attempts", "path_prefix: /api/jars\n    service: jar-service\n    retry: {attempts: 0}", "retry.attempts must be at least 1"},
+		{"rate limit without a rate", "path_prefix: /api/jars\n    service: jar-service\n    rate_limit: {burst: 5}", "rate_limit: requests_per_second must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte("routes:\n  - name: jars\n    " + tt.route + "\n"))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Parse() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Parse() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateTable(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+		err   string
+	}{
+		{"no routes", "routes: []", "route table has no routes"},
+		{"duplicate names", "routes:\n  - {name: jars, path_prefix: /a, service: s}\n  - {name: jars, path_prefix: /b, service: s}", "duplicate name"},
+		{"unknown strategy", "routes:\n  - {name: jars, path_prefix: /a, service: s}\nservices:\n  s: {load_balancing: {strategy: random}}", "unknown load_balancing.strategy"},
+		{"canary without a version", "routes:\n  - {name: jars, path_prefix: /a, service: s}\nservices:\n  s: {canary: {weight: 5}}", "canary.version is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.table)); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Parse() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMirrorDefaults(t *testing.T) {
+	routes, err := Parse([]byte(`
+routes:
+  - name: jars
+    path_prefix: /api/jars
+    service: jar-service
+    mirror: {service: jar-service-v2}
+  - name: orders
+    path_prefix: /api/orders
+    service: order-service
+    mirror: {service: order-service-v2, percent: 0, methods: [get, post]}
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	byName := make(map[string]*Route)
+	for _, route := range routes.Routes {
+		byName[route.Name] = route
+	}
+
+	tests := []struct {
+		route  string
+		method string
+		want   bool
+	}{
+		{"jars", http.MethodGet, true},
+		{"jars", http.MethodHead, true},
+		{"jars", http.MethodPost, false},
+		{"jars", http.MethodPut, false},
+		{"jars", http.MethodPatch, false},
+		{"jars", http.MethodDelete, false},
+		{"orders", http.MethodGet, true},
+		{"orders", http.MethodPost, true},
+		{"orders", http.MethodHead, false},
+	}
+	for _, tt := range tests {
+		if got := byName[tt.route].Mirror.Mirrors(tt.method); got != tt.want {
+			t.Errorf("%s: Mirrors(%s) = %v, want %v", tt.route, tt.method, got, tt.want)
+		}
+	}
+
+	if percent := byName["jars"].Mirror.Percent; percent != nil {
+		t.Errorf("jars: percent = %v, want unset", *percent)
+	}
+	if percent := byName["orders"].Mirror.Percent; percent == nil || *percent != 0 {
+		t.Errorf("orders: percent = %v, want an explicit 0", percent)
+	}
+	if names := routes.ServiceNames(); !reflect.DeepEqual(names, []string{"jar-service", "jar-service-v2", "order-service", "order-service-v2"}) {
+		t.Errorf("ServiceNames() = %v, want primaries and mirrors", names)
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		rewrite Rewrite
+		path    string
+		want    string
+	}{
+		{Rewrite{StripPrefix: "/api"}, "/api/jars/7", "/jars/7"},
+		{Rewrite{StripPrefix: "/api/jars"}, "/api/jars", "/"},
+		{Rewrite{StripPrefix: "/api", AddPrefix: "/v2"}, "/api/jars", "/v2/jars"},
+		{Rewrite{}, "/api/jars", "/api/jars"},
+	}
+
+	for _, tt := range tests {
+		route := &Route{Rewrite: tt.rewrite}
+		if got := route.RewritePath(tt.path); got != tt.want {
+			t.Errorf("RewritePath(%q) with %+v = %q, want %q", tt.path, tt.rewrite, got, tt.want)
+		}
+	}
+}
+
+// The table shipped with the gateway must load.
+func TestShippedTable(t *testing.T) {
+	data, err := os.ReadFile("../../routes.yaml")
+	if err != nil {
+		t.Fatalf("failed to read routes.yaml: %v", err)
+	}
+	routes, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse(routes.yaml) error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		method, path, route string
+		public              bool
+	}{
+		{http.MethodPost, "/api/users/login", "users-login", true},
+		{http.MethodPost, "/api/users/register", "users-register", true},
+		{http.MethodPost, "/api/users/api-keys", "users", false},
+		{http.MethodGet, "/api/users/me", "users", false},
+	} {
+		route, _ := routes.Match(httptest.NewRequest(tt.method, tt.path, nil))
+		if route == nil || route.Name != tt.route || route.Public != tt.public {
+			t.Errorf("%s %s matched %+v, want %s (public %v)", tt.method, tt.path, route, tt.route, tt.public)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/0Bleak/api-gateway/internal/problem"
+)
+
+// HandlerBuilder creates the handler chain serving a single route.
+type HandlerBuilder func(route *Route) http.Handler
+
+type compiledTable struct {
+	table    *Table
+	handlers map[*Route]http.Handler
+}
+
+// Router dispatches requests according to the current route table. The table
+// can be swapped at any time without interrupting in-flight requests.
+type Router struct {
+	build   HandlerBuilder
+	current atomic.Pointer[compiledTable]
+}
+
+func NewRouter(build HandlerBuilder) *Router {
+	return &Router{build: build}
+}
+
+// Load compiles the handlers for every route and atomically replaces the active table.
+func (rt *Router) Load(table *Table) {
+	compiled := &compiledTable{
+		table:    table,
+		handlers: make(map[*Route]http.Handler, len(table.Routes)),
+	}
+	for _, route := range table.Routes {
+		compiled.handlers[route] = rt.build(route)
+	}
+	rt.current.Store(compiled)
+}
+
+// Table returns the active route table, or nil before the first Load.
+func (rt *Router) Table() *Table {
+	compiled := rt.current.Load()
+	if compiled == nil {
+		return nil
+	}
+	return compiled.table
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	compiled := rt.current.Load()
+	if compiled == nil {
+		problem.Write(w, r, http.StatusServiceUnavailable, "Route table not loaded")
+		return
+	}
+
+	route, allowed := compiled.table.Match(r)
+	if route == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			problem.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		problem.NotFound(w, r)
+		return
+	}
+
+	compiled.handlers[route].ServeHTTP(w, r.WithContext(withRoute(r.Context(), route)))
+}
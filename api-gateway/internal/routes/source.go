@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/0Bleak/api-gateway/internal/discovery"
+)
+
+// Source provides the raw route table document.
+type Source interface {
+	Load(ctx context.Context) ([]byte, error)
+	String() string
+}
+
+type fileSource struct {
+	path string
+}
+
+func NewFileSource(path string) Source {
+	return &fileSource{path: path}
+}
+
+func (s *fileSource) Load(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(s.path)
+}
+
+func (s *fileSource) String() string {
+	return "file " + s.path
+}
+
+type consulSource struct {
+	consul *discovery.ConsulClient
+	key    string
+}
+
+func NewConsulSource(consul *discovery.ConsulClient, key string) Source {
+	return &consulSource{consul: consul, key: key}
+}
+
+func (s *consulSource) Load(ctx context.Context) ([]byte, error) {
+	return s.consul.GetKey(ctx, s.key)
+}
+
+func (s *consulSource) String() string {
+	return "consul key " + s.key
+}
+
+// Watcher keeps the gateway in sync with a Source, handing every new table to
+// the apply funcs whenever the document changes. Invalid documents are logged
+// and the previous table is kept.
+type Watcher struct {
+	source   Source
+	interval time.Duration
+	apply    []func(*Table)
+	last     []byte
+}
+
+func NewWatcher(source Source, interval time.Duration, apply ...func(*Table)) *Watcher {
+	return &Watcher{
+		source:   source,
+		interval: interval,
+		apply:    apply,
+	}
+}
+
+// Load reads the source once and installs the table.
+func (w *Watcher) Load(ctx context.Context) error {
+	data, err := w.source.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read route table from %s: %w", w.source, err)
+	}
+
+	if w.last != nil && bytes.Equal(data, w.last) {
+		return nil
+	}
+
+	table, err := Parse(data)
+	if err != nil {
+		return err
+	}
+
+	for _, apply := range w.apply {
+		apply(table)
+	}
+	w.last = data
+	log.Printf("Loaded %d routes from %s", len(table.Routes), w.source)
+	return nil
+}
+
+// Run reloads the table every interval until the context is cancelled.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Load(ctx); err != nil {
+				log.Printf("Failed to reload route table: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// memorySource returns whatever document the test last set.
+type memorySource struct {
+	data []byte
+	err  error
+}
+
+func (s *memorySource) Load(ctx context.Context) ([]byte, error) {
+	return s.data, s.err
+}
+
+func (s *memorySource) String() string {
+	return "memory"
+}
+
+func TestWatcherLoad(t *testing.T) {
+	v1 := []byte("routes:\n  - {name: jars, path_prefix: /api/jars, service: jar-service}\n")
+	v2 := []byte("routes:\n  - {name: jars, path_prefix: /api/jars, service: jar-service-v2}\n")
+
+	source := &memorySource{data: v1}
+	var applied []string
+	watcher := NewWatcher(source, 0, func(table *Table) {
+		applied = append(applied, table.Routes[0].Service)
+	})
+	ctx := context.Background()
+
+	steps := []struct {
+		name    string
+		data    []byte
+		err     error
+		wantErr bool
+		applied []string
+	}{
+		{name: "first load", data: v1, applied: []string{"jar-service"}},
+		{name: "unchanged bytes are skipped", data: v1, applied: []string{"jar-service"}},
+		{name: "changed document", data: v2, applied: []string{"jar-service", "jar-service-v2"}},
+		{name: "invalid document keeps the table", data: []byte("routes: []"), wantErr: true, applied: []string{"jar-service", "jar-service-v2"}},
+		{name: "unreadable source keeps the table", err: errors.New("gone"), wantErr: true, applied: []string{"jar-service", "jar-service-v2"}},
+		// The invalid document was never installed, so going back to the
+		// current one is a no-op too.
+		{name: "back to the installed document", data: v2, applied: []string{"jar-service", "jar-service-v2"}},
+		{name: "document applied before", data: v1, applied: []string{"jar-service", "jar-service-v2", "jar-service"}},
+	}
+
+	for _, step := range steps {
+		source.data, source.err = step.data, step.err
+		err := watcher.Load(ctx)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: Load() error = %v, want error %v", step.name, err, step.wantErr)
+		}
+		if len(applied) != len(step.applied) {
+			t.Fatalf("%s: applied %v, want %v", step.name, applied, step.applied)
+		}
+		for i := range applied {
+			if applied[i] != step.applied[i] {
+				t.Fatalf("%s: applied %v, want %v", step.name, applied, step.applied)
+			}
+		}
+	}
+}
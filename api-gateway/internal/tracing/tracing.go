@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Init installs the W3C trace context propagator and, when endpoint is set, a
+// tracer provider exporting spans over OTLP/HTTP to it. Without an endpoint no
+// spans are recorded, but trace context received on requests and events is
+// still passed on. The returned func flushes pending spans.
+func Init(ctx context.Context, service, version, endpoint string, sampleRatio float64) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, endpoint is the collector's base URL.
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	attributes := []attribute.KeyValue{semconv.ServiceName(service)}
+	if version != "" {
+		attributes = append(attributes, semconv.ServiceVersion(version))
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attributes...),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Follow the caller's sampling decision so traces are never cut in half.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// untraced are the gateway's own endpoints polled by Consul and Prometheus.
+var untraced = map[string]bool{
+	"/health":       true,
+	"/health/ready": true,
+	"/metrics":      true,
+}
+
+// Middleware starts a server span for every request except health checks and
+// metric scrapes, continuing the caller's trace. Spans are named after the
+// method until a handler renames them after the route.
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "api-gateway",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !untraced[r.URL.Path]
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
+
+// Transport starts a client span for every upstream call and passes the trace
+// context on in its headers.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// Route names the request's server span after the route that matched it and
+// records the service it is proxied to, if any.
+func Route(name, service string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + name)
+			span.SetAttributes(attribute.String("gateway.route", name))
+			if service != "" {
+				span.SetAttributes(attribute.String("gateway.service", service))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -11,14 +11,21 @@ import (
 	"time"
 
 	"github.com/0Bleak/clayjar-jar-service/internal/config"
+	"github.com/0Bleak/clayjar-jar-service/internal/cursor"
 	"github.com/0Bleak/clayjar-jar-service/internal/discovery"
 	"github.com/0Bleak/clayjar-jar-service/internal/handlers"
 	"github.com/0Bleak/clayjar-jar-service/internal/messaging"
+	"github.com/0Bleak/clayjar-jar-service/internal/openapi"
+	"github.com/0Bleak/clayjar-jar-service/internal/problem"
 	"github.com/0Bleak/clayjar-jar-service/internal/repository"
+	"github.com/0Bleak/clayjar-jar-service/internal/requestid"
 	"github.com/0Bleak/clayjar-jar-service/internal/service"
+	"github.com/0Bleak/clayjar-jar-service/internal/shadow"
+	"github.com/0Bleak/clayjar-jar-service/internal/tracing"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
 func main() {
@@ -33,11 +40,24 @@ func run() error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// Set up tracing
+	shutdownTracing, err := tracing.Init(context.Background(), "clayjar-jar-service", cfg.ServiceVersion, cfg.OTLPEndpoint, cfg.TraceSampleRatio)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
 	// Connect to MongoDB
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI))
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI).SetMonitor(otelmongo.NewMonitor()))
 	if err != nil {
 		return fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
@@ -62,10 +82,31 @@ func run() error {
 
 	// Initialize Service and Handler
 	jarService := service.NewJarService(jarRepo, kafkaProducer)
-	jarHandler := handlers.NewJarHandler(jarService)
+	jarHandler := handlers.NewJarHandler(jarService, cursor.NewSigner(cfg.CursorSecret), cfg.JWTSecret)
+
+	// Purge jars archived for longer than the retention period
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	if cfg.ArchiveRetention > 0 {
+		go purgeArchivedJars(purgeCtx, jarService, cfg.ArchiveRetention, cfg.PurgeInterval)
+		log.Printf("Purging jars archived for more than %s every %s", cfg.ArchiveRetention, cfg.PurgeInterval)
+	}
+
+	// Requests are validated against the service's OpenAPI document
+	validator, err := openapi.NewValidator(cfg.ValidateResponses)
+	if err != nil {
+		return fmt.Errorf("failed to load openapi document: %w", err)
+	}
 
 	// Setup Router
 	router := mux.NewRouter()
+	router.NotFoundHandler = requestid.Middleware(http.HandlerFunc(problem.RouteNotFound))
+	router.MethodNotAllowedHandler = requestid.Middleware(http.HandlerFunc(problem.MethodNotAllowed))
+	router.Use(tracing.Middleware("clayjar-jar-service"))
+	router.Use(requestid.Middleware)
+	router.Use(shadow.Middleware(cfg.ShadowWrites))
+	router.Use(validator.Middleware)
+	router.HandleFunc("/openapi.json", openapi.ServeSpec).Methods(http.MethodGet)
 	jarHandler.RegisterRoutes(router)
 
 	// Register with Consul
@@ -75,10 +116,10 @@ func run() error {
 	}
 
 	serviceID := fmt.Sprintf("jar-service-%s", cfg.ServiceID)
-	if err := consulClient.RegisterService(serviceID, "jar-service", cfg.ServerPort); err != nil {
+	if err := consulClient.RegisterService(serviceID, "jar-service", cfg.ServerPort, cfg.ServiceVersion); err != nil {
 		return fmt.Errorf("failed to register service with consul: %w", err)
 	}
-	log.Printf("Registered with Consul as %s", serviceID)
+	log.Printf("Registered with Consul as %s (version %s)", serviceID, cfg.ServiceVersion)
 
 	defer func() {
 		if err := consulClient.DeregisterService(serviceID); err != nil {
@@ -118,3 +159,25 @@ func run() error {
 	log.Println("Server exited gracefully")
 	return nil
 }
+
+// purgeArchivedJars deletes the jars archived for longer than retention every
+// interval until the context is cancelled.
+func purgeArchivedJars(ctx context.Context, jarService service.JarService, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := jarService.PurgeArchivedJars(ctx, time.Now().UTC().Add(-retention))
+			if err != nil {
+				log.Printf("Failed to purge archived jars: %v", err)
+			}
+			if purged > 0 {
+				log.Printf("Purged %d archived jars", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/clayjar-jar-service/internal/service"
+)
+
+type purgeCounter struct {
+	service.JarService
+	cutoffs chan time.Time
+}
+
+func (s *purgeCounter) PurgeArchivedJars(ctx context.Context, before time.Time) (int, error) {
+	s.cutoffs <- before
+	return 0, nil
+}
+
+func TestPurgeArchivedJarsLoop(t *testing.T) {
+	jars := &purgeCounter{cutoffs: make(chan time.Time, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		purgeArchivedJars(ctx, jars, 24*time.Hour, 10*time.Millisecond)
+		close(done)
+	}()
+
+	// Every tick purges the jars archived for longer than the retention.
+	for i := 0; i < 2; i++ {
+		select {
+		case cutoff := <-jars.cutoffs:
+			if age := time.Since(cutoff); age < 24*time.Hour || age > 24*time.Hour+time.Minute {
+				t.Fatalf("purged jars archived before %v, want a day ago", cutoff)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no purge after %d ticks", i)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("purge loop still running after cancel")
+	}
+}
@@ -3,19 +3,36 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Config struct {
-	ServerPort   string
-	ServiceID    string
-	MongoURI     string
-	MongoDB      string
-	KafkaBrokers []string
-	KafkaTopic   string
-	ConsulAddr   string
+	ServerPort       string
+	ServiceID        string
+	ServiceVersion   string
+	MongoURI         string
+	MongoDB          string
+	KafkaBrokers     []string
+	KafkaTopic       string
+	JWTSecret        string
+	ConsulAddr       string
+	OTLPEndpoint     string
+	TraceSampleRatio float64
+	// ValidateResponses logs responses that do not match the OpenAPI document.
+	ValidateResponses bool
+	// ShadowWrites serves mirrored writes. Only set it on shadow deployments
+	// with their own database and topics.
+	ShadowWrites bool
+	// CursorSecret signs pagination cursors. Every instance must share it.
+	CursorSecret string
+	// ArchiveRetention is how long archived jars are kept before they are
+	// purged for good; 0 keeps them forever.
+	ArchiveRetention time.Duration
+	PurgeInterval    time.Duration
 }
 
 func LoadConfig() (*Config, error) {
@@ -25,13 +42,32 @@ func LoadConfig() (*Config, error) {
 	}
 
 	cfg := &Config{
-		ServerPort:   getEnv("SERVER_PORT", "8080"),
-		ServiceID:    serviceID,
-		MongoURI:     getEnv("MONGO_URI", "mongodb://mongo:27017"),
-		MongoDB:      getEnv("MONGO_DB", "clayjar"),
-		KafkaBrokers: parseKafkaBrokers(getEnv("KAFKA_BROKERS", "shared-kafka:9092")),
-		KafkaTopic:   getEnv("KAFKA_TOPIC", "jar-events"),
-		ConsulAddr:   getEnv("CONSUL_ADDR", "consul-server:8500"),
+		ServerPort:        getEnv("SERVER_PORT", "8080"),
+		ServiceID:         serviceID,
+		ServiceVersion:    getEnv("SERVICE_VERSION", "dev"),
+		MongoURI:          getEnv("MONGO_URI", "mongodb://mongo:27017"),
+		MongoDB:           getEnv("MONGO_DB", "clayjar"),
+		KafkaBrokers:      parseKafkaBrokers(getEnv("KAFKA_BROKERS", "shared-kafka:9092")),
+		KafkaTopic:        getEnv("KAFKA_TOPIC", "jar-events"),
+		JWTSecret:         getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+		ConsulAddr:        getEnv("CONSUL_ADDR", "consul-server:8500"),
+		OTLPEndpoint:      getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		ValidateResponses: getEnv("OPENAPI_VALIDATE_RESPONSES", "false") == "true",
+		ShadowWrites:      getEnv("SHADOW_WRITES", "false") == "true",
+		CursorSecret:      getEnv("CURSOR_SECRET", "cursor-secret-change-in-production"),
+	}
+
+	traceSampleRatio, err := getFloat("TRACE_SAMPLE_RATIO", 1)
+	if err != nil {
+		return nil, err
+	}
+	cfg.TraceSampleRatio = traceSampleRatio
+
+	if cfg.ArchiveRetention, err = getDuration("ARCHIVE_RETENTION", 0); err != nil {
+		return nil, err
+	}
+	if cfg.PurgeInterval, err = getDuration("PURGE_INTERVAL", time.Hour); err != nil {
+		return nil, err
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -57,6 +93,21 @@ func (c *Config) Validate() error {
 	if c.KafkaTopic == "" {
 		return fmt.Errorf("KAFKA_TOPIC is required")
 	}
+	if c.JWTSecret == "" {
+		return fmt.Errorf("JWT_SECRET is required")
+	}
+	if c.CursorSecret == "" {
+		return fmt.Errorf("CURSOR_SECRET is required")
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return fmt.Errorf("TRACE_SAMPLE_RATIO must be between 0 and 1")
+	}
+	if c.ArchiveRetention < 0 {
+		return fmt.Errorf("ARCHIVE_RETENTION cannot be negative")
+	}
+	if c.ArchiveRetention > 0 && c.PurgeInterval <= 0 {
+		return fmt.Errorf("PURGE_INTERVAL must be positive")
+	}
 	return nil
 }
 
@@ -67,6 +118,32 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getFloat(key string, defaultValue float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number: %w", key, err)
+	}
+	return f, nil
+}
+
+func getDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration: %w", key, err)
+	}
+	return d, nil
+}
+
 func parseKafkaBrokers(brokers string) []string {
 	return strings.Split(brokers, ",")
 }
@@ -3,42 +3,58 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
+	"github.com/0Bleak/clayjar-jar-service/internal/cursor"
+	"github.com/0Bleak/clayjar-jar-service/internal/middleware"
 	"github.com/0Bleak/clayjar-jar-service/internal/models"
+	"github.com/0Bleak/clayjar-jar-service/internal/problem"
 	"github.com/0Bleak/clayjar-jar-service/internal/service"
 	"github.com/gorilla/mux"
 )
 
+// totalCountHeader counts every jar matching a listing's filter, across pages.
+const totalCountHeader = "X-Total-Count"
+
 type JarHandler struct {
-	service service.JarService
+	service   service.JarService
+	cursors   *cursor.Signer
+	jwtSecret string
 }
 
-func NewJarHandler(service service.JarService) *JarHandler {
+func NewJarHandler(service service.JarService, cursors *cursor.Signer, jwtSecret string) *JarHandler {
 	return &JarHandler{
-		service: service,
+		service:   service,
+		cursors:   cursors,
+		jwtSecret: jwtSecret,
 	}
 }
 
 func (h *JarHandler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/jars", h.CreateJar).Methods(http.MethodPost)
 	router.HandleFunc("/jars", h.GetAllJars).Methods(http.MethodGet)
+	router.HandleFunc("/jars/facets", h.GetJarFacets).Methods(http.MethodGet)
 	router.HandleFunc("/jars/{id}", h.GetJarByID).Methods(http.MethodGet)
 	router.HandleFunc("/jars/{id}", h.UpdateJar).Methods(http.MethodPut)
+	router.HandleFunc("/jars/{id}", h.PatchJar).Methods(http.MethodPatch)
 	router.HandleFunc("/jars/{id}", h.DeleteJar).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/jars/archived", middleware.AdminMiddleware(h.GetArchivedJars, h.jwtSecret)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/jars/{id}/restore", middleware.AdminMiddleware(h.RestoreJar, h.jwtSecret)).Methods(http.MethodPost)
 	router.HandleFunc("/health", h.HealthCheck).Methods(http.MethodGet)
 }
 
 func (h *JarHandler) CreateJar(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateJarRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
 		return
 	}
 
 	jar, err := h.service.CreateJar(r.Context(), &req)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -51,78 +67,243 @@ func (h *JarHandler) GetJarByID(w http.ResponseWriter, r *http.Request) {
 
 	jar, err := h.service.GetJarByID(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Jar not found")
+		problem.Write(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", jar.ETag())
 	respondWithJSON(w, http.StatusOK, jar)
 }
 
+// GetAllJars lists the catalogue, filtered and sorted by the query string.
+// Pages are addressed by offset or, for listings sorted by creation time, by
+// the signed cursors sent in the Link header. The body stays a plain array;
+// the number of matching jars is sent in X-Total-Count.
 func (h *JarHandler) GetAllJars(w http.ResponseWriter, r *http.Request) {
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
+	filter, err := h.parseListing(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
 
-	limit := int64(10)
-	offset := int64(0)
+	h.writePage(w, r, filter)
+}
 
-	if limitStr != "" {
-		if l, err := strconv.ParseInt(limitStr, 10, 64); err == nil {
-			limit = l
+// parseListing reads the filter and the cursor of a listing request.
+func (h *JarHandler) parseListing(r *http.Request) (*models.JarFilter, error) {
+	filter, err := parseJarFilter(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		if filter.Cursor, err = h.cursors.Decode(token); err != nil {
+			return nil, err
 		}
 	}
+	return filter, nil
+}
 
-	if offsetStr != "" {
-		if o, err := strconv.ParseInt(offsetStr, 10, 64); err == nil {
-			offset = o
-		}
+func (h *JarHandler) writePage(w http.ResponseWriter, r *http.Request, filter *models.JarFilter) {
+	page, err := h.service.GetAllJars(r.Context(), filter)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	var next, prev string
+	if page.Next != nil {
+		next = h.cursors.Encode(page.Next)
+	}
+	if page.Prev != nil {
+		prev = h.cursors.Encode(page.Prev)
+	}
+	cursor.SetLink(w, r, next, prev)
+	w.Header().Set(totalCountHeader, strconv.FormatInt(page.Total, 10))
+	respondWithJSON(w, http.StatusOK, page.Jars)
+}
+
+// GetJarFacets counts the jars matching the same query string as GetAllJars
+// by category, clay type, glaze type and price range.
+func (h *JarHandler) GetJarFacets(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseJarFilter(r.URL.Query())
+	if err != nil {
+		problem.Write(w, r, err)
+		return
 	}
+	filter.Sort = "" // counts are not sorted
 
-	jars, err := h.service.GetAllJars(r.Context(), limit, offset)
+	facets, err := h.service.GetJarFacets(r.Context(), filter)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, jars)
+	respondWithJSON(w, http.StatusOK, facets)
 }
 
+// UpdateJar replaces the jar. It requires If-Match, and fails with 412 unless
+// the jar is still at the version the client read.
 func (h *JarHandler) UpdateJar(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
 	var req models.CreateJarRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
 		return
 	}
 
-	jar, err := h.service.UpdateJar(r.Context(), id, &req)
+	jar, err := h.service.UpdateJar(r.Context(), id, &req, r.Header.Get("If-Match"))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", jar.ETag())
 	respondWithJSON(w, http.StatusOK, jar)
 }
 
+// PatchJar updates the fields present in a JSON Merge Patch document, under
+// the same If-Match rules as UpdateJar.
+func (h *JarHandler) PatchJar(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var patch map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		problem.Write(w, r, problem.Validation("Request body must be a JSON object"))
+		return
+	}
+
+	jar, err := h.service.PatchJar(r.Context(), id, patch, r.Header.Get("If-Match"))
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", jar.ETag())
+	respondWithJSON(w, http.StatusOK, jar)
+}
+
+// DeleteJar archives the jar: it leaves the catalogue but can still be fetched
+// by ID and restored.
 func (h *JarHandler) DeleteJar(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.service.DeleteJar(r.Context(), id); err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	if _, err := h.service.ArchiveJar(r.Context(), id, r.Header.Get("If-Match")); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Jar archived successfully"})
+}
+
+// GetArchivedJars lists archived jars, with the filters and paging of
+// GetAllJars.
+func (h *JarHandler) GetArchivedJars(w http.ResponseWriter, r *http.Request) {
+	filter, err := h.parseListing(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	filter.Archived = true
+
+	h.writePage(w, r, filter)
+}
+
+func (h *JarHandler) RestoreJar(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	jar, err := h.service.RestoreJar(r.Context(), id, r.Header.Get("If-Match"))
+	if err != nil {
+		problem.Write(w, r, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Jar deleted successfully"})
+	w.Header().Set("ETag", jar.ETag())
+	respondWithJSON(w, http.StatusOK, jar)
 }
 
 func (h *JarHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
+func parseJarFilter(query url.Values) (*models.JarFilter, error) {
+	filter := &models.JarFilter{
+		Query:          strings.TrimSpace(query.Get("q")),
+		Category:       query.Get("category"),
+		ClayType:       query.Get("clay_type"),
+		GlazeType:      query.Get("glaze_type"),
+		ProductionType: query.Get("production_type"),
+		Sort:           query.Get("sort"),
+		Limit:          10,
+	}
+
+	var err error
+	if filter.Limit, err = parseInt(query, "limit", filter.Limit); err != nil {
+		return nil, err
+	}
+	if filter.Offset, err = parseInt(query, "offset", 0); err != nil {
+		return nil, err
+	}
+	if filter.MinPrice, err = parseFloat(query, "min_price"); err != nil {
+		return nil, err
+	}
+	if filter.MaxPrice, err = parseFloat(query, "max_price"); err != nil {
+		return nil, err
+	}
+	if filter.FoodSafe, err = parseBool(query, "food_safe"); err != nil {
+		return nil, err
+	}
+	if filter.MicrowaveSafe, err = parseBool(query, "microwave_safe"); err != nil {
+		return nil, err
+	}
+	if filter.DishwasherSafe, err = parseBool(query, "dishwasher_safe"); err != nil {
+		return nil, err
+	}
+	if filter.InStock, err = parseBool(query, "in_stock"); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func parseInt(query url.Values, name string, fallback int64) (int64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, problem.Invalid("query."+name, "Must be an integer")
+	}
+	return n, nil
+}
+
+func parseFloat(query url.Values, name string) (*float64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, problem.Invalid("query."+name, "Must be a number")
+	}
+	return &f, nil
+}
+
+func parseBool(query url.Values, name string) (*bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, problem.Invalid("query."+name, "Must be true or false")
+	}
+	return &b, nil
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/clayjar-jar-service/internal/cursor"
+	"github.com/0Bleak/clayjar-jar-service/internal/models"
+	"github.com/0Bleak/clayjar-jar-service/internal/problem"
+	"github.com/0Bleak/clayjar-jar-service/internal/repository"
+	"github.com/0Bleak/clayjar-jar-service/internal/service"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryRepository keeps a single jar and applies writes only at the version
+// they were read at, like the Mongo repository.
+type memoryRepository struct {
+	repository.JarRepository
+	jar *models.Jar
+	// beforeWrite runs between the read and the write of a request, to
+	// simulate a concurrent writer.
+	beforeWrite func(jar *models.Jar)
+}
+
+func (r *memoryRepository) FindByID(ctx context.Context, id string) (*models.Jar, error) {
+	if id != r.jar.ID.Hex() {
+		return nil, repository.ErrJarNotFound
+	}
+	jar := *r.jar
+	return &jar, nil
+}
+
+func (r *memoryRepository) FindAll(ctx context.Context, filter *models.JarFilter) ([]*models.Jar, error) {
+	if filter.Archived != r.jar.IsArchived() {
+		return nil, nil
+	}
+	jar := *r.jar
+	return []*models.Jar{&jar}, nil
+}
+
+func (r *memoryRepository) Count(ctx context.Context, filter *models.JarFilter) (int64, error) {
+	jars, err := r.FindAll(ctx, filter)
+	return int64(len(jars)), err
+}
+
+func (r *memoryRepository) Update(ctx context.Context, id string, jar *models.Jar) error {
+	return r.write(jar, func() {})
+}
+
+func (r *memoryRepository) Archive(ctx context.Context, jar *models.Jar) error {
+	return r.write(jar, func() {
+		now := time.Now().UTC()
+		jar.ArchivedAt = &now
+	})
+}
+
+func (r *memoryRepository) Restore(ctx context.Context, jar *models.Jar) error {
+	return r.write(jar, func() { jar.ArchivedAt = nil })
+}
+
+func (r *memoryRepository) write(jar *models.Jar, apply func()) error {
+	if r.beforeWrite != nil {
+		r.beforeWrite(r.jar)
+	}
+	if jar.Version != r.jar.Version {
+		return repository.ErrJarModified
+	}
+	apply()
+	jar.Version++
+	stored := *jar
+	r.jar = &stored
+	return nil
+}
+
+type recordingProducer struct {
+	events []string
+}
+
+func (p *recordingProducer) PublishJarEvent(ctx context.Context, event *models.JarEvent) error {
+	p.events = append(p.events, event.Type)
+	return nil
+}
+
+func (p *recordingProducer) Close() error {
+	return nil
+}
+
+// storedJar is at version 2, so its ETag is "2".
+func storedJar() *models.Jar {
+	return &models.Jar{
+		ID:          primitive.NewObjectID(),
+		Name:        "Honey jar",
+		Description: "Celadon honey jar with lid",
+		Category:    "honey",
+		Price:       24.5,
+		StockQty:    3,
+		Attributes: models.JarAttributes{
+			ClayType:   "stoneware",
+			Dimensions: "10x8",
+			GlazeType:  "celadon",
+			FoodSafe:   true,
+		},
+		Version: 2,
+	}
+}
+
+type testServer struct {
+	router   *mux.Router
+	repo     *memoryRepository
+	producer *recordingProducer
+	path     string
+}
+
+func newTestServer() *testServer {
+	repo := &memoryRepository{jar: storedJar()}
+	producer := &recordingProducer{}
+	router := mux.NewRouter()
+	NewJarHandler(service.NewJarService(repo, producer), cursor.NewSigner("secret"), jwtSecret).RegisterRoutes(router)
+	return &testServer{router: router, repo: repo, producer: producer, path: "/jars/" + repo.jar.ID.Hex()}
+}
+
+func (s *testServer) do(method, ifMatch, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, s.path, strings.NewReader(body))
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, r)
+	return rec
+}
+
+const jwtSecret = "jwt-secret"
+
+// token signs claims the way user-service does.
+func token(t *testing.T, secret, role string, expiresIn time.Duration) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 1,
+		"role":    role,
+		"exp":     time.Now().Add(expiresIn).Unix(),
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+const putBody = `{"name": "Honey jar", "description": "Refired", "category": "honey", "price": 26, "stock_qty": 3}`
+
+func TestConditionalWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		ifMatch string
+		body    string
+		status  int
+		kind    problem.Kind
+		etag    string
+	}{
+		{"PUT without If-Match", http.MethodPut, "", putBody, http.StatusPreconditionRequired, problem.KindPreconditionRequired, ""},
+		{"PATCH without If-Match", http.MethodPatch, "", `{"price": 26}`, http.StatusPreconditionRequired, problem.KindPreconditionRequired, ""},
+		{"DELETE without If-Match", http.MethodDelete, "", "", http.StatusPreconditionRequired, problem.KindPreconditionRequired, ""},
+		{"PUT with a stale ETag", http.MethodPut, `"1"`, putBody, http.StatusPreconditionFailed, problem.KindPreconditionFailed, ""},
+		{"PATCH with a stale ETag", http.MethodPatch, `"1"`, `{"price": 26}`, http.StatusPreconditionFailed, problem.KindPreconditionFailed, ""},
+		{"DELETE with a stale ETag", http.MethodDelete, `"1"`, "", http.StatusPreconditionFailed, problem.KindPreconditionFailed, ""},
+		{"weak ETags never match", http.MethodPatch, `W/"2"`, `{"price": 26}`, http.StatusPreconditionFailed, problem.KindPreconditionFailed, ""},
+		{"PUT with the current ETag", http.MethodPut, `"2"`, putBody, http.StatusOK, "", `"3"`},
+		{"PATCH with a list holding the current ETag", http.MethodPatch, `"1", "2"`, `{"price": 26}`, http.StatusOK, "", `"3"`},
+		{"PUT with *", http.MethodPut, "*", putBody, http.StatusOK, "", `"3"`},
+		{"PATCH with *", http.MethodPatch, "*", `{"price": 26}`, http.StatusOK, "", `"3"`},
+		{"DELETE with *", http.MethodDelete, "*", "", http.StatusOK, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			rec := s.do(tt.method, tt.ifMatch, tt.body)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if got := rec.Header().Get("ETag"); got != tt.etag {
+				t.Errorf("ETag = %q, want %q", got, tt.etag)
+			}
+
+			if tt.kind == "" {
+				if len(s.producer.events) != 1 {
+					t.Errorf("published %v, want one event", s.producer.events)
+				}
+				return
+			}
+			assertProblem(t, rec, tt.kind)
+			if s.repo.jar.Version != 2 || len(s.producer.events) != 0 {
+				t.Errorf("rejected write changed the jar: version %d, events %v", s.repo.jar.Version, s.producer.events)
+			}
+		})
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+		kind    problem.Kind
+	}{
+		// The client named the version it read, which is now stale.
+		{"with the ETag read", `"2"`, http.StatusPreconditionFailed, problem.KindPreconditionFailed},
+		// The client accepted any version, so it may simply try again.
+		{"with *", "*", http.StatusConflict, problem.KindConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			s.repo.beforeWrite = func(jar *models.Jar) { jar.Version++ }
+
+			rec := s.do(http.MethodPatch, tt.ifMatch, `{"price": 26}`)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			assertProblem(t, rec, tt.kind)
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	s := newTestServer()
+
+	rec := s.do(http.MethodPatch, `"2"`, `{
+		"description": null,
+		"price": 30,
+		"attributes": {"glaze_type": null, "clay_type": "porcelain"}
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var jar models.Jar
+	if err := json.Unmarshal(rec.Body.Bytes(), &jar); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	for _, check := range []struct {
+		field     string
+		got, want any
+	}{
+		{"description", jar.Description, ""},
+		{"price", jar.Price, 30.0},
+		{"attributes.glaze_type", jar.Attributes.GlazeType, ""},
+		{"attributes.clay_type", jar.Attributes.ClayType, "porcelain"},
+		// Members absent from the patch are kept.
+		{"name", jar.Name, "Honey jar"},
+		{"attributes.dimensions", jar.Attributes.Dimensions, "10x8"},
+		{"attributes.food_safe", jar.Attributes.FoodSafe, true},
+		{"version", jar.Version, int64(3)},
+	} {
+		if check.got != check.want {
+			t.Errorf("%s = %v, want %v", check.field, check.got, check.want)
+		}
+	}
+	if s.repo.jar.Description != "" || s.repo.jar.Attributes.GlazeType != "" {
+		t.Errorf("stored jar kept deleted members: %+v", s.repo.jar)
+	}
+}
+
+func TestMergePatchRejectsInvalidMembers(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		field string
+	}{
+		{"read-only member", `{"version": 7}`, "body.version"},
+		{"wrong type", `{"price": "cheap"}`, "body.price"},
+		{"wrong nested type", `{"attributes": {"food_safe": "yes"}}`, "body.attributes.food_safe"},
+		{"required member deleted", `{"name": null}`, "body.name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			rec := s.do(http.MethodPatch, `"2"`, tt.patch)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+			}
+			doc := assertProblem(t, rec, problem.KindValidation)
+			if len(doc.Errors) != 1 || doc.Errors[0].Field != tt.field {
+				t.Errorf("errors = %+v, want one for %s", doc.Errors, tt.field)
+			}
+			if s.repo.jar.Version != 2 {
+				t.Errorf("rejected patch changed the jar to version %d", s.repo.jar.Version)
+			}
+		})
+	}
+}
+
+func TestAdminRoutesCheckTheToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+		kind   problem.Kind
+	}{
+		{"role header without a token", http.Header{"X-User-Role": {"admin"}}, http.StatusUnauthorized, problem.KindUnauthorized},
+		{
+			"role header with a customer token",
+			http.Header{"X-User-Role": {"admin"}, "Authorization": {"Bearer " + token(t, jwtSecret, "customer", time.Hour)}},
+			http.StatusForbidden, problem.KindForbidden,
+		},
+		{
+			"admin token signed with another secret",
+			http.Header{"Authorization": {"Bearer " + token(t, "other-secret", "admin", time.Hour)}},
+			http.StatusUnauthorized, problem.KindUnauthorized,
+		},
+		{
+			"expired admin token",
+			http.Header{"Authorization": {"Bearer " + token(t, jwtSecret, "admin", -time.Minute)}},
+			http.StatusUnauthorized, problem.KindUnauthorized,
+		},
+		{
+			"admin token",
+			http.Header{"Authorization": {"Bearer " + token(t, jwtSecret, "admin", time.Hour)}},
+			http.StatusOK, "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, route := range []struct{ method, path string }{
+				{http.MethodGet, "/admin/jars/archived"},
+				{http.MethodPost, "/admin/jars/{id}/restore"},
+			} {
+				s := newTestServer()
+				archivedAt := time.Now().UTC()
+				s.repo.jar.ArchivedAt = &archivedAt
+
+				r := httptest.NewRequest(route.method, strings.Replace(route.path, "{id}", s.repo.jar.ID.Hex(), 1), nil)
+				r.Header = tt.header.Clone()
+				rec := httptest.NewRecorder()
+				s.router.ServeHTTP(rec, r)
+
+				if rec.Code != tt.status {
+					t.Fatalf("%s %s: status = %d, want %d: %s", route.method, route.path, rec.Code, tt.status, rec.Body)
+				}
+				if tt.kind != "" {
+					assertProblem(t, rec, tt.kind)
+					if !s.repo.jar.IsArchived() || len(s.producer.events) != 0 {
+						t.Errorf("%s %s: rejected request restored the jar", route.method, route.path)
+					}
+				}
+			}
+		})
+	}
+}
+
+func assertProblem(t *testing.T, rec *httptest.ResponseRecorder, kind problem.Kind) problem.Problem {
+	t.Helper()
+	if got := rec.Header().Get("Content-Type"); got != problem.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, problem.ContentType)
+	}
+	var doc problem.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid problem document: %v", err)
+	}
+	if doc.Type != problem.TypeURI(kind) {
+		t.Errorf("type = %q, want %q", doc.Type, problem.TypeURI(kind))
+	}
+	return doc
+}
@@ -0,0 +1,30 @@
+package messaging
+
+import (
+	"context"
+
+	"github.com/0Bleak/clayjar-jar-service/internal/requestid"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+)
+
+// eventHeaders returns the headers of an event published while handling ctx:
+// its type, request, event and causation IDs, and the trace context. Events
+// published outside a request start a new chain.
+func eventHeaders(ctx context.Context, eventType string) []kafka.Header {
+	requestID := requestid.FromContext(ctx)
+	if requestID == "" {
+		requestID = requestid.New()
+	}
+
+	headers := []kafka.Header{
+		{Key: "event-type", Value: []byte(eventType)},
+		{Key: requestid.MessageHeader, Value: []byte(requestID)},
+		{Key: requestid.EventIDHeader, Value: []byte(requestid.New())},
+	}
+	if causationID := requestid.CausationFromContext(ctx); causationID != "" {
+		headers = append(headers, kafka.Header{Key: requestid.CausationHeader, Value: []byte(causationID)})
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{headers: &headers})
+	return headers
+}
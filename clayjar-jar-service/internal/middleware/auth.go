@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/0Bleak/clayjar-jar-service/internal/problem"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthMiddleware verifies the Bearer token issued by user-service, which the
+// gateway forwards unchanged. Identity headers are not trusted, since the
+// service can be reached without going through the gateway.
+func AuthMiddleware(next http.HandlerFunc, jwtSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			problem.Write(w, r, problem.Unauthorized("Missing authorization header"))
+			return
+		}
+
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			problem.Write(w, r, problem.Unauthorized("Invalid authorization header"))
+			return
+		}
+
+		tokenString := parts[1]
+		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(jwtSecret), nil
+		})
+
+		if err != nil || !token.Valid {
+			problem.Write(w, r, problem.Unauthorized("Invalid token"))
+			return
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			problem.Write(w, r, problem.Unauthorized("Invalid token claims"))
+			return
+		}
+
+		exp, ok := claims["exp"].(float64)
+		if !ok || time.Unix(int64(exp), 0).Before(time.Now()) {
+			problem.Write(w, r, problem.Unauthorized("Token expired"))
+			return
+		}
+
+		userID, ok := claims["user_id"].(float64)
+		if !ok {
+			problem.Write(w, r, problem.Unauthorized("Invalid user ID in token"))
+			return
+		}
+
+		role, _ := claims["role"].(string)
+
+		ctx := context.WithValue(r.Context(), "userID", int64(userID))
+		ctx = context.WithValue(ctx, "userRole", role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// AdminMiddleware only lets through users with the admin role.
+func AdminMiddleware(next http.HandlerFunc, jwtSecret string) http.HandlerFunc {
+	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if role, _ := r.Context().Value("userRole").(string); role != "admin" {
+			problem.Write(w, r, problem.Forbidden("Admin role required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}, jwtSecret)
+}
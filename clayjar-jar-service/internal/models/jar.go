@@ -1,9 +1,12 @@
 package models
 
 import (
-	"errors"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/0Bleak/clayjar-jar-service/internal/cursor"
+	"github.com/0Bleak/clayjar-jar-service/internal/problem"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -22,6 +25,13 @@ type Jar struct {
 	Attributes  JarAttributes      `bson:"attributes" json:"attributes"`
 	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	// Version counts the writes to the jar. Jars stored before versioning
+	// are at version 0.
+	Version int64 `bson:"version" json:"version"`
+	// ArchivedAt is set while the jar is withdrawn from the catalogue. Archived
+	// jars are left out of listings but can still be fetched by ID, for the
+	// orders that refer to them.
+	ArchivedAt *time.Time `bson:"archived_at,omitempty" json:"archived_at,omitempty"`
 }
 
 type JarAttributes struct {
@@ -50,24 +60,190 @@ type CreateJarRequest struct { // This is a data transfer object & is used to ob
 	Attributes  JarAttributes `json:"attributes"`
 }
 
+// Apply copies the editable fields of the request onto the jar.
+func (j *Jar) Apply(req *CreateJarRequest) {
+	j.Name = req.Name
+	j.Description = req.Description
+	j.Category = req.Category
+	j.Price = req.Price
+	j.StockQty = req.StockQty
+	j.ImageUrl = req.ImageURL
+	j.Attributes = req.Attributes
+}
+
+// Request returns the editable fields of the jar, the document merge patches
+// apply to.
+func (j *Jar) Request() *CreateJarRequest {
+	return &CreateJarRequest{
+		Name:        j.Name,
+		Description: j.Description,
+		Category:    j.Category,
+		Price:       j.Price,
+		StockQty:    j.StockQty,
+		ImageURL:    j.ImageUrl,
+		Attributes:  j.Attributes,
+	}
+}
+
+/*
+Conditional requests
+*/
+
+// ETag is the entity tag of the jar's current version.
+func (j *Jar) ETag() string {
+	return strconv.Quote(strconv.FormatInt(j.Version, 10))
+}
+
+// MatchesETag reports whether an If-Match header holds for the jar: it is
+// empty, "*", or lists the jar's entity tag. Weak tags never match.
+func (j *Jar) MatchesETag(ifMatch string) bool {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(tag) == j.ETag() {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Catalog search
+*/
+
+// Sort orders accepted by JarFilter. A leading "-" sorts in descending order.
+const (
+	SortNewest    = "-created_at"
+	SortOldest    = "created_at"
+	SortPriceAsc  = "price"
+	SortPriceDesc = "-price"
+	SortNameAsc   = "name"
+	SortNameDesc  = "-name"
+	SortRelevance = "relevance" // only with a text query
+)
+
+var SortOrders = []string{SortNewest, SortOldest, SortPriceAsc, SortPriceDesc, SortNameAsc, SortNameDesc, SortRelevance}
+
+// JarFilter selects jars from the catalogue. Nil and empty fields do not
+// filter.
+type JarFilter struct {
+	Query          string // free text over name and description
+	Category       string
+	MinPrice       *float64
+	MaxPrice       *float64
+	ClayType       string
+	GlazeType      string
+	ProductionType string
+	FoodSafe       *bool
+	MicrowaveSafe  *bool
+	DishwasherSafe *bool
+	InStock        *bool
+	Sort           string
+	Limit          int64
+	Offset         int64
+	// Cursor pages by position instead of Offset. It requires a sort by
+	// creation time.
+	Cursor *cursor.Cursor
+	// Archived lists archived jars instead of the catalogue.
+	Archived bool
+}
+
+// SortOrder returns the sort to apply: Sort, or by default newest first, or
+// by relevance for text queries.
+func (f *JarFilter) SortOrder() string {
+	switch {
+	case f.Sort != "":
+		return f.Sort
+	case f.Query != "":
+		return SortRelevance
+	default:
+		return SortNewest
+	}
+}
+
+// JarPage is one page of a catalogue listing. Total counts every jar that
+// matches the filter. Listings sorted by creation time also locate the
+// adjacent pages, if any, in Next and Prev.
+type JarPage struct {
+	Jars  []*Jar
+	Total int64
+	Next  *cursor.Cursor
+	Prev  *cursor.Cursor
+}
+
+// PriceBuckets are the lower bounds of the price ranges counted by the
+// facets endpoint; the last range is open-ended.
+var PriceBuckets = []float64{0, 25, 50, 100, 250}
+
+// JarFacets counts the jars matching a filter by attribute value, for
+// catalogue navigation. Each facet ignores the filter on its own attribute,
+// so the other values stay selectable.
+type JarFacets struct {
+	Total       int64         `json:"total"`
+	Categories  []FacetCount  `json:"categories"`
+	ClayTypes   []FacetCount  `json:"clay_types"`
+	GlazeTypes  []FacetCount  `json:"glaze_types"`
+	PriceRanges []PriceBucket `json:"price_ranges"`
+}
+
+type FacetCount struct {
+	Value string `bson:"_id" json:"value"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// PriceBucket counts the jars priced from Min up to, but excluding, Max. Max
+// is nil for the last bucket. Every bucket is listed, empty ones included.
+type PriceBucket struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int64    `json:"count"`
+}
+
 /*
 Validation
 */
 
+func (f *JarFilter) Validate() error {
+	validSort := f.Sort == ""
+	for _, sort := range SortOrders {
+		validSort = validSort || f.Sort == sort
+	}
+
+	switch {
+	case f.MinPrice != nil && *f.MinPrice < 0:
+		return problem.Invalid("query.min_price", "Minimum price cannot be negative")
+	case f.MaxPrice != nil && *f.MaxPrice < 0:
+		return problem.Invalid("query.max_price", "Maximum price cannot be negative")
+	case f.MinPrice != nil && f.MaxPrice != nil && *f.MinPrice > *f.MaxPrice:
+		return problem.Invalid("query.min_price", "Minimum price must not exceed maximum price")
+	case !validSort:
+		return problem.Invalid("query.sort", "Unknown sort order")
+	case f.Sort == SortRelevance && f.Query == "":
+		return problem.Invalid("query.sort", "Sorting by relevance requires a text query")
+	case f.Cursor != nil && f.Offset > 0:
+		return problem.Invalid("query.cursor", "A cursor cannot be combined with an offset")
+	case f.Cursor != nil && f.SortOrder() != SortNewest && f.SortOrder() != SortOldest:
+		return problem.Invalid("query.cursor", "Cursors only page listings sorted by creation time")
+	}
+	return nil
+}
+
 func (j *Jar) Validate() error { //Error validation checks if the attributes of the Jar are correct and logical
 	switch {
 	case j.Name == "":
-		return errors.New("Name attribute is mandatory")
+		return problem.Invalid("body.name", "Name attribute is mandatory")
 	case len(j.Name) > 200:
-		return errors.New("Name attribute must be less than 200 characters")
+		return problem.Invalid("body.name", "Name attribute must be less than 200 characters")
 	case j.Price < 0.01:
-		return errors.New("Price must be at least 0.01")
+		return problem.Invalid("body.price", "Price must be at least 0.01")
 	case j.Price > 10000:
-		return errors.New("Price must not exceed 10000")
+		return problem.Invalid("body.price", "Price must not exceed 10000")
 	case j.StockQty < 0:
-		return errors.New("Stock quantity cannot be negative")
+		return problem.Invalid("body.stock_qty", "Stock quantity cannot be negative")
 	case j.StockQty > 100000:
-		return errors.New("Stock quantity exceeds allowed maximum")
+		return problem.Invalid("body.stock_qty", "Stock quantity exceeds allowed maximum")
 	}
 	return nil
 }
@@ -83,12 +259,17 @@ func (j *Jar) PrepareForCreate() { //Assigns a new unique ID to a newly created
 	now := time.Now().UTC()
 	j.CreatedAt = now
 	j.UpdatedAt = now
+	j.Version = 1
 }
 
 func (j *Jar) PrepareForUpdate() { //Used to update updated_at when updating a jar
 	j.UpdatedAt = time.Now().UTC()
 }
 
+func (j *Jar) IsArchived() bool {
+	return j.ArchivedAt != nil
+}
+
 /*
 Domain Events : will be used by kafka for the event driven design
 */
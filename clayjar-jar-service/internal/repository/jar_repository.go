@@ -2,21 +2,50 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 
+	"github.com/0Bleak/clayjar-jar-service/internal/cursor"
 	"github.com/0Bleak/clayjar-jar-service/internal/models"
+	"github.com/0Bleak/clayjar-jar-service/internal/problem"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	ErrJarNotFound  = problem.NotFound("Jar not found")
+	ErrInvalidJarID = problem.Invalid("path.id", "Jar ID must be a 24-character hex string")
+	// ErrJarModified is returned when the jar is no longer at the version the
+	// write expected.
+	ErrJarModified    = problem.PreconditionFailed("Jar has been modified since it was read")
+	ErrJarArchived    = problem.Conflict("Jar is archived, restore it first")
+	ErrJarNotArchived = problem.Conflict("Jar is not archived")
+)
+
+// unavailable reports the errors meaning MongoDB could not be reached, or did
+// not answer in time, as an unavailable dependency. Other errors, including
+// nil, are returned as they are.
+func unavailable(err error) error {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return problem.Unavailable("Database unavailable", err)
+	}
+	return err
+}
+
 type JarRepository interface {
 	Create(ctx context.Context, jar *models.Jar) error
 	FindByID(ctx context.Context, id string) (*models.Jar, error)
-	FindAll(ctx context.Context, limit, offset int64) ([]*models.Jar, error)
+	FindAll(ctx context.Context, filter *models.JarFilter) ([]*models.Jar, error)
+	Count(ctx context.Context, filter *models.JarFilter) (int64, error)
+	Facets(ctx context.Context, filter *models.JarFilter) (*models.JarFacets, error)
 	Update(ctx context.Context, id string, jar *models.Jar) error
-	Delete(ctx context.Context, id string) error
+	Archive(ctx context.Context, jar *models.Jar) error
+	Restore(ctx context.Context, jar *models.Jar) error
+	Delete(ctx context.Context, id string, version int64) error
+	FindArchivedBefore(ctx context.Context, before time.Time, limit int64) ([]*models.Jar, error)
 	EnsureIndexes(ctx context.Context) error
 }
 
@@ -44,10 +73,23 @@ func (r *jarRepository) EnsureIndexes(ctx context.Context) error {
 		{
 			Keys: bson.D{{Key: "attributes.clay_type", Value: 1}},
 		},
+		{
+			Keys: bson.D{{Key: "archived_at", Value: 1}},
+		},
+		{
+			// A collection has at most one text index; it backs the q filter.
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "description", Value: "text"},
+			},
+			Options: options.Index().
+				SetName("jar_text").
+				SetWeights(bson.D{{Key: "name", Value: 10}, {Key: "description", Value: 1}}),
+		},
 	}
 
 	_, err := r.collection.Indexes().CreateMany(ctx, indexes)
-	return err
+	return unavailable(err)
 }
 
 func (r *jarRepository) Create(ctx context.Context, jar *models.Jar) error {
@@ -57,7 +99,7 @@ func (r *jarRepository) Create(ctx context.Context, jar *models.Jar) error {
 	jar.PrepareForCreate()
 
 	_, err := r.collection.InsertOne(ctx, jar)
-	return err
+	return unavailable(err)
 }
 
 func (r *jarRepository) FindByID(ctx context.Context, id string) (*models.Jar, error) {
@@ -66,45 +108,295 @@ func (r *jarRepository) FindByID(ctx context.Context, id string) (*models.Jar, e
 
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, err
+		return nil, ErrInvalidJarID
 	}
 
 	var jar models.Jar
 	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&jar)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrJarNotFound
+	}
 	if err != nil {
-		return nil, err
+		return nil, unavailable(err)
 	}
 
 	return &jar, nil
 }
 
-func (r *jarRepository) FindAll(ctx context.Context, limit, offset int64) ([]*models.Jar, error) {
+func (r *jarRepository) FindAll(ctx context.Context, filter *models.JarFilter) ([]*models.Jar, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	opts := options.Find().SetLimit(limit).SetSkip(offset).SetSort(bson.D{{Key: "created_at", Value: -1}})
+	query, sort := filterDocument(filter), sortDocument(filter)
+	backward := filter.Cursor != nil && filter.Cursor.Before
+	if filter.Cursor != nil {
+		seek, seekSort, err := seekDocument(filter.Cursor, filter.SortOrder())
+		if err != nil {
+			return nil, err
+		}
+		query["$or"], sort = seek, seekSort
+	}
+
+	opts := options.Find().SetLimit(filter.Limit).SetSkip(filter.Offset).SetSort(sort)
 
-	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	cur, err := r.collection.Find(ctx, query, opts)
 	if err != nil {
-		return nil, err
+		return nil, unavailable(err)
 	}
-	defer cursor.Close(ctx)
+	defer cur.Close(ctx)
 
-	var jars []*models.Jar
-	if err := cursor.All(ctx, &jars); err != nil {
-		return nil, err
+	jars := []*models.Jar{}
+	if err := cur.All(ctx, &jars); err != nil {
+		return nil, unavailable(err)
 	}
 
+	if backward {
+		for i, j := 0, len(jars)-1; i < j; i, j = i+1, j-1 {
+			jars[i], jars[j] = jars[j], jars[i]
+		}
+	}
 	return jars, nil
 }
 
+// seekDocument returns the conditions and the sort reading the jars past the
+// cursor, in a listing sorted by creation time. Jars created at the same time
+// are ordered by ID. The page before a cursor is read in reverse, so FindAll
+// turns it around.
+func seekDocument(c *cursor.Cursor, sortOrder string) (bson.A, bson.D, error) {
+	id, err := primitive.ObjectIDFromHex(c.ID)
+	if err != nil {
+		return nil, nil, cursor.ErrInvalid
+	}
+
+	op, direction := "$gt", 1
+	if (sortOrder == models.SortNewest) != c.Before {
+		op, direction = "$lt", -1
+	}
+	seek := bson.A{
+		bson.M{"created_at": bson.M{op: c.CreatedAt}},
+		bson.M{"created_at": c.CreatedAt, "_id": bson.M{op: id}},
+	}
+	return seek, bson.D{{Key: "created_at", Value: direction}, {Key: "_id", Value: direction}}, nil
+}
+
+func (r *jarRepository) Count(ctx context.Context, filter *models.JarFilter) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := r.collection.CountDocuments(ctx, filterDocument(filter))
+	return count, unavailable(err)
+}
+
+// Facets counts the matching jars by category, clay type, glaze type and
+// price range in a single aggregation. The filters on those attributes are
+// applied per facet rather than up front, so that each facet is counted
+// without its own filter.
+func (r *jarRepository) Facets(ctx context.Context, filter *models.JarFilter) (*models.JarFacets, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cur, err := r.collection.Aggregate(ctx, facetPipeline(filter))
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	defer cur.Close(ctx)
+
+	var results []struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Categories  []models.FacetCount `bson:"categories"`
+		ClayTypes   []models.FacetCount `bson:"clay_types"`
+		GlazeTypes  []models.FacetCount `bson:"glaze_types"`
+		PriceRanges []struct {
+			Min   float64 `bson:"_id"`
+			Count int64   `bson:"count"`
+		} `bson:"price_ranges"`
+	}
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, unavailable(err)
+	}
+
+	facets := &models.JarFacets{
+		Categories:  []models.FacetCount{},
+		ClayTypes:   []models.FacetCount{},
+		GlazeTypes:  []models.FacetCount{},
+		PriceRanges: make([]models.PriceBucket, len(models.PriceBuckets)),
+	}
+	for i, bound := range models.PriceBuckets {
+		facets.PriceRanges[i].Min = bound
+		if i+1 < len(models.PriceBuckets) {
+			next := models.PriceBuckets[i+1]
+			facets.PriceRanges[i].Max = &next
+		}
+	}
+	if len(results) == 0 {
+		return facets, nil
+	}
+
+	result := results[0]
+	if len(result.Total) > 0 {
+		facets.Total = result.Total[0].Count
+	}
+	if result.Categories != nil {
+		facets.Categories = result.Categories
+	}
+	if result.ClayTypes != nil {
+		facets.ClayTypes = result.ClayTypes
+	}
+	if result.GlazeTypes != nil {
+		facets.GlazeTypes = result.GlazeTypes
+	}
+	for _, bucket := range result.PriceRanges {
+		for i := range facets.PriceRanges {
+			if facets.PriceRanges[i].Min == bucket.Min {
+				facets.PriceRanges[i].Count = bucket.Count
+			}
+		}
+	}
+
+	return facets, nil
+}
+
+// facetPipeline returns the aggregation counting the facets of the jars
+// matching filter. Values at or above the last price bound fall into the
+// $bucket default, which is that bound, so the top range is open-ended.
+func facetPipeline(filter *models.JarFilter) mongo.Pipeline {
+	base := *filter
+	base.Category, base.ClayType, base.GlazeType = "", "", ""
+	base.MinPrice, base.MaxPrice = nil, nil
+
+	// without returns the attribute filters other than the facet's own.
+	without := func(clear func(f *models.JarFilter)) bson.M {
+		facet := models.JarFilter{
+			Category:  filter.Category,
+			ClayType:  filter.ClayType,
+			GlazeType: filter.GlazeType,
+			MinPrice:  filter.MinPrice,
+			MaxPrice:  filter.MaxPrice,
+		}
+		clear(&facet)
+		return filterDocument(&facet)
+	}
+	countBy := func(field string, clear func(f *models.JarFilter)) bson.A {
+		match := without(clear)
+		match[field] = bson.M{"$exists": true, "$ne": ""}
+		return bson.A{
+			bson.M{"$match": match},
+			bson.M{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+		}
+	}
+
+	return mongo.Pipeline{
+		// $text is only allowed in the first stage.
+		{{Key: "$match", Value: filterDocument(&base)}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{
+				bson.M{"$match": without(func(f *models.JarFilter) {})},
+				bson.M{"$count": "count"},
+			},
+			"categories":  countBy("category", func(f *models.JarFilter) { f.Category = "" }),
+			"clay_types":  countBy("attributes.clay_type", func(f *models.JarFilter) { f.ClayType = "" }),
+			"glaze_types": countBy("attributes.glaze_type", func(f *models.JarFilter) { f.GlazeType = "" }),
+			"price_ranges": bson.A{
+				bson.M{"$match": without(func(f *models.JarFilter) { f.MinPrice, f.MaxPrice = nil, nil })},
+				bson.M{"$bucket": bson.M{
+					"groupBy":    "$price",
+					"boundaries": models.PriceBuckets,
+					"default":    models.PriceBuckets[len(models.PriceBuckets)-1],
+					"output":     bson.M{"count": bson.M{"$sum": 1}},
+				}},
+			},
+		}}},
+	}
+}
+
+// filterDocument translates a catalogue filter into a Mongo query. Archived
+// jars are only matched when the filter asks for them.
+func filterDocument(filter *models.JarFilter) bson.M {
+	query := bson.M{"archived_at": nil}
+	if filter.Archived {
+		query["archived_at"] = bson.M{"$ne": nil}
+	}
+
+	if filter.Query != "" {
+		query["$text"] = bson.M{"$search": filter.Query}
+	}
+	if filter.Category != "" {
+		query["category"] = filter.Category
+	}
+	if filter.ClayType != "" {
+		query["attributes.clay_type"] = filter.ClayType
+	}
+	if filter.GlazeType != "" {
+		query["attributes.glaze_type"] = filter.GlazeType
+	}
+	if filter.ProductionType != "" {
+		query["attributes.production_type"] = filter.ProductionType
+	}
+	if filter.FoodSafe != nil {
+		query["attributes.food_safe"] = *filter.FoodSafe
+	}
+	if filter.MicrowaveSafe != nil {
+		query["attributes.microwave_safe"] = *filter.MicrowaveSafe
+	}
+	if filter.DishwasherSafe != nil {
+		query["attributes.dishwasher_safe"] = *filter.DishwasherSafe
+	}
+
+	price := bson.M{}
+	if filter.MinPrice != nil {
+		price["$gte"] = *filter.MinPrice
+	}
+	if filter.MaxPrice != nil {
+		price["$lte"] = *filter.MaxPrice
+	}
+	if len(price) > 0 {
+		query["price"] = price
+	}
+
+	if filter.InStock != nil {
+		if *filter.InStock {
+			query["stock_qty"] = bson.M{"$gt": 0}
+		} else {
+			query["stock_qty"] = bson.M{"$lte": 0}
+		}
+	}
+
+	return query
+}
+
+// sortDocument orders results by the filter's sort. _id breaks ties so pages
+// are stable.
+func sortDocument(filter *models.JarFilter) bson.D {
+	sort := filter.SortOrder()
+	if sort == models.SortRelevance {
+		return bson.D{
+			{Key: "score", Value: bson.M{"$meta": "textScore"}},
+			{Key: "_id", Value: -1},
+		}
+	}
+
+	field, direction := strings.TrimPrefix(sort, "-"), 1
+	if strings.HasPrefix(sort, "-") {
+		direction = -1
+	}
+	return bson.D{
+		{Key: field, Value: direction},
+		{Key: "_id", Value: direction},
+	}
+}
+
+// Update writes the jar if it is still at jar.Version, and moves it to the
+// next version.
 func (r *jarRepository) Update(ctx context.Context, id string, jar *models.Jar) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
+		return ErrInvalidJarID
 	}
 
 	jar.PrepareForUpdate()
@@ -119,22 +411,128 @@ func (r *jarRepository) Update(ctx context.Context, id string, jar *models.Jar)
 			"image_url":   jar.ImageUrl,
 			"attributes":  jar.Attributes,
 			"updated_at":  jar.UpdatedAt,
+			"version":     jar.Version + 1,
 		},
 	}
 
-	_, err = r.collection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
-	return err
+	result, err := r.collection.UpdateOne(ctx, versionFilter(objectID, jar.Version), update)
+	if err != nil {
+		return unavailable(err)
+	}
+	if result.MatchedCount == 0 {
+		return r.missing(ctx, objectID)
+	}
+
+	jar.Version++
+	return nil
 }
 
-func (r *jarRepository) Delete(ctx context.Context, id string) error {
+// Archive withdraws the jar from the catalogue if it is still at
+// jar.Version, and moves it to the next version.
+func (r *jarRepository) Archive(ctx context.Context, jar *models.Jar) error {
+	jar.PrepareForUpdate()
+	archivedAt := jar.UpdatedAt
+	update := bson.M{
+		"$set": bson.M{"archived_at": archivedAt, "updated_at": jar.UpdatedAt, "version": jar.Version + 1},
+	}
+	if err := r.setArchived(ctx, jar, update); err != nil {
+		return err
+	}
+	jar.ArchivedAt = &archivedAt
+	return nil
+}
+
+// Restore returns the archived jar to the catalogue if it is still at
+// jar.Version, and moves it to the next version.
+func (r *jarRepository) Restore(ctx context.Context, jar *models.Jar) error {
+	jar.PrepareForUpdate()
+	update := bson.M{
+		"$set":   bson.M{"updated_at": jar.UpdatedAt, "version": jar.Version + 1},
+		"$unset": bson.M{"archived_at": ""},
+	}
+	if err := r.setArchived(ctx, jar, update); err != nil {
+		return err
+	}
+	jar.ArchivedAt = nil
+	return nil
+}
+
+func (r *jarRepository) setArchived(ctx context.Context, jar *models.Jar, update bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx, versionFilter(jar.ID, jar.Version), update)
+	if err != nil {
+		return unavailable(err)
+	}
+	if result.MatchedCount == 0 {
+		return r.missing(ctx, jar.ID)
+	}
+
+	jar.Version++
+	return nil
+}
+
+// FindArchivedBefore returns up to limit jars archived before the given time,
+// oldest first.
+func (r *jarRepository) FindArchivedBefore(ctx context.Context, before time.Time, limit int64) ([]*models.Jar, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetLimit(limit).SetSort(bson.D{{Key: "archived_at", Value: 1}})
+
+	cur, err := r.collection.Find(ctx, bson.M{"archived_at": bson.M{"$lt": before}}, opts)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	defer cur.Close(ctx)
+
+	jars := []*models.Jar{}
+	if err := cur.All(ctx, &jars); err != nil {
+		return nil, unavailable(err)
+	}
+	return jars, nil
+}
+
+// Delete removes the jar for good if it is still at version. Jars are
+// archived rather than deleted; only purging archived jars deletes them.
+func (r *jarRepository) Delete(ctx context.Context, id string, version int64) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
+		return ErrInvalidJarID
 	}
 
-	_, err = r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
-	return err
+	result, err := r.collection.DeleteOne(ctx, versionFilter(objectID, version))
+	if err != nil {
+		return unavailable(err)
+	}
+	if result.DeletedCount == 0 {
+		return r.missing(ctx, objectID)
+	}
+	return nil
+}
+
+// missing tells why a write matched no jar: it is gone, or at another
+// version.
+func (r *jarRepository) missing(ctx context.Context, id primitive.ObjectID) error {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return unavailable(err)
+	}
+	if count == 0 {
+		return ErrJarNotFound
+	}
+	return ErrJarModified
+}
+
+// versionFilter matches the jar at version. Version 0 also matches jars
+// stored before versioning, which have no version field.
+func versionFilter(id primitive.ObjectID, version int64) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, "version": bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{"_id": id, "version": version}
 }
@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/clayjar-jar-service/internal/cursor"
+	"github.com/0Bleak/clayjar-jar-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSeekDocument(t *testing.T) {
+	createdAt := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	id := primitive.NewObjectID()
+
+	tests := []struct {
+		name      string
+		sort      string
+		before    bool
+		op        string
+		direction int
+	}{
+		{"newest first, next page", models.SortNewest, false, "$lt", -1},
+		{"newest first, previous page", models.SortNewest, true, "$gt", 1},
+		{"oldest first, next page", models.SortOldest, false, "$gt", 1},
+		{"oldest first, previous page", models.SortOldest, true, "$lt", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seek, sort, err := seekDocument(&cursor.Cursor{CreatedAt: createdAt, ID: id.Hex(), Before: tt.before}, tt.sort)
+			if err != nil {
+				t.Fatalf("seekDocument() error = %v", err)
+			}
+
+			// Jars created at the cursor's time are split by ID, so ties are
+			// neither repeated nor skipped.
+			wantSeek := bson.A{
+				bson.M{"created_at": bson.M{tt.op: createdAt}},
+				bson.M{"created_at": createdAt, "_id": bson.M{tt.op: id}},
+			}
+			if !reflect.DeepEqual(seek, wantSeek) {
+				t.Errorf("seek = %v, want %v", seek, wantSeek)
+			}
+			wantSort := bson.D{{Key: "created_at", Value: tt.direction}, {Key: "_id", Value: tt.direction}}
+			if !reflect.DeepEqual(sort, wantSort) {
+				t.Errorf("sort = %v, want %v", sort, wantSort)
+			}
+		})
+	}
+}
+
+func TestSeekDocumentRejectsInvalidID(t *testing.T) {
+	_, _, err := seekDocument(&cursor.Cursor{CreatedAt: time.Now(), ID: "42"}, models.SortNewest)
+	if !errors.Is(err, cursor.ErrInvalid) {
+		t.Fatalf("seekDocument() error = %v, want cursor.ErrInvalid", err)
+	}
+}
+
+func TestFilterDocument(t *testing.T) {
+	yes, no := true, false
+	minPrice, maxPrice := 25.0, 100.0
+
+	tests := []struct {
+		name   string
+		filter models.JarFilter
+		want   bson.M
+	}{
+		{"no filter", models.JarFilter{}, bson.M{"archived_at": nil}},
+		{"archived", models.JarFilter{Archived: true}, bson.M{"archived_at": bson.M{"$ne": nil}}},
+		{"text query", models.JarFilter{Query: "blue vase"}, bson.M{"archived_at": nil, "$text": bson.M{"$search": "blue vase"}}},
+		{"category", models.JarFilter{Category: "vases"}, bson.M{"archived_at": nil, "category": "vases"}},
+		{"clay type", models.JarFilter{ClayType: "stoneware"}, bson.M{"archived_at": nil, "attributes.clay_type": "stoneware"}},
+		{"glaze type", models.JarFilter{GlazeType: "matte"}, bson.M{"archived_at": nil, "attributes.glaze_type": "matte"}},
+		{"production type", models.JarFilter{ProductionType: "handmade"}, bson.M{"archived_at": nil, "attributes.production_type": "handmade"}},
+		{"food safe", models.JarFilter{FoodSafe: &yes}, bson.M{"archived_at": nil, "attributes.food_safe": true}},
+		{"not microwave safe", models.JarFilter{MicrowaveSafe: &no}, bson.M{"archived_at": nil, "attributes.microwave_safe": false}},
+		{"dishwasher safe", models.JarFilter{DishwasherSafe: &yes}, bson.M{"archived_at": nil, "attributes.dishwasher_safe": true}},
+		{"min price", models.JarFilter{MinPrice: &minPrice}, bson.M{"archived_at": nil, "price": bson.M{"$gte": 25.0}}},
+		{"max price", models.JarFilter{MaxPrice: &maxPrice}, bson.M{"archived_at": nil, "price": bson.M{"$lte": 100.0}}},
+		{"price range", models.JarFilter{MinPrice: &minPrice, MaxPrice: &maxPrice}, bson.M{"archived_at": nil, "price": bson.M{"$gte": 25.0, "$lte": 100.0}}},
+		{"in stock", models.JarFilter{InStock: &yes}, bson.M{"archived_at": nil, "stock_qty": bson.M{"$gt": 0}}},
+		{"out of stock", models.JarFilter{InStock: &no}, bson.M{"archived_at": nil, "stock_qty": bson.M{"$lte": 0}}},
+		{
+			name:   "filters combine",
+			filter: models.JarFilter{Category: "mugs", GlazeType: "gloss", FoodSafe: &yes, MaxPrice: &maxPrice},
+			want: bson.M{
+				"archived_at":           nil,
+				"category":              "mugs",
+				"attributes.glaze_type": "gloss",
+				"attributes.food_safe":  true,
+				"price":                 bson.M{"$lte": 100.0},
+			},
+		},
+		// Sorting and paging do not select jars.
+		{"sort and page", models.JarFilter{Sort: models.SortPriceAsc, Limit: 10, Offset: 20}, bson.M{"archived_at": nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filterDocument(&tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("filterDocument() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortDocument(t *testing.T) {
+	tests := []struct {
+		filter models.JarFilter
+		want   bson.D
+	}{
+		{models.JarFilter{}, bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{models.JarFilter{Sort: models.SortPriceAsc}, bson.D{{Key: "price", Value: 1}, {Key: "_id", Value: 1}}},
+		{models.JarFilter{Sort: models.SortNameDesc}, bson.D{{Key: "name", Value: -1}, {Key: "_id", Value: -1}}},
+		{models.JarFilter{Query: "vase"}, bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: -1}}},
+		{models.JarFilter{Query: "vase", Sort: models.SortOldest}, bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+	}
+
+	for _, tt := range tests {
+		if got := sortDocument(&tt.filter); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortDocument(%+v) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestFacetPipeline(t *testing.T) {
+	yes := true
+	minPrice, maxPrice := 25.0, 100.0
+	filter := &models.JarFilter{
+		Query:     "vase",
+		Category:  "vases",
+		ClayType:  "stoneware",
+		GlazeType: "matte",
+		MinPrice:  &minPrice,
+		MaxPrice:  &maxPrice,
+		FoodSafe:  &yes,
+		Sort:      models.SortPriceAsc,
+		Limit:     10,
+	}
+
+	pipeline := facetPipeline(filter)
+	if len(pipeline) != 2 {
+		t.Fatalf("pipeline has %d stages, want 2", len(pipeline))
+	}
+
+	// The first stage applies every filter except the faceted attributes.
+	wantMatch := bson.D{{Key: "$match", Value: bson.M{
+		"archived_at":          nil,
+		"$text":                bson.M{"$search": "vase"},
+		"attributes.food_safe": true,
+	}}}
+	if !reflect.DeepEqual(pipeline[0], wantMatch) {
+		t.Fatalf("first stage = %v, want %v", pipeline[0], wantMatch)
+	}
+
+	if pipeline[1][0].Key != "$facet" {
+		t.Fatalf("second stage = %v, want $facet", pipeline[1])
+	}
+	facets := pipeline[1][0].Value.(bson.M)
+
+	category := bson.M{"category": "vases"}
+	clayType := bson.M{"attributes.clay_type": "stoneware"}
+	glazeType := bson.M{"attributes.glaze_type": "matte"}
+	price := bson.M{"price": bson.M{"$gte": 25.0, "$lte": 100.0}}
+	present := bson.M{"$exists": true, "$ne": ""}
+	match := func(parts ...bson.M) bson.M {
+		m := bson.M{"archived_at": nil}
+		for _, part := range parts {
+			for key, value := range part {
+				m[key] = value
+			}
+		}
+		return m
+	}
+
+	// Each facet drops the filter on its own attribute and keeps the others.
+	tests := []struct {
+		facet string
+		match bson.M
+	}{
+		{"total", match(category, clayType, glazeType, price)},
+		{"categories", match(clayType, glazeType, price, bson.M{"category": present})},
+		{"clay_types", match(category, glazeType, price, bson.M{"attributes.clay_type": present})},
+		{"glaze_types", match(category, clayType, price, bson.M{"attributes.glaze_type": present})},
+		{"price_ranges", match(category, clayType, glazeType)},
+	}
+	for _, tt := range tests {
+		stages, ok := facets[tt.facet].(bson.A)
+		if !ok || len(stages) == 0 {
+			t.Fatalf("facet %s = %v", tt.facet, facets[tt.facet])
+		}
+		if got := stages[0]; !reflect.DeepEqual(got, bson.M{"$match": tt.match}) {
+			t.Errorf("facet %s: first stage = %v, want $match %v", tt.facet, got, tt.match)
+		}
+	}
+
+	for facet, field := range map[string]string{"categories": "$category", "clay_types": "$attributes.clay_type", "glaze_types": "$attributes.glaze_type"} {
+		group := facets[facet].(bson.A)[1].(bson.M)["$group"].(bson.M)
+		if group["_id"] != field {
+			t.Errorf("facet %s groups by %v, want %s", facet, group["_id"], field)
+		}
+	}
+
+	// Prices from the last bound up land in the default bucket, which is
+	// that bound, so the top range has no upper limit.
+	bucket := facets["price_ranges"].(bson.A)[1].(bson.M)["$bucket"].(bson.M)
+	if bucket["groupBy"] != "$price" {
+		t.Errorf("$bucket groups by %v, want $price", bucket["groupBy"])
+	}
+	if !reflect.DeepEqual(bucket["boundaries"], []float64{0, 25, 50, 100, 250}) {
+		t.Errorf("$bucket boundaries = %v", bucket["boundaries"])
+	}
+	if bucket["default"] != 250.0 {
+		t.Errorf("$bucket default = %v, want 250", bucket["default"])
+	}
+}
+
+// Without attribute filters every facet counts the whole catalogue.
+func TestFacetPipelineWithoutFilters(t *testing.T) {
+	pipeline := facetPipeline(&models.JarFilter{})
+
+	if want := (bson.D{{Key: "$match", Value: bson.M{"archived_at": nil}}}); !reflect.DeepEqual(pipeline[0], want) {
+		t.Fatalf("first stage = %v, want %v", pipeline[0], want)
+	}
+	facets := pipeline[1][0].Value.(bson.M)
+	want := bson.M{"$match": bson.M{"archived_at": nil}}
+	for _, facet := range []string{"total", "price_ranges"} {
+		if got := facets[facet].(bson.A)[0]; !reflect.DeepEqual(got, want) {
+			t.Errorf("facet %s: first stage = %v, want %v", facet, got, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Header carries the request ID on HTTP requests and responses.
+const Header = "X-Request-ID"
+
+// Kafka message headers. Events carry the ID of the request that started the
+// chain they belong to, their own ID, and the ID of the request or event that
+// caused them.
+const (
+	MessageHeader   = "request-id"
+	EventIDHeader   = "event-id"
+	CausationHeader = "causation-id"
+)
+
+// maxLength bounds IDs accepted from callers, which end up in every log line.
+const maxLength = 128
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	causationIDKey
+)
+
+// New returns a random 128-bit ID.
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether an ID received from a caller may be reused: it must be
+// short and made of URL-safe characters only.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext stores the request ID. The request is also recorded as the cause
+// of any event published while handling it.
+func NewContext(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+	return context.WithValue(ctx, causationIDKey, id)
+}
+
+// WithCausation records the event being handled as the cause of any event
+// published while handling it.
+func WithCausation(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, causationIDKey, id)
+}
+
+// FromContext returns the request ID, or "" outside a request.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func CausationFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(causationIDKey).(string)
+	return id
+}
+
+// Printf logs like log.Printf, followed by the request ID in ctx.
+func Printf(ctx context.Context, format string, v ...any) {
+	log.Printf("%s request_id=%s", fmt.Sprintf(format, v...), FromContext(ctx))
+}
+
+// Middleware reuses the caller's X-Request-ID, or assigns one, stores it in
+// the context, returns it on the response and logs the request with it.
+// Health checks are not logged.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := NewContext(r.Context(), id)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if r.URL.Path != "/health" {
+			Printf(ctx, "[%s] %s %d in %v", r.Method, r.RequestURI, rec.status, time.Since(start))
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -1,22 +1,47 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/0Bleak/clayjar-jar-service/internal/cursor"
 	"github.com/0Bleak/clayjar-jar-service/internal/messaging"
 	"github.com/0Bleak/clayjar-jar-service/internal/models"
+	"github.com/0Bleak/clayjar-jar-service/internal/problem"
 	"github.com/0Bleak/clayjar-jar-service/internal/repository"
 )
 
 type JarService interface {
 	CreateJar(ctx context.Context, req *models.CreateJarRequest) (*models.Jar, error)
 	GetJarByID(ctx context.Context, id string) (*models.Jar, error)
-	GetAllJars(ctx context.Context, limit, offset int64) ([]*models.Jar, error)
-	UpdateJar(ctx context.Context, id string, req *models.CreateJarRequest) (*models.Jar, error)
-	DeleteJar(ctx context.Context, id string) error
+	GetAllJars(ctx context.Context, filter *models.JarFilter) (*models.JarPage, error)
+	GetJarFacets(ctx context.Context, filter *models.JarFilter) (*models.JarFacets, error)
+	UpdateJar(ctx context.Context, id string, req *models.CreateJarRequest, ifMatch string) (*models.Jar, error)
+	PatchJar(ctx context.Context, id string, patch map[string]any, ifMatch string) (*models.Jar, error)
+	ArchiveJar(ctx context.Context, id string, ifMatch string) (*models.Jar, error)
+	RestoreJar(ctx context.Context, id string, ifMatch string) (*models.Jar, error)
+	PurgeArchivedJars(ctx context.Context, before time.Time) (int, error)
 }
 
+// ErrConcurrentUpdate is returned when the jar changed between reading and
+// writing it and the request did not name the version it was based on: a
+// restore without If-Match, or a write with If-Match: *. Other writes fail
+// with repository.ErrJarModified instead.
+var ErrConcurrentUpdate = problem.Conflict("Jar was modified by another request, try again")
+
+// ErrIfMatchRequired is returned for updates and deletes sent without
+// If-Match.
+var ErrIfMatchRequired = problem.PreconditionRequired("If-Match with the jar's ETag is required")
+
+// purgeBatch is how many archived jars are read at a time when purging.
+const purgeBatch = 100
+
 type jarService struct {
 	repo     repository.JarRepository
 	producer messaging.KafkaProducer
@@ -71,81 +96,322 @@ func (s *jarService) GetJarByID(ctx context.Context, id string) (*models.Jar, er
 	return jar, nil
 }
 
-func (s *jarService) GetAllJars(ctx context.Context, limit, offset int64) ([]*models.Jar, error) {
-	if limit <= 0 {
-		limit = 10
+func (s *jarService) GetAllJars(ctx context.Context, filter *models.JarFilter) (*models.JarPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 10
 	}
-	if limit > 100 {
-		limit = 100
+	if filter.Limit > 100 {
+		filter.Limit = 100
 	}
-	if offset < 0 {
-		offset = 0
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	if err := filter.Validate(); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
 	}
 
-	jars, err := s.repo.FindAll(ctx, limit, offset)
+	// One extra jar tells whether another page follows.
+	query := *filter
+	query.Limit++
+	jars, err := s.repo.FindAll(ctx, &query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch jars: %w", err)
 	}
 
-	return jars, nil
+	backward := filter.Cursor != nil && filter.Cursor.Before
+	more := int64(len(jars)) > filter.Limit
+	if more && backward {
+		jars = jars[1:]
+	} else if more {
+		jars = jars[:filter.Limit]
+	}
+
+	total, err := s.repo.Count(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count jars: %w", err)
+	}
+
+	page := &models.JarPage{Jars: jars, Total: total}
+
+	sort := filter.SortOrder()
+	if len(jars) > 0 && (sort == models.SortNewest || sort == models.SortOldest) {
+		first, last := jars[0], jars[len(jars)-1]
+		if more || backward {
+			page.Next = &cursor.Cursor{CreatedAt: last.CreatedAt, ID: last.ID.Hex()}
+		}
+		if (more && backward) || (!backward && (filter.Cursor != nil || filter.Offset > 0)) {
+			page.Prev = &cursor.Cursor{CreatedAt: first.CreatedAt, ID: first.ID.Hex(), Before: true}
+		}
+	}
+
+	return page, nil
 }
 
-func (s *jarService) UpdateJar(ctx context.Context, id string, req *models.CreateJarRequest) (*models.Jar, error) {
+func (s *jarService) GetJarFacets(ctx context.Context, filter *models.JarFilter) (*models.JarFacets, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	facets, err := s.repo.Facets(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count facets: %w", err)
+	}
+
+	return facets, nil
+}
+
+// UpdateJar replaces the editable fields of the jar. ifMatch is required and
+// must be "*" or list the jar's current ETag.
+func (s *jarService) UpdateJar(ctx context.Context, id string, req *models.CreateJarRequest, ifMatch string) (*models.Jar, error) {
+	if err := requireETag(ifMatch); err != nil {
+		return nil, err
+	}
+
+	existingJar, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jar: %w", err)
+	}
+	if !existingJar.MatchesETag(ifMatch) {
+		return nil, repository.ErrJarModified
+	}
+	if existingJar.IsArchived() {
+		return nil, repository.ErrJarArchived
+	}
+
+	existingJar.Apply(req)
+	return s.update(ctx, id, existingJar, ifMatch)
+}
+
+// PatchJar applies a JSON Merge Patch (RFC 7396) to the editable fields of
+// the jar: members of patch replace the jar's, null members reset them and
+// nested objects are merged.
+func (s *jarService) PatchJar(ctx context.Context, id string, patch map[string]any, ifMatch string) (*models.Jar, error) {
+	if err := requireETag(ifMatch); err != nil {
+		return nil, err
+	}
+
 	existingJar, err := s.repo.FindByID(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("jar not found: %w", err)
+		return nil, fmt.Errorf("failed to fetch jar: %w", err)
+	}
+	if !existingJar.MatchesETag(ifMatch) {
+		return nil, repository.ErrJarModified
+	}
+	if existingJar.IsArchived() {
+		return nil, repository.ErrJarArchived
 	}
 
-	existingJar.Name = req.Name
-	existingJar.Description = req.Description
-	existingJar.Category = req.Category
-	existingJar.Price = req.Price
-	existingJar.StockQty = req.StockQty
-	existingJar.ImageUrl = req.ImageURL
-	existingJar.Attributes = req.Attributes
+	req, err := mergePatch(existingJar.Request(), patch)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := existingJar.Validate(); err != nil {
+	existingJar.Apply(req)
+	return s.update(ctx, id, existingJar, ifMatch)
+}
+
+func (s *jarService) update(ctx context.Context, id string, jar *models.Jar, ifMatch string) (*models.Jar, error) {
+	if err := jar.Validate(); err != nil {
 		return nil, fmt.Errorf("validation failed: %w", err)
 	}
 
-	if err := s.repo.Update(ctx, id, existingJar); err != nil {
+	if err := s.repo.Update(ctx, id, jar); err != nil {
+		if errors.Is(err, repository.ErrJarModified) && !namesVersion(ifMatch) {
+			return nil, ErrConcurrentUpdate
+		}
 		return nil, fmt.Errorf("failed to update jar: %w", err)
 	}
 
 	event := models.JarEvent{
 		Type:      "jar.updated",
-		JarID:     existingJar.ID.Hex(),
-		Payload:   existingJar,
-		Timestamp: existingJar.UpdatedAt,
+		JarID:     jar.ID.Hex(),
+		Payload:   jar,
+		Timestamp: jar.UpdatedAt,
 	}
 
 	if err := s.producer.PublishJarEvent(ctx, &event); err != nil {
 		return nil, fmt.Errorf("failed to publish jar updated event: %w", err)
 	}
 
-	return existingJar, nil
+	return jar, nil
+}
+
+// ArchiveJar withdraws the jar from the catalogue. It stays available by ID
+// for the orders that refer to it, and can be restored. ifMatch is required,
+// as for UpdateJar.
+func (s *jarService) ArchiveJar(ctx context.Context, id string, ifMatch string) (*models.Jar, error) {
+	if err := requireETag(ifMatch); err != nil {
+		return nil, err
+	}
+	return s.setArchived(ctx, id, ifMatch, true)
 }
 
-func (s *jarService) DeleteJar(ctx context.Context, id string) error {
+// RestoreJar returns an archived jar to the catalogue. A non-empty ifMatch
+// must list the jar's current ETag.
+func (s *jarService) RestoreJar(ctx context.Context, id string, ifMatch string) (*models.Jar, error) {
+	return s.setArchived(ctx, id, ifMatch, false)
+}
+
+func (s *jarService) setArchived(ctx context.Context, id string, ifMatch string, archive bool) (*models.Jar, error) {
 	jar, err := s.repo.FindByID(ctx, id)
 	if err != nil {
-		return fmt.Errorf("jar not found: %w", err)
+		return nil, fmt.Errorf("failed to fetch jar: %w", err)
+	}
+	if !jar.MatchesETag(ifMatch) {
+		return nil, repository.ErrJarModified
 	}
 
-	if err := s.repo.Delete(ctx, id); err != nil {
-		return fmt.Errorf("failed to delete jar: %w", err)
+	eventType := "jar.archived"
+	if archive {
+		if jar.IsArchived() {
+			return nil, repository.ErrJarArchived
+		}
+		err = s.repo.Archive(ctx, jar)
+	} else {
+		if !jar.IsArchived() {
+			return nil, repository.ErrJarNotArchived
+		}
+		err = s.repo.Restore(ctx, jar)
+		eventType = "jar.restored"
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrJarModified) && !namesVersion(ifMatch) {
+			return nil, ErrConcurrentUpdate
+		}
+		return nil, fmt.Errorf("failed to update jar: %w", err)
 	}
 
 	event := models.JarEvent{
-		Type:      "jar.deleted",
+		Type:      eventType,
 		JarID:     jar.ID.Hex(),
-		Payload:   nil,
+		Payload:   jar,
 		Timestamp: jar.UpdatedAt,
 	}
 
 	if err := s.producer.PublishJarEvent(ctx, &event); err != nil {
-		return fmt.Errorf("failed to publish jar deleted event: %w", err)
+		return nil, fmt.Errorf("failed to publish %s event: %w", eventType, err)
+	}
+
+	return jar, nil
+}
+
+// PurgeArchivedJars deletes the jars archived before the given time for good
+// and returns how many were deleted. Jars restored meanwhile are kept.
+func (s *jarService) PurgeArchivedJars(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	for {
+		jars, err := s.repo.FindArchivedBefore(ctx, before, purgeBatch)
+		if err != nil {
+			return purged, fmt.Errorf("failed to fetch archived jars: %w", err)
+		}
+
+		for _, jar := range jars {
+			err := s.repo.Delete(ctx, jar.ID.Hex(), jar.Version)
+			if errors.Is(err, repository.ErrJarNotFound) || errors.Is(err, repository.ErrJarModified) {
+				continue
+			}
+			if err != nil {
+				return purged, fmt.Errorf("failed to delete jar: %w", err)
+			}
+			purged++
+
+			event := models.JarEvent{
+				Type:      "jar.deleted",
+				JarID:     jar.ID.Hex(),
+				Payload:   nil,
+				Timestamp: time.Now().UTC(),
+			}
+
+			if err := s.producer.PublishJarEvent(ctx, &event); err != nil {
+				return purged, fmt.Errorf("failed to publish jar deleted event: %w", err)
+			}
+		}
+
+		if len(jars) < purgeBatch {
+			return purged, nil
+		}
 	}
+}
 
+// requireETag rejects writes sent without If-Match. "*" is a precondition
+// too: it holds for any version of the jar.
+func requireETag(ifMatch string) error {
+	if strings.TrimSpace(ifMatch) == "" {
+		return ErrIfMatchRequired
+	}
 	return nil
 }
+
+// namesVersion reports whether ifMatch holds the write to particular versions
+// of the jar, rather than to whichever is current.
+func namesVersion(ifMatch string) bool {
+	ifMatch = strings.TrimSpace(ifMatch)
+	return ifMatch != "" && ifMatch != "*"
+}
+
+// mergePatch applies patch to the JSON form of req. Members that are not
+// editable, such as id or version, and values of the wrong type are
+// rejected.
+func mergePatch(req *models.CreateJarRequest, patch map[string]any) (*models.CreateJarRequest, error) {
+	document, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode jar: %w", err)
+	}
+	var target map[string]any
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, fmt.Errorf("failed to encode jar: %w", err)
+	}
+
+	merged, err := json.Marshal(mergeObjects(target, patch))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode jar: %w", err)
+	}
+
+	var patched models.CreateJarRequest
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, problem.Invalid("body."+typeErr.Field, "Must be "+jsonType(typeErr.Type.Kind()))
+		}
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return nil, problem.Invalid("body."+strings.Trim(field, `"`), "Field cannot be changed")
+		}
+		return nil, problem.Validation("Invalid merge patch")
+	}
+
+	return &patched, nil
+}
+
+func mergeObjects(target, patch map[string]any) map[string]any {
+	if target == nil {
+		target = make(map[string]any)
+	}
+	for key, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(target, key)
+		case map[string]any:
+			existing, _ := target[key].(map[string]any)
+			target[key] = mergeObjects(existing, value)
+		default:
+			target[key] = value
+		}
+	}
+	return target
+}
+
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "a number"
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/clayjar-jar-service/internal/messaging"
+	"github.com/0Bleak/clayjar-jar-service/internal/models"
+	"github.com/0Bleak/clayjar-jar-service/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pagingRepository serves FindAll from memory with the keyset semantics of
+// the Mongo queries: jars ordered by (created_at, _id), the page before a
+// cursor read in reverse and turned around. The tests using it cover the
+// service's cursor bookkeeping only; the Mongo conditions are checked by
+// TestSeekDocument in the repository package.
+type pagingRepository struct {
+	repository.JarRepository
+	jars []*models.Jar
+}
+
+func (r *pagingRepository) FindAll(ctx context.Context, filter *models.JarFilter) ([]*models.Jar, error) {
+	backward := filter.Cursor != nil && filter.Cursor.Before
+	descending := (filter.SortOrder() == models.SortNewest) != backward
+
+	jars := append([]*models.Jar(nil), r.jars...)
+	sort.Slice(jars, func(i, j int) bool { return earlier(jars[i], jars[j]) != descending })
+
+	if c := filter.Cursor; c != nil {
+		at := &models.Jar{CreatedAt: c.CreatedAt}
+		at.ID, _ = primitive.ObjectIDFromHex(c.ID)
+
+		var past []*models.Jar
+		for _, jar := range jars {
+			if (descending && earlier(jar, at)) || (!descending && earlier(at, jar)) {
+				past = append(past, jar)
+			}
+		}
+		jars = past
+	}
+
+	jars = jars[min(filter.Offset, int64(len(jars))):]
+	jars = jars[:min(filter.Limit, int64(len(jars)))]
+	if backward {
+		for i, j := 0, len(jars)-1; i < j; i, j = i+1, j-1 {
+			jars[i], jars[j] = jars[j], jars[i]
+		}
+	}
+	return jars, nil
+}
+
+func (r *pagingRepository) Count(ctx context.Context, filter *models.JarFilter) (int64, error) {
+	return int64(len(r.jars)), nil
+}
+
+func earlier(a, b *models.Jar) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
+
+// tiedJars are numbered 1 to 7 in the last byte of their ID, oldest first.
+// They share their creation time in runs, so pages split ties.
+func tiedJars() []*models.Jar {
+	t0 := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	times := []time.Time{t0, t0, t0, t0.Add(time.Second), t0.Add(time.Second), t0.Add(2 * time.Second), t0.Add(2 * time.Second)}
+
+	jars := make([]*models.Jar, len(times))
+	for i, createdAt := range times {
+		jars[i] = &models.Jar{CreatedAt: createdAt}
+		jars[i].ID[11] = byte(i + 1)
+	}
+	return jars
+}
+
+func numbers(jars []*models.Jar) []int {
+	numbers := make([]int, len(jars))
+	for i, jar := range jars {
+		numbers[i] = int(jar.ID[11])
+	}
+	return numbers
+}
+
+func TestGetAllJarsCursorBookkeeping(t *testing.T) {
+	tests := []struct {
+		name  string
+		sort  string
+		limit int64
+		pages [][]int
+	}{
+		{"newest first by 2", models.SortNewest, 2, [][]int{{7, 6}, {5, 4}, {3, 2}, {1}}},
+		{"newest first by 3", models.SortNewest, 3, [][]int{{7, 6, 5}, {4, 3, 2}, {1}}},
+		{"oldest first by 2", models.SortOldest, 2, [][]int{{1, 2}, {3, 4}, {5, 6}, {7}}},
+		{"oldest first by 3", models.SortOldest, 3, [][]int{{1, 2, 3}, {4, 5, 6}, {7}}},
+		{"single page", models.SortNewest, 10, [][]int{{7, 6, 5, 4, 3, 2, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewJarService(&pagingRepository{jars: tiedJars()}, nil)
+			ctx := context.Background()
+
+			// Forward through Next.
+			var forward []*models.JarPage
+			page, err := svc.GetAllJars(ctx, &models.JarFilter{Sort: tt.sort, Limit: tt.limit})
+			for {
+				if err != nil {
+					t.Fatalf("GetAllJars() error = %v", err)
+				}
+				if page.Total != 7 {
+					t.Fatalf("Total = %d, want 7", page.Total)
+				}
+				forward = append(forward, page)
+				if page.Next == nil {
+					break
+				}
+				page, err = svc.GetAllJars(ctx, &models.JarFilter{Sort: tt.sort, Limit: tt.limit, Cursor: page.Next})
+			}
+
+			if len(forward) != len(tt.pages) {
+				t.Fatalf("got %d pages, want %d", len(forward), len(tt.pages))
+			}
+			for i, page := range forward {
+				if got := numbers(page.Jars); !reflect.DeepEqual(got, tt.pages[i]) {
+					t.Fatalf("forward page %d = %v, want %v", i, got, tt.pages[i])
+				}
+			}
+			if forward[0].Prev != nil {
+				t.Fatal("first page has a prev cursor")
+			}
+
+			// Back through Prev from the last page.
+			page = forward[len(forward)-1]
+			for i := len(tt.pages) - 2; i >= 0; i-- {
+				if page.Prev == nil {
+					t.Fatalf("page %d has no prev cursor", i+1)
+				}
+				page, err = svc.GetAllJars(ctx, &models.JarFilter{Sort: tt.sort, Limit: tt.limit, Cursor: page.Prev})
+				if err != nil {
+					t.Fatalf("GetAllJars() error = %v", err)
+				}
+				if got := numbers(page.Jars); !reflect.DeepEqual(got, tt.pages[i]) {
+					t.Fatalf("backward page %d = %v, want %v", i, got, tt.pages[i])
+				}
+				if page.Next == nil {
+					t.Fatalf("backward page %d has no next cursor", i)
+				}
+			}
+			if page.Prev != nil {
+				t.Fatal("first page reached backwards has a prev cursor")
+			}
+		})
+	}
+}
+
+func TestGetAllJarsCursorBookkeepingOtherSorts(t *testing.T) {
+	svc := NewJarService(&pagingRepository{jars: tiedJars()}, nil)
+
+	page, err := svc.GetAllJars(context.Background(), &models.JarFilter{Sort: models.SortPriceAsc, Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("GetAllJars() error = %v", err)
+	}
+	if page.Next != nil || page.Prev != nil {
+		t.Fatalf("listing sorted by price has cursors: next %v, prev %v", page.Next, page.Prev)
+	}
+}
+
+// archiveRepository holds jars the way FindArchivedBefore and Delete see them:
+// archived jars are read oldest first, and a delete only removes a jar still
+// at the version it was read at.
+type archiveRepository struct {
+	repository.JarRepository
+	jars    map[primitive.ObjectID]*models.Jar
+	finds   int
+	findErr error
+	// beforeDelete runs before each delete, to change the jar meanwhile.
+	beforeDelete func(jar *models.Jar)
+}
+
+func (r *archiveRepository) FindArchivedBefore(ctx context.Context, before time.Time, limit int64) ([]*models.Jar, error) {
+	r.finds++
+	if r.findErr != nil {
+		return nil, r.findErr
+	}
+
+	var jars []*models.Jar
+	for _, jar := range r.jars {
+		if jar.ArchivedAt != nil && jar.ArchivedAt.Before(before) {
+			copied := *jar
+			jars = append(jars, &copied)
+		}
+	}
+	sort.Slice(jars, func(i, j int) bool { return jars[i].ArchivedAt.Before(*jars[j].ArchivedAt) })
+	return jars[:min(int64(len(jars)), limit)], nil
+}
+
+func (r *archiveRepository) Delete(ctx context.Context, id string, version int64) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	jar, ok := r.jars[objectID]
+	if ok && r.beforeDelete != nil {
+		r.beforeDelete(jar)
+	}
+	switch {
+	case !ok:
+		return repository.ErrJarNotFound
+	case jar.Version != version:
+		return repository.ErrJarModified
+	}
+	delete(r.jars, objectID)
+	return nil
+}
+
+type recordingProducer struct {
+	messaging.KafkaProducer
+	events []*models.JarEvent
+	err    error
+}
+
+func (p *recordingProducer) PublishJarEvent(ctx context.Context, event *models.JarEvent) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+// archivedJars returns jars archived one second apart, the first one an hour
+// before cutoff, and the jars that are still listed.
+func archivedJars(cutoff time.Time, archived, listed int) map[primitive.ObjectID]*models.Jar {
+	jars := make(map[primitive.ObjectID]*models.Jar)
+	for i := 0; i < archived+listed; i++ {
+		jar := &models.Jar{ID: primitive.NewObjectID(), Version: 3}
+		if i < archived {
+			at := cutoff.Add(-time.Hour + time.Duration(i)*time.Second)
+			jar.ArchivedAt = &at
+		}
+		jars[jar.ID] = jar
+	}
+	return jars
+}
+
+func TestPurgeArchivedJars(t *testing.T) {
+	cutoff := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("deletes jars archived before the cutoff", func(t *testing.T) {
+		jars := archivedJars(cutoff, 3, 2)
+		recent := &models.Jar{ID: primitive.NewObjectID(), Version: 1, ArchivedAt: &cutoff}
+		jars[recent.ID] = recent
+		repo := &archiveRepository{jars: jars}
+		producer := &recordingProducer{}
+
+		purged, err := NewJarService(repo, producer).PurgeArchivedJars(context.Background(), cutoff)
+		if err != nil {
+			t.Fatalf("PurgeArchivedJars() error = %v", err)
+		}
+		if purged != 3 || len(repo.jars) != 3 {
+			t.Fatalf("purged %d, %d jars left; want 3 and 3", purged, len(repo.jars))
+		}
+		if _, ok := repo.jars[recent.ID]; !ok {
+			t.Fatal("jar archived at the cutoff was purged")
+		}
+		if len(producer.events) != 3 {
+			t.Fatalf("published %d events, want 3", len(producer.events))
+		}
+		for _, event := range producer.events {
+			if event.Type != "jar.deleted" || event.JarID == "" {
+				t.Fatalf("event = %+v, want jar.deleted with the jar ID", event)
+			}
+		}
+	})
+
+	t.Run("reads in batches until one is short", func(t *testing.T) {
+		repo := &archiveRepository{jars: archivedJars(cutoff, 2*purgeBatch+10, 0)}
+
+		purged, err := NewJarService(repo, &recordingProducer{}).PurgeArchivedJars(context.Background(), cutoff)
+		if err != nil {
+			t.Fatalf("PurgeArchivedJars() error = %v", err)
+		}
+		if purged != 2*purgeBatch+10 || len(repo.jars) != 0 {
+			t.Fatalf("purged %d, %d jars left", purged, len(repo.jars))
+		}
+		if repo.finds != 3 {
+			t.Fatalf("read %d batches, want 3", repo.finds)
+		}
+	})
+
+	t.Run("keeps jars restored meanwhile", func(t *testing.T) {
+		repo := &archiveRepository{jars: archivedJars(cutoff, 2, 0)}
+		var restored *models.Jar
+		repo.beforeDelete = func(jar *models.Jar) {
+			if restored == nil {
+				restored = jar
+				jar.ArchivedAt = nil
+				jar.Version++
+			}
+		}
+		producer := &recordingProducer{}
+
+		purged, err := NewJarService(repo, producer).PurgeArchivedJars(context.Background(), cutoff)
+		if err != nil {
+			t.Fatalf("PurgeArchivedJars() error = %v", err)
+		}
+		if purged != 1 || len(producer.events) != 1 {
+			t.Fatalf("purged %d with %d events, want 1", purged, len(producer.events))
+		}
+		if _, ok := repo.jars[restored.ID]; !ok || len(repo.jars) != 1 {
+			t.Fatal("restored jar was purged")
+		}
+	})
+
+	t.Run("stops when the store fails", func(t *testing.T) {
+		outage := errors.New("connection refused")
+		repo := &archiveRepository{jars: archivedJars(cutoff, 2, 0), findErr: outage}
+
+		purged, err := NewJarService(repo, &recordingProducer{}).PurgeArchivedJars(context.Background(), cutoff)
+		if !errors.Is(err, outage) || purged != 0 {
+			t.Fatalf("PurgeArchivedJars() = %d, %v; want 0 and the outage", purged, err)
+		}
+	})
+
+	t.Run("stops when publishing fails", func(t *testing.T) {
+		down := errors.New("broker unavailable")
+		repo := &archiveRepository{jars: archivedJars(cutoff, 3, 0)}
+
+		purged, err := NewJarService(repo, &recordingProducer{err: down}).PurgeArchivedJars(context.Background(), cutoff)
+		if !errors.Is(err, down) || purged != 1 {
+			t.Fatalf("PurgeArchivedJars() = %d, %v; want 1 and the broker error", purged, err)
+		}
+		if len(repo.jars) != 2 {
+			t.Fatalf("%d jars left, want 2", len(repo.jars))
+		}
+	})
+}
@@ -14,11 +14,18 @@ import (
 	"github.com/0Bleak/inventory-service/internal/discovery"
 	"github.com/0Bleak/inventory-service/internal/handlers"
 	"github.com/0Bleak/inventory-service/internal/messaging"
+	"github.com/0Bleak/inventory-service/internal/openapi"
+	"github.com/0Bleak/inventory-service/internal/problem"
 	"github.com/0Bleak/inventory-service/internal/repository"
+	"github.com/0Bleak/inventory-service/internal/requestid"
 	"github.com/0Bleak/inventory-service/internal/service"
+	"github.com/0Bleak/inventory-service/internal/shadow"
+	"github.com/0Bleak/inventory-service/internal/tracing"
+	"github.com/XSAM/otelsql"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 func main() {
@@ -33,11 +40,28 @@ func run() error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// Set up tracing
+	shutdownTracing, err := tracing.Init(context.Background(), "inventory-service", cfg.ServiceVersion, cfg.OTLPEndpoint, cfg.TraceSampleRatio)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
 	// Connect to PostgreSQL
-	db, err := sqlx.Connect("postgres", cfg.DatabaseURL)
+	sqlDB, err := otelsql.Open("postgres", cfg.DatabaseURL,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
+	db := sqlx.NewDb(sqlDB, "postgres")
 	defer db.Close()
 
 	if err := db.Ping(); err != nil {
@@ -72,8 +96,21 @@ func run() error {
 		}
 	}()
 
+	// Requests are validated against the service's OpenAPI document
+	validator, err := openapi.NewValidator(cfg.ValidateResponses)
+	if err != nil {
+		return fmt.Errorf("failed to load openapi document: %w", err)
+	}
+
 	// Setup router
 	router := mux.NewRouter()
+	router.NotFoundHandler = requestid.Middleware(http.HandlerFunc(problem.RouteNotFound))
+	router.MethodNotAllowedHandler = requestid.Middleware(http.HandlerFunc(problem.MethodNotAllowed))
+	router.Use(tracing.Middleware("inventory-service"))
+	router.Use(requestid.Middleware)
+	router.Use(shadow.Middleware(cfg.ShadowWrites))
+	router.Use(validator.Middleware)
+	router.HandleFunc("/openapi.json", openapi.ServeSpec).Methods(http.MethodGet)
 	inventoryHandler.RegisterRoutes(router)
 
 	// Register with Consul
@@ -83,10 +120,10 @@ func run() error {
 	}
 
 	serviceID := fmt.Sprintf("inventory-service-%s", cfg.ServiceID)
-	if err := consulClient.RegisterService(serviceID, "inventory-service", cfg.ServerPort); err != nil {
+	if err := consulClient.RegisterService(serviceID, "inventory-service", cfg.ServerPort, cfg.ServiceVersion); err != nil {
 		return fmt.Errorf("failed to register service with consul: %w", err)
 	}
-	log.Printf("Registered with Consul as %s", serviceID)
+	log.Printf("Registered with Consul as %s (version %s)", serviceID, cfg.ServiceVersion)
 
 	defer func() {
 		if err := consulClient.DeregisterService(serviceID); err != nil {
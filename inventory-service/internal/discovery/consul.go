@@ -23,7 +23,9 @@ func NewConsulClient(address string) (*ConsulClient, error) {
 	return &ConsulClient{client: client}, nil
 }
 
-func (c *ConsulClient) RegisterService(serviceID, serviceName, port string) error {
+// RegisterService registers the instance under its build version, both as a
+// tag and as the "version" service meta read by the gateway.
+func (c *ConsulClient) RegisterService(serviceID, serviceName, port, version string) error {
 	// Get the container hostname for health checks
 	hostname := os.Getenv("HOSTNAME")
 	if hostname == "" {
@@ -35,6 +37,8 @@ func (c *ConsulClient) RegisterService(serviceID, serviceName, port string) erro
 		Name:    serviceName,
 		Address: hostname,
 		Port:    parsePort(port),
+		Tags:    []string{version},
+		Meta:    map[string]string{"version": version},
 		Check: &api.AgentServiceCheck{
 			HTTP:                           fmt.Sprintf("http://%s:%s/health", hostname, port),
 			Interval:                       "10s",
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/0Bleak/inventory-service/internal/models"
+	"github.com/0Bleak/inventory-service/internal/problem"
 	"github.com/0Bleak/inventory-service/internal/service"
 	"github.com/gorilla/mux"
 )
@@ -29,13 +30,13 @@ func (h *InventoryHandler) RegisterRoutes(router *mux.Router) {
 func (h *InventoryHandler) CreateInventory(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateInventoryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
 		return
 	}
 
 	inventory, err := h.service.CreateInventory(r.Context(), &req)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -48,7 +49,7 @@ func (h *InventoryHandler) GetInventory(w http.ResponseWriter, r *http.Request)
 
 	inventory, err := h.service.GetInventory(r.Context(), jarID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Inventory not found")
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -61,13 +62,13 @@ func (h *InventoryHandler) UpdateInventory(w http.ResponseWriter, r *http.Reques
 
 	var req models.UpdateInventoryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
 		return
 	}
 
 	inventory, err := h.service.UpdateInventory(r.Context(), jarID, &req)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -78,10 +79,6 @@ func (h *InventoryHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
-}
-
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 	w.Header().Set("Content-Type", "application/json")
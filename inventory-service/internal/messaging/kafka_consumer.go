@@ -4,9 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 
 	"github.com/0Bleak/inventory-service/internal/models"
+	"github.com/0Bleak/inventory-service/internal/requestid"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -45,17 +45,22 @@ func (c *kafkaConsumer) ConsumeOrderEvents(ctx context.Context, handler OrderEve
 			return fmt.Errorf("failed to read message: %w", err)
 		}
 
+		msgCtx, span := startProcessSpan(messageContext(ctx, msg), msg)
+
 		var orderEvent models.OrderEvent
 		if err := json.Unmarshal(msg.Value, &orderEvent); err != nil {
-			log.Printf("Failed to unmarshal order event: %v", err)
+			requestid.Printf(msgCtx, "Failed to unmarshal order event: %v", err)
+			endSpan(span, err)
 			continue
 		}
 
-		log.Printf("Received order event: %s for order %d", orderEvent.Type, orderEvent.OrderID)
+		requestid.Printf(msgCtx, "Received order event: %s for order %d", orderEvent.Type, orderEvent.OrderID)
 
-		if err := handler.HandleOrderEvent(ctx, &orderEvent); err != nil {
-			log.Printf("Failed to handle order event: %v", err)
+		err = handler.HandleOrderEvent(msgCtx, &orderEvent)
+		if err != nil {
+			requestid.Printf(msgCtx, "Failed to handle order event: %v", err)
 		}
+		endSpan(span, err)
 	}
 }
 
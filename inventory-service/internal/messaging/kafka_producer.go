@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/0Bleak/inventory-service/internal/models"
+	"github.com/0Bleak/inventory-service/internal/problem"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -35,26 +36,27 @@ func NewKafkaProducer(brokers []string, topic string) KafkaProducer {
 	}
 }
 
-func (p *kafkaProducer) PublishInventoryEvent(ctx context.Context, event *models.InventoryEvent) error {
+func (p *kafkaProducer) PublishInventoryEvent(ctx context.Context, event *models.InventoryEvent) (err error) {
+	ctx, span := startPublishSpan(ctx, p.writer.Topic, event.Type)
+	defer func() { endSpan(span, err) }()
+
 	eventJSON, err := json.Marshal(event)
 	if err != nil {
 		return fmt.Errorf("failed to marshal inventory event: %w", err)
 	}
 
 	message := kafka.Message{
-		Key:   []byte(event.JarID),
-		Value: eventJSON,
-		Time:  event.Timestamp,
-		Headers: []kafka.Header{
-			{Key: "event-type", Value: []byte(event.Type)},
-		},
+		Key:     []byte(event.JarID),
+		Value:   eventJSON,
+		Time:    event.Timestamp,
+		Headers: eventHeaders(ctx, event.Type),
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	if err := p.writer.WriteMessages(ctx, message); err != nil {
-		return fmt.Errorf("failed to write inventory event to kafka: %w", err)
+		return fmt.Errorf("failed to write inventory event to kafka: %w", problem.Unavailable("Event broker unavailable", err))
 	}
 
 	return nil
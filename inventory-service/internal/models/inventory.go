@@ -1,8 +1,9 @@
 package models
 
 import (
-	"errors"
 	"time"
+
+	"github.com/0Bleak/inventory-service/internal/problem"
 )
 
 type Inventory struct {
@@ -43,17 +44,17 @@ type OrderEvent struct {
 
 func (r *CreateInventoryRequest) Validate() error {
 	if r.JarID == "" {
-		return errors.New("jar_id is required")
+		return problem.Invalid("body.jar_id", "jar_id is required")
 	}
 	if r.Quantity < 0 {
-		return errors.New("quantity cannot be negative")
+		return problem.Invalid("body.quantity", "quantity cannot be negative")
 	}
 	return nil
 }
 
 func (r *UpdateInventoryRequest) Validate() error {
 	if r.Quantity < 0 {
-		return errors.New("quantity cannot be negative")
+		return problem.Invalid("body.quantity", "quantity cannot be negative")
 	}
 	return nil
 }
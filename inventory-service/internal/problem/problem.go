@@ -0,0 +1,153 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/0Bleak/inventory-service/internal/requestid"
+)
+
+// ContentType is the media type of problem documents (RFC 7807).
+const ContentType = "application/problem+json"
+
+// Kind classifies an error for clients. Each kind has one status and one
+// problem type URI.
+type Kind string
+
+const (
+	KindValidation       Kind = "validation"
+	KindUnauthorized     Kind = "unauthorized"
+	KindForbidden        Kind = "forbidden"
+	KindNotFound         Kind = "not-found"
+	KindConflict         Kind = "conflict"
+	KindMethodNotAllowed Kind = "method-not-allowed"
+	KindUnavailable      Kind = "upstream-unavailable"
+)
+
+var statuses = map[Kind]int{
+	KindValidation:       http.StatusBadRequest,
+	KindUnauthorized:     http.StatusUnauthorized,
+	KindForbidden:        http.StatusForbidden,
+	KindNotFound:         http.StatusNotFound,
+	KindConflict:         http.StatusConflict,
+	KindMethodNotAllowed: http.StatusMethodNotAllowed,
+	KindUnavailable:      http.StatusServiceUnavailable,
+}
+
+// FieldError points at one invalid input, e.g. body.quantity or query.limit.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a failure the client can act on. Message is shown to the client;
+// Err, the underlying cause, is only logged.
+type Error struct {
+	Kind    Kind
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status of the error's kind.
+func (e *Error) Status() int {
+	return statuses[e.Kind]
+}
+
+func Validation(message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Message: message, Fields: fields}
+}
+
+// Invalid reports a single invalid field.
+func Invalid(field, message string) *Error {
+	return Validation(message, FieldError{Field: field, Message: message})
+}
+
+func Unauthorized(message string) *Error {
+	return &Error{Kind: KindUnauthorized, Message: message}
+}
+
+func Forbidden(message string) *Error {
+	return &Error{Kind: KindForbidden, Message: message}
+}
+
+func NotFound(message string) *Error {
+	return &Error{Kind: KindNotFound, Message: message}
+}
+
+func Conflict(message string) *Error {
+	return &Error{Kind: KindConflict, Message: message}
+}
+
+// Unavailable reports that a dependency of the service failed.
+func Unavailable(message string, err error) *Error {
+	return &Error{Kind: KindUnavailable, Message: message, Err: err}
+}
+
+// Problem is an RFC 7807 problem document. RequestID lets clients quote the
+// request when reporting an error.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// TypeURI names the problem type of a kind.
+func TypeURI(kind Kind) string {
+	return "urn:clayjar:problem:" + string(kind)
+}
+
+// Write answers the request with the problem document for err. Errors of
+// unknown type are logged and reported as 500 without their message.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	problem := Problem{
+		Type:      "about:blank",
+		Status:    http.StatusInternalServerError,
+		Instance:  r.URL.Path,
+		RequestID: requestid.FromContext(r.Context()),
+	}
+
+	var e *Error
+	if errors.As(err, &e) && e.Status() != 0 {
+		if e.Err != nil {
+			requestid.Printf(r.Context(), "%s %s failed: %v", r.Method, r.URL.Path, err)
+		}
+		problem.Type = TypeURI(e.Kind)
+		problem.Status = e.Status()
+		problem.Detail = e.Message
+		problem.Errors = e.Fields
+	} else {
+		requestid.Printf(r.Context(), "%s %s failed: %v", r.Method, r.URL.Path, err)
+	}
+	problem.Title = http.StatusText(problem.Status)
+
+	body, _ := json.Marshal(problem)
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(problem.Status)
+	w.Write(body)
+}
+
+// RouteNotFound and MethodNotAllowed answer requests the router cannot match.
+func RouteNotFound(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, NotFound("No route for "+r.URL.Path))
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, &Error{Kind: KindMethodNotAllowed, Message: r.Method + " is not allowed on " + r.URL.Path})
+}
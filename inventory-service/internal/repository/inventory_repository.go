@@ -3,13 +3,28 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"time"
 
 	"github.com/0Bleak/inventory-service/internal/models"
+	"github.com/0Bleak/inventory-service/internal/problem"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+var (
+	ErrInventoryNotFound = problem.NotFound("Inventory not found")
+	ErrInventoryExists   = problem.Conflict("Inventory already exists for this jar")
+	ErrInsufficientStock = problem.Conflict("Insufficient stock")
+)
+
+// uniqueViolation is the PostgreSQL error code for duplicate keys.
+const uniqueViolation = "23505"
+
 type InventoryRepository interface {
 	Create(ctx context.Context, inventory *models.Inventory) error
 	FindByJarID(ctx context.Context, jarID string) (*models.Inventory, error)
@@ -44,8 +59,12 @@ func (r *inventoryRepository) Create(ctx context.Context, inventory *models.Inve
 		now,
 	).Scan(&inventory.ID)
 
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return ErrInventoryExists
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create inventory: %w", err)
+		return fmt.Errorf("failed to create inventory: %w", unavailable(err))
 	}
 
 	inventory.CreatedAt = now
@@ -59,10 +78,10 @@ func (r *inventoryRepository) FindByJarID(ctx context.Context, jarID string) (*m
 
 	err := r.db.GetContext(ctx, &inventory, query, jarID)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("inventory not found")
+		return nil, ErrInventoryNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find inventory: %w", err)
+		return nil, fmt.Errorf("failed to find inventory: %w", unavailable(err))
 	}
 
 	return &inventory, nil
@@ -77,7 +96,7 @@ func (r *inventoryRepository) Update(ctx context.Context, inventory *models.Inve
 
 	_, err := r.db.ExecContext(ctx, query, inventory.Quantity, inventory.Reserved, time.Now(), inventory.JarID)
 	if err != nil {
-		return fmt.Errorf("failed to update inventory: %w", err)
+		return fmt.Errorf("failed to update inventory: %w", unavailable(err))
 	}
 
 	return nil
@@ -92,16 +111,16 @@ func (r *inventoryRepository) ReserveStock(ctx context.Context, jarID string, qu
 
 	result, err := r.db.ExecContext(ctx, query, quantity, time.Now(), jarID)
 	if err != nil {
-		return fmt.Errorf("failed to reserve stock: %w", err)
+		return fmt.Errorf("failed to reserve stock: %w", unavailable(err))
 	}
 
 	rows, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get affected rows: %w", err)
+		return fmt.Errorf("failed to get affected rows: %w", unavailable(err))
 	}
 
 	if rows == 0 {
-		return fmt.Errorf("insufficient stock")
+		return ErrInsufficientStock
 	}
 
 	return nil
@@ -116,8 +135,32 @@ func (r *inventoryRepository) ReleaseStock(ctx context.Context, jarID string, qu
 
 	_, err := r.db.ExecContext(ctx, query, quantity, time.Now(), jarID)
 	if err != nil {
-		return fmt.Errorf("failed to release stock: %w", err)
+		return fmt.Errorf("failed to release stock: %w", unavailable(err))
 	}
 
 	return nil
 }
+
+// unavailable reports the errors meaning PostgreSQL could not be reached, or
+// did not answer in time, as an unavailable dependency. Other errors, including
+// nil, are returned as they are.
+func unavailable(err error) error {
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &pqErr):
+		// Connection exceptions, too many connections, shutdowns and
+		// cancelled statements.
+		switch pqErr.Code.Class() {
+		case "08", "53", "57":
+		default:
+			return err
+		}
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.As(err, &netErr):
+	default:
+		return err
+	}
+	return problem.Unavailable("Database unavailable", err)
+}
@@ -3,11 +3,11 @@ package service
 import (
 	"context"
 	"fmt"
-	"log"
 
 	"github.com/0Bleak/inventory-service/internal/messaging"
 	"github.com/0Bleak/inventory-service/internal/models"
 	"github.com/0Bleak/inventory-service/internal/repository"
+	"github.com/0Bleak/inventory-service/internal/requestid"
 )
 
 type InventoryService interface {
@@ -54,7 +54,7 @@ func (s *inventoryService) CreateInventory(ctx context.Context, req *models.Crea
 	}
 
 	if err := s.producer.PublishInventoryEvent(ctx, event); err != nil {
-		log.Printf("Failed to publish inventory created event: %v", err)
+		requestid.Printf(ctx, "Failed to publish inventory created event: %v", err)
 	}
 
 	return inventory, nil
@@ -90,24 +90,24 @@ func (s *inventoryService) UpdateInventory(ctx context.Context, jarID string, re
 	}
 
 	if err := s.producer.PublishInventoryEvent(ctx, event); err != nil {
-		log.Printf("Failed to publish inventory updated event: %v", err)
+		requestid.Printf(ctx, "Failed to publish inventory updated event: %v", err)
 	}
 
 	return inventory, nil
 }
 
 func (s *inventoryService) HandleOrderEvent(ctx context.Context, event *models.OrderEvent) error {
-	log.Printf("Handling order event: %s for order %d", event.Type, event.OrderID)
+	requestid.Printf(ctx, "Handling order event: %s for order %d", event.Type, event.OrderID)
 
 	switch event.Type {
 	case "order.created":
 		// Reserve stock
 		if err := s.repo.ReserveStock(ctx, event.JarID, event.Quantity); err != nil {
-			log.Printf("Failed to reserve stock: %v", err)
+			requestid.Printf(ctx, "Failed to reserve stock: %v", err)
 			return err
 		}
 
-		log.Printf("Reserved %d units of jar %s for order %d", event.Quantity, event.JarID, event.OrderID)
+		requestid.Printf(ctx, "Reserved %d units of jar %s for order %d", event.Quantity, event.JarID, event.OrderID)
 
 		// Publish inventory reserved event
 		inventoryEvent := &models.InventoryEvent{
@@ -118,18 +118,18 @@ func (s *inventoryService) HandleOrderEvent(ctx context.Context, event *models.O
 		}
 
 		if err := s.producer.PublishInventoryEvent(ctx, inventoryEvent); err != nil {
-			log.Printf("Failed to publish inventory reserved event: %v", err)
+			requestid.Printf(ctx, "Failed to publish inventory reserved event: %v", err)
 		}
 
 	case "order.status_updated":
 		if event.Status == "cancelled" {
 			// Release stock
 			if err := s.repo.ReleaseStock(ctx, event.JarID, event.Quantity); err != nil {
-				log.Printf("Failed to release stock: %v", err)
+				requestid.Printf(ctx, "Failed to release stock: %v", err)
 				return err
 			}
 
-			log.Printf("Released %d units of jar %s for cancelled order %d", event.Quantity, event.JarID, event.OrderID)
+			requestid.Printf(ctx, "Released %d units of jar %s for cancelled order %d", event.Quantity, event.JarID, event.OrderID)
 
 			// Publish inventory released event
 			inventoryEvent := &models.InventoryEvent{
@@ -140,7 +140,7 @@ func (s *inventoryService) HandleOrderEvent(ctx context.Context, event *models.O
 			}
 
 			if err := s.producer.PublishInventoryEvent(ctx, inventoryEvent); err != nil {
-				log.Printf("Failed to publish inventory released event: %v", err)
+				requestid.Printf(ctx, "Failed to publish inventory released event: %v", err)
 			}
 		}
 	}
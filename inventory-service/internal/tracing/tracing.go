@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Init installs the W3C trace context propagator and, when endpoint is set, a
+// tracer provider exporting spans over OTLP/HTTP to it. Without an endpoint no
+// spans are recorded, but trace context received on requests and events is
+// still passed on. The returned func flushes pending spans.
+func Init(ctx context.Context, service, version, endpoint string, sampleRatio float64) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, endpoint is the collector's base URL.
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	attributes := []attribute.KeyValue{semconv.ServiceName(service)}
+	if version != "" {
+		attributes = append(attributes, semconv.ServiceVersion(version))
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attributes...),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Follow the caller's sampling decision so traces are never cut in half.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a span for every request except health checks, named
+// after the matched route and continuing the caller's trace.
+func Middleware(service string) mux.MiddlewareFunc {
+	return otelmux.Middleware(service, otelmux.WithFilter(func(r *http.Request) bool {
+		return r.URL.Path != "/health"
+	}))
+}
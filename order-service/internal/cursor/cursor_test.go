@@ -0,0 +1,88 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerRoundTrip(t *testing.T) {
+	signer := NewSigner("secret")
+	createdAt := time.Date(2026, 3, 1, 12, 30, 0, 123456789, time.UTC)
+
+	for _, c := range []*Cursor{
+		{CreatedAt: createdAt, ID: "6650a1b2c3d4e5f601234567"},
+		{CreatedAt: createdAt, ID: "42", Before: true},
+	} {
+		got, err := signer.Decode(signer.Encode(c))
+		if err != nil {
+			t.Fatalf("Decode(Encode(%+v)) error = %v", c, err)
+		}
+		if !got.CreatedAt.Equal(c.CreatedAt) || got.ID != c.ID || got.Before != c.Before {
+			t.Fatalf("Decode(Encode(%+v)) = %+v", c, got)
+		}
+	}
+}
+
+func TestSignerRejectsTampering(t *testing.T) {
+	signer := NewSigner("secret")
+	token := signer.Encode(&Cursor{CreatedAt: time.Unix(1_700_000_000, 0).UTC(), ID: "42"})
+	payload, signature, _ := strings.Cut(token, ".")
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2026-01-01T00:00:00Z","id":"1"}`))
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2026-01-01T00:00:00Z"}`))
+
+	tests := map[string]string{
+		"empty":                "",
+		"no signature":         payload,
+		"empty signature":      payload + ".",
+		"signature not base64": payload + ".!!!",
+		"flipped signature":    payload + "." + flip(signature),
+		"edited payload":       forged + "." + signature,
+		"edited payload byte":  flip(payload) + "." + signature,
+		"other secret":         NewSigner("other").Encode(&Cursor{ID: "42"}),
+		"signed without id":    unsigned + "." + base64.RawURLEncoding.EncodeToString(signer.sign(unsigned)),
+		"signed non-JSON":      "bm90LWpzb24." + base64.RawURLEncoding.EncodeToString(signer.sign("bm90LWpzb24")),
+	}
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			if c, err := signer.Decode(token); !errors.Is(err, ErrInvalid) {
+				t.Fatalf("Decode() = %+v, %v, want ErrInvalid", c, err)
+			}
+		})
+	}
+}
+
+func TestSetLink(t *testing.T) {
+	tests := []struct {
+		name, next, prev, want string
+	}{
+		{"no pages", "", "", ""},
+		{"next only", "n1", "", `<?cursor=n1&limit=2&sort=-created_at>; rel="next"`},
+		{"both", "n1", "p1", `<?cursor=n1&limit=2&sort=-created_at>; rel="next", <?cursor=p1&limit=2&sort=-created_at>; rel="prev"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/list?limit=2&offset=4&sort=-created_at&cursor=old", nil)
+			SetLink(rec, r, tt.next, tt.prev)
+			if got := rec.Header().Get("Link"); got != tt.want {
+				t.Fatalf("Link = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// flip changes the first character of a base64url string.
+func flip(s string) string {
+	if s[0] == 'A' {
+		return "B" + s[1:]
+	}
+	return "A" + s[1:]
+}
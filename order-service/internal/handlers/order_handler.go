@@ -5,18 +5,25 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/0Bleak/order-service/internal/cursor"
 	"github.com/0Bleak/order-service/internal/models"
+	"github.com/0Bleak/order-service/internal/problem"
 	"github.com/0Bleak/order-service/internal/service"
 	"github.com/gorilla/mux"
 )
 
+// totalCountHeader counts every order, across pages.
+const totalCountHeader = "X-Total-Count"
+
 type OrderHandler struct {
 	service service.OrderService
+	cursors *cursor.Signer
 }
 
-func NewOrderHandler(service service.OrderService) *OrderHandler {
+func NewOrderHandler(service service.OrderService, cursors *cursor.Signer) *OrderHandler {
 	return &OrderHandler{
 		service: service,
+		cursors: cursors,
 	}
 }
 
@@ -31,13 +38,13 @@ func (h *OrderHandler) RegisterRoutes(router *mux.Router) {
 func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateOrderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
 		return
 	}
 
 	order, err := h.service.CreateOrder(r.Context(), &req)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -50,19 +57,22 @@ func (h *OrderHandler) GetOrderByID(w http.ResponseWriter, r *http.Request) {
 
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid order ID")
+		problem.Write(w, r, problem.Invalid("path.id", "Invalid order ID"))
 		return
 	}
 
 	order, err := h.service.GetOrderByID(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Order not found")
+		problem.Write(w, r, err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, order)
 }
 
+// GetAllOrders returns a page of orders, newest first. Pages are addressed by
+// offset or by the signed cursors sent in the Link header; the body stays a
+// plain array either way and the number of orders is sent in X-Total-Count.
 func (h *OrderHandler) GetAllOrders(w http.ResponseWriter, r *http.Request) {
 	limitStr := r.URL.Query().Get("limit")
 	offsetStr := r.URL.Query().Get("offset")
@@ -82,13 +92,31 @@ func (h *OrderHandler) GetAllOrders(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	orders, err := h.service.GetAllOrders(r.Context(), limit, offset)
+	var position *cursor.Cursor
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		var err error
+		if position, err = h.cursors.Decode(token); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+	}
+
+	page, err := h.service.GetAllOrders(r.Context(), limit, offset, position)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, orders)
+	var next, prev string
+	if page.Next != nil {
+		next = h.cursors.Encode(page.Next)
+	}
+	if page.Prev != nil {
+		prev = h.cursors.Encode(page.Prev)
+	}
+	cursor.SetLink(w, r, next, prev)
+	w.Header().Set(totalCountHeader, strconv.FormatInt(page.Total, 10))
+	respondWithJSON(w, http.StatusOK, page.Orders)
 }
 
 func (h *OrderHandler) GetOrdersByUserID(w http.ResponseWriter, r *http.Request) {
@@ -97,13 +125,13 @@ func (h *OrderHandler) GetOrdersByUserID(w http.ResponseWriter, r *http.Request)
 
 	userID, err := strconv.ParseInt(userIDStr, 10, 64)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		problem.Write(w, r, problem.Invalid("path.user_id", "Invalid user ID"))
 		return
 	}
 
 	orders, err := h.service.GetOrdersByUserID(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -114,10 +142,6 @@ func (h *OrderHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
-}
-
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 	w.Header().Set("Content-Type", "application/json")
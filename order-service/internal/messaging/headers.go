@@ -0,0 +1,53 @@
+package messaging
+
+import (
+	"context"
+
+	"github.com/0Bleak/order-service/internal/requestid"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+)
+
+// eventHeaders returns the headers of an event published while handling ctx:
+// its type, request, event and causation IDs, and the trace context. Events
+// published outside a request start a new chain.
+func eventHeaders(ctx context.Context, eventType string) []kafka.Header {
+	requestID := requestid.FromContext(ctx)
+	if requestID == "" {
+		requestID = requestid.New()
+	}
+
+	headers := []kafka.Header{
+		{Key: "event-type", Value: []byte(eventType)},
+		{Key: requestid.MessageHeader, Value: []byte(requestID)},
+		{Key: requestid.EventIDHeader, Value: []byte(requestid.New())},
+	}
+	if causationID := requestid.CausationFromContext(ctx); causationID != "" {
+		headers = append(headers, kafka.Header{Key: requestid.CausationHeader, Value: []byte(causationID)})
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{headers: &headers})
+	return headers
+}
+
+// messageContext restores the request ID of a consumed event and records the
+// event as the cause of any event published while handling it.
+func messageContext(ctx context.Context, msg kafka.Message) context.Context {
+	var requestID, eventID string
+	for _, header := range msg.Headers {
+		switch header.Key {
+		case requestid.MessageHeader:
+			requestID = string(header.Value)
+		case requestid.EventIDHeader:
+			eventID = string(header.Value)
+		}
+	}
+
+	if !requestid.Valid(requestID) {
+		requestID = requestid.New()
+	}
+	ctx = requestid.NewContext(ctx, requestID)
+	if requestid.Valid(eventID) {
+		ctx = requestid.WithCausation(ctx, eventID)
+	}
+	return ctx
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 
 	"github.com/0Bleak/order-service/internal/models"
+	"github.com/0Bleak/order-service/internal/requestid"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -45,17 +45,22 @@ func (c *kafkaConsumer) ConsumePaymentEvents(ctx context.Context, handler Paymen
 			return fmt.Errorf("failed to read message: %w", err)
 		}
 
+		msgCtx, span := startProcessSpan(messageContext(ctx, msg), msg)
+
 		var paymentEvent models.PaymentEvent
 		if err := json.Unmarshal(msg.Value, &paymentEvent); err != nil {
-			log.Printf("Failed to unmarshal payment event: %v", err)
+			requestid.Printf(msgCtx, "Failed to unmarshal payment event: %v", err)
+			endSpan(span, err)
 			continue
 		}
 
-		log.Printf("Received payment event: %s for order %d", paymentEvent.Type, paymentEvent.OrderID)
+		requestid.Printf(msgCtx, "Received payment event: %s for order %d", paymentEvent.Type, paymentEvent.OrderID)
 
-		if err := handler.HandlePaymentEvent(ctx, &paymentEvent); err != nil {
-			log.Printf("Failed to handle payment event: %v", err)
+		err = handler.HandlePaymentEvent(msgCtx, &paymentEvent)
+		if err != nil {
+			requestid.Printf(msgCtx, "Failed to handle payment event: %v", err)
 		}
+		endSpan(span, err)
 	}
 }
 
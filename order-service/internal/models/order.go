@@ -1,8 +1,10 @@
 package models
 
 import (
-	"errors"
 	"time"
+
+	"github.com/0Bleak/order-service/internal/cursor"
+	"github.com/0Bleak/order-service/internal/problem"
 )
 
 type Order struct {
@@ -16,6 +18,15 @@ type Order struct {
 	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"`
 }
 
+// OrderPage is one page of the order listing, newest first. Total counts
+// every order; Next and Prev locate the adjacent pages, if any.
+type OrderPage struct {
+	Orders []*Order
+	Total  int64
+	Next   *cursor.Cursor
+	Prev   *cursor.Cursor
+}
+
 type CreateOrderRequest struct {
 	UserID     int64   `json:"user_id"`
 	JarID      string  `json:"jar_id"`
@@ -43,16 +54,16 @@ type PaymentEvent struct {
 
 func (r *CreateOrderRequest) Validate() error {
 	if r.UserID <= 0 {
-		return errors.New("user_id is required")
+		return problem.Invalid("body.user_id", "user_id is required")
 	}
 	if r.JarID == "" {
-		return errors.New("jar_id is required")
+		return problem.Invalid("body.jar_id", "jar_id is required")
 	}
 	if r.Quantity <= 0 {
-		return errors.New("quantity must be positive")
+		return problem.Invalid("body.quantity", "quantity must be positive")
 	}
 	if r.TotalPrice <= 0 {
-		return errors.New("total_price must be positive")
+		return problem.Invalid("body.total_price", "total_price must be positive")
 	}
 	return nil
 }
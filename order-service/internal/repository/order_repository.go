@@ -3,17 +3,28 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strconv"
 	"time"
 
+	"github.com/0Bleak/order-service/internal/cursor"
 	"github.com/0Bleak/order-service/internal/models"
+	"github.com/0Bleak/order-service/internal/problem"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+var ErrOrderNotFound = problem.NotFound("Order not found")
+
 type OrderRepository interface {
 	Create(ctx context.Context, order *models.Order) error
 	FindByID(ctx context.Context, id int64) (*models.Order, error)
-	FindAll(ctx context.Context, limit, offset int64) ([]*models.Order, error)
+	FindAll(ctx context.Context, limit, offset int64, position *cursor.Cursor) ([]*models.Order, error)
+	Count(ctx context.Context) (int64, error)
 	FindByUserID(ctx context.Context, userID int64) ([]*models.Order, error)
 	UpdateStatus(ctx context.Context, id int64, status string) error
 }
@@ -47,7 +58,7 @@ func (r *orderRepository) Create(ctx context.Context, order *models.Order) error
 	).Scan(&order.ID)
 
 	if err != nil {
-		return fmt.Errorf("failed to create order: %w", err)
+		return fmt.Errorf("failed to create order: %w", unavailable(err))
 	}
 
 	order.CreatedAt = now
@@ -61,28 +72,69 @@ func (r *orderRepository) FindByID(ctx context.Context, id int64) (*models.Order
 
 	err := r.db.GetContext(ctx, &order, query, id)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("order not found")
+		return nil, ErrOrderNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find order: %w", err)
+		return nil, fmt.Errorf("failed to find order: %w", unavailable(err))
 	}
 
 	return &order, nil
 }
 
-func (r *orderRepository) FindAll(ctx context.Context, limit, offset int64) ([]*models.Order, error) {
+// FindAll returns orders newest first, skipping offset orders or, when
+// position is set, starting next to it.
+func (r *orderRepository) FindAll(ctx context.Context, limit, offset int64, position *cursor.Cursor) ([]*models.Order, error) {
+	if position != nil {
+		return r.findFrom(ctx, limit, position)
+	}
+
 	var orders []*models.Order
 	query := `SELECT id, user_id, jar_id, quantity, total_price, status, created_at, updated_at 
-	          FROM orders ORDER BY created_at DESC LIMIT $1 OFFSET $2`
+	          FROM orders ORDER BY created_at DESC, id DESC LIMIT $1 OFFSET $2`
 
 	err := r.db.SelectContext(ctx, &orders, query, limit, offset)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find orders: %w", err)
+		return nil, fmt.Errorf("failed to find orders: %w", unavailable(err))
+	}
+
+	return orders, nil
+}
+
+func (r *orderRepository) findFrom(ctx context.Context, limit int64, position *cursor.Cursor) ([]*models.Order, error) {
+	id, err := strconv.ParseInt(position.ID, 10, 64)
+	if err != nil {
+		return nil, cursor.ErrInvalid
+	}
+
+	var orders []*models.Order
+	query := `SELECT id, user_id, jar_id, quantity, total_price, status, created_at, updated_at 
+	          FROM orders WHERE (created_at, id) < ($1, $2) ORDER BY created_at DESC, id DESC LIMIT $3`
+	if position.Before {
+		// Read the preceding page backwards, then turn it around.
+		query = `SELECT id, user_id, jar_id, quantity, total_price, status, created_at, updated_at 
+		         FROM orders WHERE (created_at, id) > ($1, $2) ORDER BY created_at ASC, id ASC LIMIT $3`
+	}
+
+	if err := r.db.SelectContext(ctx, &orders, query, position.CreatedAt, id, limit); err != nil {
+		return nil, fmt.Errorf("failed to find orders: %w", unavailable(err))
 	}
 
+	if position.Before {
+		for i, j := 0, len(orders)-1; i < j; i, j = i+1, j-1 {
+			orders[i], orders[j] = orders[j], orders[i]
+		}
+	}
 	return orders, nil
 }
 
+func (r *orderRepository) Count(ctx context.Context) (int64, error) {
+	var count int64
+	if err := r.db.GetContext(ctx, &count, `SELECT COUNT(*) FROM orders`); err != nil {
+		return 0, fmt.Errorf("failed to count orders: %w", unavailable(err))
+	}
+	return count, nil
+}
+
 func (r *orderRepository) FindByUserID(ctx context.Context, userID int64) ([]*models.Order, error) {
 	var orders []*models.Order
 	query := `SELECT id, user_id, jar_id, quantity, total_price, status, created_at, updated_at 
@@ -90,7 +142,7 @@ func (r *orderRepository) FindByUserID(ctx context.Context, userID int64) ([]*mo
 
 	err := r.db.SelectContext(ctx, &orders, query, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find orders by user: %w", err)
+		return nil, fmt.Errorf("failed to find orders by user: %w", unavailable(err))
 	}
 
 	return orders, nil
@@ -101,8 +153,32 @@ func (r *orderRepository) UpdateStatus(ctx context.Context, id int64, status str
 
 	_, err := r.db.ExecContext(ctx, query, status, time.Now(), id)
 	if err != nil {
-		return fmt.Errorf("failed to update order status: %w", err)
+		return fmt.Errorf("failed to update order status: %w", unavailable(err))
 	}
 
 	return nil
 }
+
+// unavailable reports the errors meaning PostgreSQL could not be reached, or
+// did not answer in time, as an unavailable dependency. Other errors, including
+// nil, are returned as they are.
+func unavailable(err error) error {
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &pqErr):
+		// Connection exceptions, too many connections, shutdowns and
+		// cancelled statements.
+		switch pqErr.Code.Class() {
+		case "08", "53", "57":
+		default:
+			return err
+		}
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.As(err, &netErr):
+	default:
+		return err
+	}
+	return problem.Unavailable("Database unavailable", err)
+}
@@ -3,17 +3,20 @@ package service
 import (
 	"context"
 	"fmt"
-	"log"
+	"strconv"
 
+	"github.com/0Bleak/order-service/internal/cursor"
 	"github.com/0Bleak/order-service/internal/messaging"
 	"github.com/0Bleak/order-service/internal/models"
+	"github.com/0Bleak/order-service/internal/problem"
 	"github.com/0Bleak/order-service/internal/repository"
+	"github.com/0Bleak/order-service/internal/requestid"
 )
 
 type OrderService interface {
 	CreateOrder(ctx context.Context, req *models.CreateOrderRequest) (*models.Order, error)
 	GetOrderByID(ctx context.Context, id int64) (*models.Order, error)
-	GetAllOrders(ctx context.Context, limit, offset int64) ([]*models.Order, error)
+	GetAllOrders(ctx context.Context, limit, offset int64, position *cursor.Cursor) (*models.OrderPage, error)
 	GetOrdersByUserID(ctx context.Context, userID int64) ([]*models.Order, error)
 	HandlePaymentEvent(ctx context.Context, event *models.PaymentEvent) error
 }
@@ -59,7 +62,7 @@ func (s *orderService) CreateOrder(ctx context.Context, req *models.CreateOrderR
 	}
 
 	if err := s.producer.PublishOrderEvent(ctx, event); err != nil {
-		log.Printf("Failed to publish order created event: %v", err)
+		requestid.Printf(ctx, "Failed to publish order created event: %v", err)
 	}
 
 	return order, nil
@@ -69,7 +72,7 @@ func (s *orderService) GetOrderByID(ctx context.Context, id int64) (*models.Orde
 	return s.repo.FindByID(ctx, id)
 }
 
-func (s *orderService) GetAllOrders(ctx context.Context, limit, offset int64) ([]*models.Order, error) {
+func (s *orderService) GetAllOrders(ctx context.Context, limit, offset int64, position *cursor.Cursor) (*models.OrderPage, error) {
 	if limit <= 0 {
 		limit = 10
 	}
@@ -79,8 +82,41 @@ func (s *orderService) GetAllOrders(ctx context.Context, limit, offset int64) ([
 	if offset < 0 {
 		offset = 0
 	}
+	if position != nil && offset > 0 {
+		return nil, problem.Invalid("query.cursor", "A cursor cannot be combined with an offset")
+	}
+
+	// One extra order tells whether another page follows.
+	orders, err := s.repo.FindAll(ctx, limit+1, offset, position)
+	if err != nil {
+		return nil, err
+	}
+
+	backward := position != nil && position.Before
+	more := int64(len(orders)) > limit
+	if more && backward {
+		orders = orders[1:]
+	} else if more {
+		orders = orders[:limit]
+	}
+
+	total, err := s.repo.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.OrderPage{Orders: orders, Total: total}
+	if len(orders) > 0 {
+		first, last := orders[0], orders[len(orders)-1]
+		if more || backward {
+			page.Next = &cursor.Cursor{CreatedAt: last.CreatedAt, ID: strconv.FormatInt(last.ID, 10)}
+		}
+		if (more && backward) || (!backward && (position != nil || offset > 0)) {
+			page.Prev = &cursor.Cursor{CreatedAt: first.CreatedAt, ID: strconv.FormatInt(first.ID, 10), Before: true}
+		}
+	}
 
-	return s.repo.FindAll(ctx, limit, offset)
+	return page, nil
 }
 
 func (s *orderService) GetOrdersByUserID(ctx context.Context, userID int64) ([]*models.Order, error) {
@@ -88,7 +124,7 @@ func (s *orderService) GetOrdersByUserID(ctx context.Context, userID int64) ([]*
 }
 
 func (s *orderService) HandlePaymentEvent(ctx context.Context, event *models.PaymentEvent) error {
-	log.Printf("Handling payment event: %s for order %d", event.Type, event.OrderID)
+	requestid.Printf(ctx, "Handling payment event: %s for order %d", event.Type, event.OrderID)
 
 	var newStatus string
 	switch event.Type {
@@ -121,7 +157,7 @@ func (s *orderService) HandlePaymentEvent(ctx context.Context, event *models.Pay
 	}
 
 	if err := s.producer.PublishOrderEvent(ctx, orderEvent); err != nil {
-		log.Printf("Failed to publish order status updated event: %v", err)
+		requestid.Printf(ctx, "Failed to publish order status updated event: %v", err)
 	}
 
 	return nil
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/order-service/internal/cursor"
+	"github.com/0Bleak/order-service/internal/models"
+	"github.com/0Bleak/order-service/internal/repository"
+)
+
+// pagingRepository serves FindAll from memory with the keyset semantics of
+// the SQL queries: newest first by (created_at, id), reading the page before
+// a cursor in ascending order. The tests using it cover the service's cursor
+// bookkeeping only; the SQL itself is not run here.
+type pagingRepository struct {
+	repository.OrderRepository
+	orders []*models.Order
+}
+
+func (r *pagingRepository) FindAll(ctx context.Context, limit, offset int64, position *cursor.Cursor) ([]*models.Order, error) {
+	orders := append([]*models.Order(nil), r.orders...)
+	sort.Slice(orders, func(i, j int) bool { return newer(orders[i], orders[j]) })
+
+	if position != nil {
+		at := &models.Order{CreatedAt: position.CreatedAt}
+		at.ID, _ = strconv.ParseInt(position.ID, 10, 64)
+
+		var selected []*models.Order
+		for _, order := range orders {
+			if (!position.Before && newer(at, order)) || (position.Before && newer(order, at)) {
+				selected = append(selected, order)
+			}
+		}
+		if position.Before {
+			// The closest orders come last when newest first.
+			selected = selected[max(len(selected)-int(limit), 0):]
+			return selected, nil
+		}
+		orders, offset = selected, 0
+	}
+
+	if offset >= int64(len(orders)) {
+		return nil, nil
+	}
+	orders = orders[offset:]
+	return orders[:min(int64(len(orders)), limit)], nil
+}
+
+func (r *pagingRepository) Count(ctx context.Context) (int64, error) {
+	return int64(len(r.orders)), nil
+}
+
+func newer(a, b *models.Order) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
+
+// tiedOrders share their creation time in runs, so pages split ties.
+func tiedOrders() []*models.Order {
+	t0 := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	times := []time.Time{t0, t0, t0, t0.Add(time.Second), t0.Add(time.Second), t0.Add(2 * time.Second), t0.Add(2 * time.Second)}
+
+	orders := make([]*models.Order, len(times))
+	for i, createdAt := range times {
+		orders[i] = &models.Order{ID: int64(i + 1), CreatedAt: createdAt}
+	}
+	return orders
+}
+
+func ids(orders []*models.Order) []int64 {
+	ids := make([]int64, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	return ids
+}
+
+func TestGetAllOrdersCursorBookkeeping(t *testing.T) {
+	tests := []struct {
+		limit int64
+		pages [][]int64
+	}{
+		{limit: 2, pages: [][]int64{{7, 6}, {5, 4}, {3, 2}, {1}}},
+		{limit: 3, pages: [][]int64{{7, 6, 5}, {4, 3, 2}, {1}}},
+		{limit: 7, pages: [][]int64{{7, 6, 5, 4, 3, 2, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run("limit "+strconv.FormatInt(tt.limit, 10), func(t *testing.T) {
+			svc := NewOrderService(&pagingRepository{orders: tiedOrders()}, nil)
+			ctx := context.Background()
+
+			// Forward through Next.
+			var forward []*models.OrderPage
+			page, err := svc.GetAllOrders(ctx, tt.limit, 0, nil)
+			for {
+				if err != nil {
+					t.Fatalf("GetAllOrders() error = %v", err)
+				}
+				if page.Total != 7 {
+					t.Fatalf("Total = %d, want 7", page.Total)
+				}
+				forward = append(forward, page)
+				if page.Next == nil {
+					break
+				}
+				page, err = svc.GetAllOrders(ctx, tt.limit, 0, page.Next)
+			}
+
+			if len(forward) != len(tt.pages) {
+				t.Fatalf("got %d pages, want %d", len(forward), len(tt.pages))
+			}
+			for i, page := range forward {
+				if got := ids(page.Orders); !reflect.DeepEqual(got, tt.pages[i]) {
+					t.Fatalf("forward page %d = %v, want %v", i, got, tt.pages[i])
+				}
+			}
+			if forward[0].Prev != nil {
+				t.Fatal("first page has a prev cursor")
+			}
+
+			// Back through Prev from the last page.
+			page = forward[len(forward)-1]
+			for i := len(tt.pages) - 2; i >= 0; i-- {
+				if page.Prev == nil {
+					t.Fatalf("page %d has no prev cursor", i+1)
+				}
+				if page, err = svc.GetAllOrders(ctx, tt.limit, 0, page.Prev); err != nil {
+					t.Fatalf("GetAllOrders() error = %v", err)
+				}
+				if got := ids(page.Orders); !reflect.DeepEqual(got, tt.pages[i]) {
+					t.Fatalf("backward page %d = %v, want %v", i, got, tt.pages[i])
+				}
+				if page.Next == nil {
+					t.Fatalf("backward page %d has no next cursor", i)
+				}
+			}
+			if page.Prev != nil {
+				t.Fatal("first page reached backwards has a prev cursor")
+			}
+		})
+	}
+}
+
+func TestGetAllOrdersCursorBookkeepingPrevFromOffset(t *testing.T) {
+	svc := NewOrderService(&pagingRepository{orders: tiedOrders()}, nil)
+	ctx := context.Background()
+
+	page, err := svc.GetAllOrders(ctx, 2, 3, nil)
+	if err != nil {
+		t.Fatalf("GetAllOrders() error = %v", err)
+	}
+	if got := ids(page.Orders); !reflect.DeepEqual(got, []int64{4, 3}) {
+		t.Fatalf("page at offset 3 = %v, want [4 3]", got)
+	}
+	if page.Prev == nil {
+		t.Fatal("page at an offset has no prev cursor")
+	}
+
+	prev, err := svc.GetAllOrders(ctx, 2, 0, page.Prev)
+	if err != nil {
+		t.Fatalf("GetAllOrders() error = %v", err)
+	}
+	if got := ids(prev.Orders); !reflect.DeepEqual(got, []int64{6, 5}) {
+		t.Fatalf("page before = %v, want [6 5]", got)
+	}
+	if prev.Prev == nil {
+		t.Fatal("page before has no prev cursor although order 7 precedes it")
+	}
+}
+
+func TestGetAllOrdersRejectsCursorWithOffset(t *testing.T) {
+	svc := NewOrderService(&pagingRepository{orders: tiedOrders()}, nil)
+	position := &cursor.Cursor{CreatedAt: time.Now(), ID: "3"}
+
+	if _, err := svc.GetAllOrders(context.Background(), 2, 2, position); err == nil {
+		t.Fatal("GetAllOrders() with a cursor and an offset succeeded")
+	}
+}
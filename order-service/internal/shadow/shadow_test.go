@@ -0,0 +1,51 @@
+package shadow
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		shadow       bool
+		acceptWrites bool
+		status       int
+	}{
+		{"primary write", http.MethodPost, false, false, http.StatusNoContent},
+		{"shadow read", http.MethodGet, true, false, http.StatusNoContent},
+		{"shadow HEAD", http.MethodHead, true, false, http.StatusNoContent},
+		{"shadow OPTIONS", http.MethodOptions, true, false, http.StatusNoContent},
+		{"shadow POST", http.MethodPost, true, false, http.StatusForbidden},
+		{"shadow PUT", http.MethodPut, true, false, http.StatusForbidden},
+		{"shadow PATCH", http.MethodPatch, true, false, http.StatusForbidden},
+		{"shadow DELETE", http.MethodDelete, true, false, http.StatusForbidden},
+		{"shadow write on an isolated instance", http.MethodPost, true, true, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			handler := Middleware(tt.acceptWrites)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			r := httptest.NewRequest(tt.method, "/resource", nil)
+			if tt.shadow {
+				r.Header.Set(Header, "true")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if reached != (tt.status == http.StatusNoContent) {
+				t.Fatalf("handler reached = %v with status %d", reached, rec.Code)
+			}
+		})
+	}
+}
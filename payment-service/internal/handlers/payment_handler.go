@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/0Bleak/payment-service/internal/models"
+	"github.com/0Bleak/payment-service/internal/problem"
 	"github.com/0Bleak/payment-service/internal/service"
 	"github.com/gorilla/mux"
 )
@@ -30,13 +31,13 @@ func (h *PaymentHandler) RegisterRoutes(router *mux.Router) {
 func (h *PaymentHandler) CreatePayment(w http.ResponseWriter, r *http.Request) {
 	var req models.CreatePaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
 		return
 	}
 
 	payment, err := h.service.CreatePayment(r.Context(), &req)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -49,13 +50,13 @@ func (h *PaymentHandler) GetPaymentByID(w http.ResponseWriter, r *http.Request)
 
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid payment ID")
+		problem.Write(w, r, problem.Invalid("path.id", "Invalid payment ID"))
 		return
 	}
 
 	payment, err := h.service.GetPaymentByID(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Payment not found")
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -68,13 +69,13 @@ func (h *PaymentHandler) GetPaymentByOrderID(w http.ResponseWriter, r *http.Requ
 
 	orderID, err := strconv.ParseInt(orderIDStr, 10, 64)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid order ID")
+		problem.Write(w, r, problem.Invalid("path.order_id", "Invalid order ID"))
 		return
 	}
 
 	payment, err := h.service.GetPaymentByOrderID(r.Context(), orderID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Payment not found")
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -85,10 +86,6 @@ func (h *PaymentHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
-}
-
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 	w.Header().Set("Content-Type", "application/json")
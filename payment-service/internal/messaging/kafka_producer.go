@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/0Bleak/payment-service/internal/models"
+	"github.com/0Bleak/payment-service/internal/problem"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -35,26 +36,27 @@ func NewKafkaProducer(brokers []string, topic string) KafkaProducer {
 	}
 }
 
-func (p *kafkaProducer) PublishPaymentEvent(ctx context.Context, event *models.PaymentEvent) error {
+func (p *kafkaProducer) PublishPaymentEvent(ctx context.Context, event *models.PaymentEvent) (err error) {
+	ctx, span := startPublishSpan(ctx, p.writer.Topic, event.Type)
+	defer func() { endSpan(span, err) }()
+
 	eventJSON, err := json.Marshal(event)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payment event: %w", err)
 	}
 
 	message := kafka.Message{
-		Key:   []byte(fmt.Sprintf("%d", event.PaymentID)),
-		Value: eventJSON,
-		Time:  event.Timestamp,
-		Headers: []kafka.Header{
-			{Key: "event-type", Value: []byte(event.Type)},
-		},
+		Key:     []byte(fmt.Sprintf("%d", event.PaymentID)),
+		Value:   eventJSON,
+		Time:    event.Timestamp,
+		Headers: eventHeaders(ctx, event.Type),
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	if err := p.writer.WriteMessages(ctx, message); err != nil {
-		return fmt.Errorf("failed to write payment event to kafka: %w", err)
+		return fmt.Errorf("failed to write payment event to kafka: %w", problem.Unavailable("Event broker unavailable", err))
 	}
 
 	return nil
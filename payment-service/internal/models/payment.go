@@ -1,8 +1,9 @@
 package models
 
 import (
-	"errors"
 	"time"
+
+	"github.com/0Bleak/payment-service/internal/problem"
 )
 
 type Payment struct {
@@ -43,13 +44,13 @@ type OrderEvent struct {
 
 func (r *CreatePaymentRequest) Validate() error {
 	if r.OrderID <= 0 {
-		return errors.New("order_id is required")
+		return problem.Invalid("body.order_id", "order_id is required")
 	}
 	if r.Amount <= 0 {
-		return errors.New("amount must be positive")
+		return problem.Invalid("body.amount", "amount must be positive")
 	}
 	if r.PaymentMethod == "" {
-		return errors.New("payment_method is required")
+		return problem.Invalid("body.payment_method", "payment_method is required")
 	}
 	return nil
 }
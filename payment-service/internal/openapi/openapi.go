@@ -0,0 +1,182 @@
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/0Bleak/payment-service/internal/problem"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// ServeSpec serves the service's OpenAPI document.
+func ServeSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(spec)
+}
+
+// Validator checks requests, and optionally responses, against the service's
+// OpenAPI document.
+type Validator struct {
+	router            routers.Router
+	validateResponses bool
+}
+
+func init() {
+	// Only date and date-time are checked out of the box.
+	openapi3.DefineStringFormatValidator("email", openapi3.NewRegexpFormatValidator(openapi3.FormatOfStringForEmail))
+}
+
+// NewValidator loads the embedded document. Responses that do not match it
+// are logged when validateResponses is set; they are still sent.
+func NewValidator(validateResponses bool) (*Validator, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load openapi document: %w", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid openapi document: %w", err)
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to route openapi document: %w", err)
+	}
+
+	return &Validator{
+		router:            router,
+		validateResponses: validateResponses,
+	}, nil
+}
+
+// Middleware rejects requests whose parameters or body do not match their
+// operation with a validation problem listing the fields at fault. Requests for paths the document
+// does not describe are passed through. Authentication is left to the
+// handlers.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := v.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			problem.Write(w, r, problem.Validation("Invalid request", fieldErrors(err)...))
+			return
+		}
+
+		if !v.validateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rec.status,
+			Header:                 rec.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+			Options:                &openapi3filter.Options{MultiError: true, IncludeResponseStatus: true},
+		})
+		if err != nil {
+			log.Printf("Response to %s %s does not match the openapi document: %v", r.Method, route.Path, fieldErrors(err))
+		}
+	})
+}
+
+// fieldErrors flattens a validation error into one entry per offending field.
+func fieldErrors(err error) []problem.FieldError {
+	switch err := err.(type) {
+	case openapi3.MultiError:
+		var fields []problem.FieldError
+		for _, err := range err {
+			fields = append(fields, fieldErrors(err)...)
+		}
+		return fields
+
+	case *openapi3filter.RequestError:
+		field := "body"
+		if err.Parameter != nil {
+			field = err.Parameter.In + "." + err.Parameter.Name
+		}
+
+		switch inner := err.Err.(type) {
+		case openapi3.MultiError, *openapi3.SchemaError:
+			fields := fieldErrors(inner)
+			for i := range fields {
+				fields[i].Field = joinField(field, fields[i].Field)
+			}
+			return fields
+		}
+
+		message := err.Reason
+		if message == "" && err.Err != nil {
+			message = err.Err.Error()
+		}
+		return []problem.FieldError{{Field: field, Message: message}}
+
+	case *openapi3.SchemaError:
+		return []problem.FieldError{{Field: strings.Join(err.JSONPointer(), "."), Message: err.Reason}}
+
+	case *openapi3filter.ResponseError:
+		if err.Err != nil {
+			fields := fieldErrors(err.Err)
+			for i := range fields {
+				fields[i].Field = joinField("response", fields[i].Field)
+			}
+			return fields
+		}
+		return []problem.FieldError{{Field: "response", Message: err.Reason}}
+	}
+
+	return []problem.FieldError{{Message: err.Error()}}
+}
+
+func joinField(prefix, field string) string {
+	if field == "" {
+		return prefix
+	}
+	return prefix + "." + field
+}
+
+// responseRecorder keeps a copy of the response for validation while it is
+// written to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
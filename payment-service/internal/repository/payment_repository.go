@@ -3,13 +3,21 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"time"
 
 	"github.com/0Bleak/payment-service/internal/models"
+	"github.com/0Bleak/payment-service/internal/problem"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+var ErrPaymentNotFound = problem.NotFound("Payment not found")
+
 type PaymentRepository interface {
 	Create(ctx context.Context, payment *models.Payment) error
 	FindByID(ctx context.Context, id int64) (*models.Payment, error)
@@ -46,7 +54,7 @@ func (r *paymentRepository) Create(ctx context.Context, payment *models.Payment)
 	).Scan(&payment.ID)
 
 	if err != nil {
-		return fmt.Errorf("failed to create payment: %w", err)
+		return fmt.Errorf("failed to create payment: %w", unavailable(err))
 	}
 
 	payment.CreatedAt = now
@@ -61,10 +69,10 @@ func (r *paymentRepository) FindByID(ctx context.Context, id int64) (*models.Pay
 
 	err := r.db.GetContext(ctx, &payment, query, id)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("payment not found")
+		return nil, ErrPaymentNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find payment: %w", err)
+		return nil, fmt.Errorf("failed to find payment: %w", unavailable(err))
 	}
 
 	return &payment, nil
@@ -77,10 +85,10 @@ func (r *paymentRepository) FindByOrderID(ctx context.Context, orderID int64) (*
 
 	err := r.db.GetContext(ctx, &payment, query, orderID)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("payment not found")
+		return nil, ErrPaymentNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find payment: %w", err)
+		return nil, fmt.Errorf("failed to find payment: %w", unavailable(err))
 	}
 
 	return &payment, nil
@@ -91,8 +99,32 @@ func (r *paymentRepository) UpdateStatus(ctx context.Context, id int64, status,
 
 	_, err := r.db.ExecContext(ctx, query, status, transactionID, time.Now(), id)
 	if err != nil {
-		return fmt.Errorf("failed to update payment status: %w", err)
+		return fmt.Errorf("failed to update payment status: %w", unavailable(err))
 	}
 
 	return nil
 }
+
+// unavailable reports the errors meaning PostgreSQL could not be reached, or
+// did not answer in time, as an unavailable dependency. Other errors, including
+// nil, are returned as they are.
+func unavailable(err error) error {
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &pqErr):
+		// Connection exceptions, too many connections, shutdowns and
+		// cancelled statements.
+		switch pqErr.Code.Class() {
+		case "08", "53", "57":
+		default:
+			return err
+		}
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.As(err, &netErr):
+	default:
+		return err
+	}
+	return problem.Unavailable("Database unavailable", err)
+}
@@ -2,14 +2,16 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"time"
 
 	"github.com/0Bleak/payment-service/internal/messaging"
 	"github.com/0Bleak/payment-service/internal/models"
+	"github.com/0Bleak/payment-service/internal/problem"
 	"github.com/0Bleak/payment-service/internal/repository"
+	"github.com/0Bleak/payment-service/internal/requestid"
 	"github.com/google/uuid"
 )
 
@@ -37,6 +39,13 @@ func (s *paymentService) CreatePayment(ctx context.Context, req *models.CreatePa
 		return nil, fmt.Errorf("validation failed: %w", err)
 	}
 
+	// An order is paid at most once
+	if _, err := s.repo.FindByOrderID(ctx, req.OrderID); err == nil {
+		return nil, problem.Conflict(fmt.Sprintf("Order %d already has a payment", req.OrderID))
+	} else if !errors.Is(err, repository.ErrPaymentNotFound) {
+		return nil, err
+	}
+
 	payment := &models.Payment{
 		OrderID:       req.OrderID,
 		Amount:        req.Amount,
@@ -50,7 +59,7 @@ func (s *paymentService) CreatePayment(ctx context.Context, req *models.CreatePa
 	}
 
 	// Simulate payment processing
-	go s.processPayment(context.Background(), payment)
+	go s.processPayment(context.WithoutCancel(ctx), payment)
 
 	return payment, nil
 }
@@ -64,13 +73,13 @@ func (s *paymentService) GetPaymentByOrderID(ctx context.Context, orderID int64)
 }
 
 func (s *paymentService) HandleOrderEvent(ctx context.Context, event *models.OrderEvent) error {
-	log.Printf("Handling order event: %s for order %d", event.Type, event.OrderID)
+	requestid.Printf(ctx, "Handling order event: %s for order %d", event.Type, event.OrderID)
 
 	if event.Type == "order.created" {
 		// Check if payment already exists for this order
 		existingPayment, _ := s.repo.FindByOrderID(ctx, event.OrderID)
 		if existingPayment != nil {
-			log.Printf("Payment already exists for order %d", event.OrderID)
+			requestid.Printf(ctx, "Payment already exists for order %d", event.OrderID)
 			return nil
 		}
 
@@ -88,10 +97,10 @@ func (s *paymentService) HandleOrderEvent(ctx context.Context, event *models.Ord
 			return fmt.Errorf("failed to create payment: %w", err)
 		}
 
-		log.Printf("Created payment %d for order %d", payment.ID, event.OrderID)
+		requestid.Printf(ctx, "Created payment %d for order %d", payment.ID, event.OrderID)
 
 		// Simulate payment processing
-		go s.processPayment(context.Background(), payment)
+		go s.processPayment(context.WithoutCancel(ctx), payment)
 	}
 
 	return nil
@@ -113,16 +122,16 @@ func (s *paymentService) processPayment(ctx context.Context, payment *models.Pay
 	if success {
 		status = "completed"
 		eventType = "payment.completed"
-		log.Printf("Payment %d processed successfully", payment.ID)
+		requestid.Printf(ctx, "Payment %d processed successfully", payment.ID)
 	} else {
 		status = "failed"
 		eventType = "payment.failed"
-		log.Printf("Payment %d failed", payment.ID)
+		requestid.Printf(ctx, "Payment %d failed", payment.ID)
 	}
 
 	// Update payment status
 	if err := s.repo.UpdateStatus(ctx, payment.ID, status, transactionID); err != nil {
-		log.Printf("Failed to update payment status: %v", err)
+		requestid.Printf(ctx, "Failed to update payment status: %v", err)
 		return
 	}
 
@@ -137,6 +146,6 @@ func (s *paymentService) processPayment(ctx context.Context, payment *models.Pay
 	}
 
 	if err := s.producer.PublishPaymentEvent(ctx, event); err != nil {
-		log.Printf("Failed to publish payment event: %v", err)
+		requestid.Printf(ctx, "Failed to publish payment event: %v", err)
 	}
 }
@@ -0,0 +1,34 @@
+package shadow
+
+import (
+	"net/http"
+
+	"github.com/0Bleak/payment-service/internal/problem"
+)
+
+// Header marks requests the gateway mirrored to a shadow service. Their
+// responses are discarded, so they must not change shared state.
+const Header = "X-Shadow-Request"
+
+// Middleware rejects mirrored writes before they reach a handler, so a shadow
+// sharing the primary's database and topics cannot repeat them. Instances
+// running apart from the primary pass acceptWrites to serve them normally.
+func Middleware(acceptWrites bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !acceptWrites && r.Header.Get(Header) != "" && !safe(r.Method) {
+				problem.Write(w, r, problem.Forbidden("Shadow requests cannot change state on this instance"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func safe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
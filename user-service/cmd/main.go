@@ -14,11 +14,18 @@ import (
 	"github.com/0Bleak/user-service/internal/discovery"
 	"github.com/0Bleak/user-service/internal/handlers"
 	"github.com/0Bleak/user-service/internal/middleware"
+	"github.com/0Bleak/user-service/internal/openapi"
+	"github.com/0Bleak/user-service/internal/problem"
 	"github.com/0Bleak/user-service/internal/repository"
+	"github.com/0Bleak/user-service/internal/requestid"
 	"github.com/0Bleak/user-service/internal/service"
+	"github.com/0Bleak/user-service/internal/shadow"
+	"github.com/0Bleak/user-service/internal/tracing"
+	"github.com/XSAM/otelsql"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 func main() {
@@ -33,11 +40,28 @@ func run() error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// Set up tracing
+	shutdownTracing, err := tracing.Init(context.Background(), "user-service", cfg.ServiceVersion, cfg.OTLPEndpoint, cfg.TraceSampleRatio)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
 	// Connect to PostgreSQL
-	db, err := sqlx.Connect("postgres", cfg.DatabaseURL)
+	sqlDB, err := otelsql.Open("postgres", cfg.DatabaseURL,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
+	db := sqlx.NewDb(sqlDB, "postgres")
 	defer db.Close()
 
 	if err := db.Ping(); err != nil {
@@ -54,14 +78,37 @@ func run() error {
 	userRepo := repository.NewUserRepository(db)
 	userService := service.NewUserService(userRepo, cfg.JWTSecret)
 	userHandler := handlers.NewUserHandler(userService)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, userRepo)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+
+	// Requests are validated against the service's OpenAPI document
+	validator, err := openapi.NewValidator(cfg.ValidateResponses)
+	if err != nil {
+		return fmt.Errorf("failed to load openapi document: %w", err)
+	}
 
 	// Setup router
 	router := mux.NewRouter()
+	router.NotFoundHandler = requestid.Middleware(http.HandlerFunc(problem.RouteNotFound))
+	router.MethodNotAllowedHandler = requestid.Middleware(http.HandlerFunc(problem.MethodNotAllowed))
+	router.Use(tracing.Middleware("user-service"))
+	router.Use(requestid.Middleware)
+	router.Use(shadow.Middleware(cfg.ShadowWrites))
+	router.Use(validator.Middleware)
+	router.HandleFunc("/openapi.json", openapi.ServeSpec).Methods(http.MethodGet)
 	router.HandleFunc("/users/register", userHandler.Register).Methods(http.MethodPost)
 	router.HandleFunc("/users/login", userHandler.Login).Methods(http.MethodPost)
 	router.HandleFunc("/users/me", middleware.AuthMiddleware(userHandler.GetProfile, cfg.JWTSecret)).Methods(http.MethodGet)
+	router.HandleFunc("/users/api-keys", middleware.AdminMiddleware(apiKeyHandler.Create, cfg.JWTSecret)).Methods(http.MethodPost)
+	router.HandleFunc("/users/api-keys", middleware.AdminMiddleware(apiKeyHandler.List, cfg.JWTSecret)).Methods(http.MethodGet)
+	router.HandleFunc("/users/api-keys/{id}", middleware.AdminMiddleware(apiKeyHandler.Revoke, cfg.JWTSecret)).Methods(http.MethodDelete)
 	router.HandleFunc("/health", userHandler.HealthCheck).Methods(http.MethodGet)
 
+	// Called by the gateway only, with the shared internal token; not exposed
+	// through its route table.
+	router.HandleFunc("/internal/api-keys/verify", middleware.InternalMiddleware(apiKeyHandler.Verify, cfg.InternalToken)).Methods(http.MethodPost)
+
 	// Register with Consul
 	consulClient, err := discovery.NewConsulClient(cfg.ConsulAddr)
 	if err != nil {
@@ -69,10 +116,10 @@ func run() error {
 	}
 
 	serviceID := fmt.Sprintf("user-service-%s", cfg.ServiceID)
-	if err := consulClient.RegisterService(serviceID, "user-service", cfg.ServerPort); err != nil {
+	if err := consulClient.RegisterService(serviceID, "user-service", cfg.ServerPort, cfg.ServiceVersion); err != nil {
 		return fmt.Errorf("failed to register service with consul: %w", err)
 	}
-	log.Printf("Registered with Consul as %s", serviceID)
+	log.Printf("Registered with Consul as %s (version %s)", serviceID, cfg.ServiceVersion)
 
 	defer func() {
 		if err := consulClient.DeregisterService(serviceID); err != nil {
@@ -126,6 +173,23 @@ func runMigrations(db *sqlx.DB) error {
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(255) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash CHAR(64) UNIQUE NOT NULL,
+		scopes TEXT[] NOT NULL,
+		requests_per_second DOUBLE PRECISION NOT NULL DEFAULT 0,
+		burst INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		last_used_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
 	`
 
 	_, err := db.Exec(schema)
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/0Bleak/user-service/internal/models"
+	"github.com/0Bleak/user-service/internal/problem"
+	"github.com/0Bleak/user-service/internal/service"
+	"github.com/gorilla/mux"
+)
+
+type APIKeyHandler struct {
+	service service.APIKeyService
+}
+
+func NewAPIKeyHandler(service service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		service: service,
+	}
+}
+
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
+		return
+	}
+
+	created, err := h.service.Create(r.Context(), &req)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+// List returns every key, or the keys of the user given by ?user_id=.
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	var userID int64
+	if value := r.URL.Query().Get("user_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			problem.Write(w, r, problem.Invalid("query.user_id", "Invalid user ID"))
+			return
+		}
+		userID = id
+	}
+
+	keys, err := h.service.List(r.Context(), userID)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		problem.Write(w, r, problem.Invalid("path.id", "Invalid API key ID"))
+		return
+	}
+
+	if err := h.service.Revoke(r.Context(), id); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Verify is called by the gateway to resolve the key of an incoming request.
+func (h *APIKeyHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	var req models.VerifyAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
+		return
+	}
+
+	identity, err := h.service.Verify(r.Context(), req.Key)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, identity)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/0Bleak/user-service/internal/models"
+	"github.com/0Bleak/user-service/internal/problem"
 	"github.com/0Bleak/user-service/internal/service"
 )
 
@@ -21,13 +22,13 @@ func NewUserHandler(service service.UserService) *UserHandler {
 func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req models.RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
 		return
 	}
 
 	user, err := h.service.Register(r.Context(), &req)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -37,13 +38,13 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		problem.Write(w, r, problem.Validation("Invalid request payload"))
 		return
 	}
 
 	token, err := h.service.Login(r.Context(), &req)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -55,7 +56,7 @@ func (h *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.service.GetUserByID(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "User not found")
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -66,10 +67,6 @@ func (h *UserHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
-}
-
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 	w.Header().Set("Content-Type", "application/json")
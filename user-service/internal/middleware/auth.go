@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/0Bleak/user-service/internal/problem"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -14,13 +15,13 @@ func AuthMiddleware(next http.HandlerFunc, jwtSecret string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Missing authorization header", http.StatusUnauthorized)
+			problem.Write(w, r, problem.Unauthorized("Missing authorization header"))
 			return
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+			problem.Write(w, r, problem.Unauthorized("Invalid authorization header"))
 			return
 		}
 
@@ -33,29 +34,43 @@ func AuthMiddleware(next http.HandlerFunc, jwtSecret string) http.HandlerFunc {
 		})
 
 		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			problem.Write(w, r, problem.Unauthorized("Invalid token"))
 			return
 		}
 
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			problem.Write(w, r, problem.Unauthorized("Invalid token claims"))
 			return
 		}
 
 		exp, ok := claims["exp"].(float64)
 		if !ok || time.Unix(int64(exp), 0).Before(time.Now()) {
-			http.Error(w, "Token expired", http.StatusUnauthorized)
+			problem.Write(w, r, problem.Unauthorized("Token expired"))
 			return
 		}
 
 		userID, ok := claims["user_id"].(float64)
 		if !ok {
-			http.Error(w, "Invalid user ID in token", http.StatusUnauthorized)
+			problem.Write(w, r, problem.Unauthorized("Invalid user ID in token"))
 			return
 		}
 
+		role, _ := claims["role"].(string)
+
 		ctx := context.WithValue(r.Context(), "userID", int64(userID))
+		ctx = context.WithValue(ctx, "userRole", role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
+
+// AdminMiddleware only lets through users with the admin role.
+func AdminMiddleware(next http.HandlerFunc, jwtSecret string) http.HandlerFunc {
+	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if role, _ := r.Context().Value("userRole").(string); role != "admin" {
+			problem.Write(w, r, problem.Forbidden("Admin role required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}, jwtSecret)
+}
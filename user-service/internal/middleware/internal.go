@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/0Bleak/user-service/internal/problem"
+)
+
+// InternalTokenHeader carries the token shared with the gateway on calls to
+// the /internal endpoints.
+const InternalTokenHeader = "X-Internal-Token"
+
+// InternalMiddleware only lets through callers presenting the shared internal
+// token. It answers 403 rather than 401, so that the gateway does not take a
+// wrong token for an invalid API key.
+func InternalMiddleware(next http.HandlerFunc, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get(InternalTokenHeader)
+		if given == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			problem.Write(w, r, problem.Forbidden("Internal endpoint"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInternalMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"no token", "", http.StatusForbidden},
+		{"wrong token", "guess", http.StatusForbidden},
+		{"prefix of the token", "internal", http.StatusForbidden},
+		{"shared token", "internal-token", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := InternalMiddleware(func(w http.ResponseWriter, r *http.Request) { called = true }, "internal-token")
+
+			r := httptest.NewRequest(http.MethodPost, "/internal/api-keys/verify", nil)
+			if tt.token != "" {
+				r.Header.Set(InternalTokenHeader, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, r)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if called != (tt.status == http.StatusOK) {
+				t.Fatalf("handler called = %v", called)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/0Bleak/user-service/internal/problem"
+	"github.com/lib/pq"
+)
+
+// APIKey lets a machine client act as the user it was issued for. Only the
+// SHA-256 hash of the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID                int64          `db:"id" json:"id"`
+	UserID            int64          `db:"user_id" json:"user_id"`
+	Name              string         `db:"name" json:"name"`
+	Prefix            string         `db:"prefix" json:"prefix"`
+	KeyHash           string         `db:"key_hash" json:"-"`
+	Scopes            pq.StringArray `db:"scopes" json:"scopes"`
+	RequestsPerSecond float64        `db:"requests_per_second" json:"requests_per_second,omitempty"`
+	Burst             int            `db:"burst" json:"burst,omitempty"`
+	CreatedAt         time.Time      `db:"created_at" json:"created_at"`
+	ExpiresAt         *time.Time     `db:"expires_at" json:"expires_at,omitempty"`
+	RevokedAt         *time.Time     `db:"revoked_at" json:"revoked_at,omitempty"`
+	LastUsedAt        *time.Time     `db:"last_used_at" json:"last_used_at,omitempty"`
+}
+
+// Active reports whether the key is neither revoked nor expired.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// CreateAPIKeyRequest issues a key for a user. Scopes name gateway routes,
+// optionally restricted to one method: "orders" or "orders:GET". A zero rate
+// limit leaves the gateway's route quotas in place.
+type CreateAPIKeyRequest struct {
+	UserID            int64      `json:"user_id"`
+	Name              string     `json:"name"`
+	Scopes            []string   `json:"scopes"`
+	RequestsPerSecond float64    `json:"requests_per_second"`
+	Burst             int        `json:"burst"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+}
+
+// CreatedAPIKey is returned once, when the key is issued; the key itself
+// cannot be retrieved afterwards.
+type CreatedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+type VerifyAPIKeyRequest struct {
+	Key string `json:"key"`
+}
+
+// APIKeyIdentity is what the gateway needs to authorize a request made with a key.
+type APIKeyIdentity struct {
+	KeyID             int64    `json:"key_id"`
+	UserID            int64    `json:"user_id"`
+	Email             string   `json:"email"`
+	Role              string   `json:"role"`
+	Scopes            []string `json:"scopes"`
+	RequestsPerSecond float64  `json:"requests_per_second,omitempty"`
+	Burst             int      `json:"burst,omitempty"`
+}
+
+func (r *CreateAPIKeyRequest) Validate() error {
+	if r.UserID <= 0 {
+		return problem.Invalid("body.user_id", "user id is required")
+	}
+	if r.Name == "" {
+		return problem.Invalid("body.name", "name is required")
+	}
+	if len(r.Scopes) == 0 {
+		return problem.Invalid("body.scopes", "at least one scope is required")
+	}
+	for _, scope := range r.Scopes {
+		route, method, _ := strings.Cut(scope, ":")
+		if route == "" || strings.ContainsAny(scope, " \t") || method != strings.ToUpper(method) {
+			return problem.Invalid("body.scopes", "scopes must be a route name or route:METHOD")
+		}
+	}
+	if r.RequestsPerSecond < 0 {
+		return problem.Invalid("body.requests_per_second", "rate limit cannot be negative")
+	}
+	if r.Burst < 0 {
+		return problem.Invalid("body.burst", "rate limit cannot be negative")
+	}
+	if r.ExpiresAt != nil && r.ExpiresAt.Before(time.Now()) {
+		return problem.Invalid("body.expires_at", "expiry must be in the future")
+	}
+	return nil
+}
@@ -1,8 +1,9 @@
 package models
 
 import (
-	"errors"
 	"time"
+
+	"github.com/0Bleak/user-service/internal/problem"
 )
 
 type User struct {
@@ -28,26 +29,26 @@ type LoginRequest struct {
 
 func (r *RegisterRequest) Validate() error {
 	if r.Email == "" {
-		return errors.New("email is required")
+		return problem.Invalid("body.email", "email is required")
 	}
 	if r.Password == "" {
-		return errors.New("password is required")
+		return problem.Invalid("body.password", "password is required")
 	}
 	if len(r.Password) < 6 {
-		return errors.New("password must be at least 6 characters")
+		return problem.Invalid("body.password", "password must be at least 6 characters")
 	}
 	if r.FullName == "" {
-		return errors.New("full name is required")
+		return problem.Invalid("body.full_name", "full name is required")
 	}
 	return nil
 }
 
 func (r *LoginRequest) Validate() error {
 	if r.Email == "" {
-		return errors.New("email is required")
+		return problem.Invalid("body.email", "email is required")
 	}
 	if r.Password == "" {
-		return errors.New("password is required")
+		return problem.Invalid("body.password", "password is required")
 	}
 	return nil
 }
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/0Bleak/user-service/internal/models"
+	"github.com/0Bleak/user-service/internal/problem"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrAPIKeyNotFound = problem.NotFound("API key not found")
+
+// lastUsedResolution bounds how often verifying a key writes its last-used time.
+const lastUsedResolution = time.Minute
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	List(ctx context.Context, userID int64) ([]*models.APIKey, error)
+	FindByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	Revoke(ctx context.Context, id int64) error
+	TouchLastUsed(ctx context.Context, id int64) error
+}
+
+type apiKeyRepository struct {
+	db *sqlx.DB
+}
+
+func NewAPIKeyRepository(db *sqlx.DB) APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, requests_per_second, burst, created_at, expires_at, revoked_at, last_used_at`
+
+func (r *apiKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, requests_per_second, burst, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+
+	now := time.Now()
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		key.Scopes,
+		key.RequestsPerSecond,
+		key.Burst,
+		now,
+		key.ExpiresAt,
+	).Scan(&key.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", unavailable(err))
+	}
+
+	key.CreatedAt = now
+	return nil
+}
+
+// List returns the keys of a user, or every key when userID is 0, newest first.
+func (r *apiKeyRepository) List(ctx context.Context, userID int64) ([]*models.APIKey, error) {
+	keys := []*models.APIKey{}
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE $1 = 0 OR user_id = $1 ORDER BY created_at DESC`
+
+	if err := r.db.SelectContext(ctx, &keys, query, userID); err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", unavailable(err))
+	}
+
+	return keys, nil
+}
+
+func (r *apiKeyRepository) FindByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	var key models.APIKey
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+
+	err := r.db.GetContext(ctx, &key, query, keyHash)
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find api key: %w", unavailable(err))
+	}
+
+	return &key, nil
+}
+
+// Revoke marks the key revoked. Revoking it again keeps the first revocation time.
+func (r *apiKeyRepository) Revoke(ctx context.Context, id int64) error {
+	query := `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", unavailable(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", unavailable(err))
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// TouchLastUsed records that the key was used, at most once per lastUsedResolution.
+func (r *apiKeyRepository) TouchLastUsed(ctx context.Context, id int64) error {
+	query := `UPDATE api_keys SET last_used_at = $2 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $3)`
+
+	now := time.Now()
+	if _, err := r.db.ExecContext(ctx, query, id, now, now.Add(-lastUsedResolution)); err != nil {
+		return fmt.Errorf("failed to update api key last use: %w", unavailable(err))
+	}
+
+	return nil
+}
@@ -3,13 +3,27 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"time"
 
 	"github.com/0Bleak/user-service/internal/models"
+	"github.com/0Bleak/user-service/internal/problem"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+var (
+	ErrUserNotFound = problem.NotFound("User not found")
+	ErrEmailTaken   = problem.Conflict("Email is already registered")
+)
+
+// uniqueViolation is the PostgreSQL error code for duplicate keys.
+const uniqueViolation = "23505"
+
 type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	FindByEmail(ctx context.Context, email string) (*models.User, error)
@@ -43,8 +57,12 @@ func (r *userRepository) Create(ctx context.Context, user *models.User) error {
 		now,
 	).Scan(&user.ID)
 
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return ErrEmailTaken
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
+		return fmt.Errorf("failed to create user: %w", unavailable(err))
 	}
 
 	user.CreatedAt = now
@@ -58,10 +76,10 @@ func (r *userRepository) FindByEmail(ctx context.Context, email string) (*models
 
 	err := r.db.GetContext(ctx, &user, query, email)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find user: %w", err)
+		return nil, fmt.Errorf("failed to find user: %w", unavailable(err))
 	}
 
 	return &user, nil
@@ -73,11 +91,35 @@ func (r *userRepository) FindByID(ctx context.Context, id int64) (*models.User,
 
 	err := r.db.GetContext(ctx, &user, query, id)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find user: %w", err)
+		return nil, fmt.Errorf("failed to find user: %w", unavailable(err))
 	}
 
 	return &user, nil
 }
+
+// unavailable reports the errors meaning PostgreSQL could not be reached, or
+// did not answer in time, as an unavailable dependency. Other errors, including
+// nil, are returned as they are.
+func unavailable(err error) error {
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &pqErr):
+		// Connection exceptions, too many connections, shutdowns and
+		// cancelled statements.
+		switch pqErr.Code.Class() {
+		case "08", "53", "57":
+		default:
+			return err
+		}
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.As(err, &netErr):
+	default:
+		return err
+	}
+	return problem.Unavailable("Database unavailable", err)
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0Bleak/user-service/internal/models"
+	"github.com/0Bleak/user-service/internal/problem"
+	"github.com/0Bleak/user-service/internal/repository"
+)
+
+// memoryKeys finds keys by hash like the Postgres repository.
+type memoryKeys struct {
+	repository.APIKeyRepository
+	keys     []*models.APIKey
+	err      error
+	touched  []int64
+	touchErr error
+}
+
+func (r *memoryKeys) FindByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, key := range r.keys {
+		if key.KeyHash == keyHash {
+			return key, nil
+		}
+	}
+	return nil, repository.ErrAPIKeyNotFound
+}
+
+func (r *memoryKeys) TouchLastUsed(ctx context.Context, id int64) error {
+	r.touched = append(r.touched, id)
+	return r.touchErr
+}
+
+type memoryUsers struct {
+	repository.UserRepository
+	users map[int64]*models.User
+}
+
+func (r *memoryUsers) FindByID(ctx context.Context, id int64) (*models.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func TestVerify(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	outage := problem.Unavailable("Database unavailable", errors.New("connection refused"))
+
+	newKey := func(id, userID int64, key string) *models.APIKey {
+		return &models.APIKey{
+			ID:                id,
+			UserID:            userID,
+			KeyHash:           hashAPIKey(key),
+			Scopes:            []string{"orders:GET"},
+			RequestsPerSecond: 5,
+			Burst:             10,
+		}
+	}
+	revoked := newKey(2, 42, "cj_revoked")
+	revoked.RevokedAt = &past
+	expired := newKey(3, 42, "cj_expired")
+	expired.ExpiresAt = &past
+	expiring := newKey(4, 42, "cj_expiring")
+	expiring.ExpiresAt = &future
+
+	keys := []*models.APIKey{newKey(1, 42, "cj_active"), revoked, expired, expiring, newKey(5, 99, "cj_orphan")}
+	users := map[int64]*models.User{42: {ID: 42, Email: "buyer@example.com", Role: "customer"}}
+
+	tests := []struct {
+		name     string
+		key      string
+		repoErr  error
+		touchErr error
+		wantKey  int64
+		wantErr  error
+	}{
+		{name: "active key", key: "cj_active", wantKey: 1},
+		{name: "key not expired yet", key: "cj_expiring", wantKey: 4},
+		{name: "failing to record use is ignored", key: "cj_active", touchErr: errors.New("timeout"), wantKey: 1},
+		{name: "without the prefix", key: "active", wantErr: ErrInvalidAPIKey},
+		{name: "unknown key", key: "cj_unknown", wantErr: ErrInvalidAPIKey},
+		{name: "revoked key", key: "cj_revoked", wantErr: ErrInvalidAPIKey},
+		{name: "expired key", key: "cj_expired", wantErr: ErrInvalidAPIKey},
+		{name: "key of a deleted user", key: "cj_orphan", wantErr: ErrInvalidAPIKey},
+		// An outage must not look like an invalid key, or the gateway would
+		// cache it as one.
+		{name: "key store outage", key: "cj_active", repoErr: outage, wantErr: outage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memoryKeys{keys: keys, err: tt.repoErr, touchErr: tt.touchErr}
+			service := NewAPIKeyService(repo, &memoryUsers{users: users})
+
+			identity, err := service.Verify(context.Background(), tt.key)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+				}
+				if len(repo.touched) != 0 {
+					t.Fatalf("rejected key recorded as used: %v", repo.touched)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+
+			want := models.APIKeyIdentity{
+				KeyID:             tt.wantKey,
+				UserID:            42,
+				Email:             "buyer@example.com",
+				Role:              "customer",
+				RequestsPerSecond: 5,
+				Burst:             10,
+			}
+			if identity.KeyID != want.KeyID || identity.UserID != want.UserID || identity.Email != want.Email ||
+				identity.Role != want.Role || identity.RequestsPerSecond != want.RequestsPerSecond || identity.Burst != want.Burst {
+				t.Fatalf("identity = %+v, want %+v", identity, want)
+			}
+			if len(identity.Scopes) != 1 || identity.Scopes[0] != "orders:GET" {
+				t.Fatalf("scopes = %v, want [orders:GET]", identity.Scopes)
+			}
+			if len(repo.touched) != 1 || repo.touched[0] != tt.wantKey {
+				t.Fatalf("recorded use of %v, want [%d]", repo.touched, tt.wantKey)
+			}
+		})
+	}
+}
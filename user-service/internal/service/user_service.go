@@ -2,15 +2,19 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/0Bleak/user-service/internal/models"
+	"github.com/0Bleak/user-service/internal/problem"
 	"github.com/0Bleak/user-service/internal/repository"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrInvalidCredentials = problem.Unauthorized("Invalid credentials")
+
 type UserService interface {
 	Register(ctx context.Context, req *models.RegisterRequest) (*models.User, error)
 	Login(ctx context.Context, req *models.LoginRequest) (string, error)
@@ -35,9 +39,10 @@ func (s *userService) Register(ctx context.Context, req *models.RegisterRequest)
 	}
 
 	// Check if user exists
-	existingUser, _ := s.repo.FindByEmail(ctx, req.Email)
-	if existingUser != nil {
-		return nil, fmt.Errorf("user with email %s already exists", req.Email)
+	if _, err := s.repo.FindByEmail(ctx, req.Email); err == nil {
+		return nil, repository.ErrEmailTaken
+	} else if !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
 	}
 
 	// Hash password
@@ -66,12 +71,15 @@ func (s *userService) Login(ctx context.Context, req *models.LoginRequest) (stri
 	}
 
 	user, err := s.repo.FindByEmail(ctx, req.Email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return "", ErrInvalidCredentials
+	}
 	if err != nil {
-		return "", fmt.Errorf("invalid credentials")
+		return "", err
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		return "", fmt.Errorf("invalid credentials")
+		return "", ErrInvalidCredentials
 	}
 
 	// Generate JWT token